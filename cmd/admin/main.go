@@ -0,0 +1,90 @@
+// Command admin runs maintenance tasks against the database and storage of
+// the service. It reads the same configuration as the API server.
+//
+// Usage:
+//
+//	admin <command> [flags]
+package main
+
+import (
+	"context"
+	"fileupload/config"
+	"fileupload/pkg/logger"
+	"fileupload/pkg/minio"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// app holds what commands need to run
+type app struct {
+	cfg *config.Config
+	db  *gorm.DB
+}
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = []command{
+	{"rewrap", "wrap every data key with the active master key", runRewrap},
+	{"migrate-storage", "copy stored content between local disk and MinIO", runMigrateStorage},
+	{"scrub", "check stored content against the database", runScrub},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		logger.Log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := logger.Setup(logger.Options{Level: cfg.Settings().LogLevel, Format: cfg.LogFormat}); err != nil {
+		logger.Log.Fatalf("Failed to configure logger: %v", err)
+	}
+	if cfg.EnabledMinio {
+		minio.Init(cfg.MinioEndpoint, cfg.MinioAccessKey, cfg.MinioSecretKey, cfg.MinioUseSSL)
+	}
+
+	db, err := gorm.Open(postgres.Open(cfg.DBConnection), &gorm.Config{})
+	if err != nil {
+		logger.Log.Fatal("Failed to connect to database: ", err)
+	}
+
+	// An interrupted command stops between records and can be run again
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd.run(ctx, &app{cfg: cfg, db: db}, os.Args[2:]); err != nil {
+		logger.Log.Errorf("%s failed: %v", cmd.name, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: admin <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", cmd.name, cmd.summary)
+	}
+}
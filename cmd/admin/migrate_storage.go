@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/internal/usecase"
+	"flag"
+	"fmt"
+)
+
+// runMigrateStorage copies the content of files and file versions to another
+// storage backend and records the backend on each record. Reads fall back
+// from local disk to MinIO, so content stays available throughout; with
+// -delete-source the source copy is only removed once the target copy has
+// been verified and recorded.
+func runMigrateStorage(ctx context.Context, a *app, args []string) error {
+	flags := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	target := flags.String("to", entity.BackendMinio, `backend to migrate to, "minio" or "local"`)
+	concurrency := flags.Int("concurrency", 4, "number of records migrated at once")
+	dryRun := flags.Bool("dry-run", false, "only report what would be migrated")
+	deleteSource := flags.Bool("delete-source", false, "remove the source copy once the target copy is verified")
+	flags.Parse(args)
+
+	migrationUseCase := usecase.NewStorageMigrationUseCase(repository.NewStorageRepository(a.db), a.cfg)
+	result, err := migrationUseCase.Migrate(ctx, usecase.MigrateOptions{
+		Target:       *target,
+		Concurrency:  *concurrency,
+		DryRun:       *dryRun,
+		DeleteSource: *deleteSource,
+	})
+	if result != nil {
+		if *dryRun {
+			fmt.Printf("would copy %d records (%d bytes), %d already on %s, %d missing or unreadable\n",
+				result.Copied, result.Bytes, result.Reused, *target, result.Failed)
+		} else {
+			fmt.Printf("copied %d records (%d bytes), reused %d existing copies, skipped %d, failed %d\n",
+				result.Copied, result.Bytes, result.Reused, result.Skipped, result.Failed)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 && !*dryRun {
+		return fmt.Errorf("%d records could not be migrated", result.Failed)
+	}
+	if result.Skipped > 0 {
+		fmt.Println("some records changed while running; run migrate-storage again")
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fileupload/internal/repository"
+	"fileupload/internal/usecase"
+	"flag"
+	"fmt"
+)
+
+// runRewrap wraps the data keys of all encrypted content with the active
+// master key. To rotate master keys, add the new key, make it active,
+// restart the API, run rewrap until it reports nothing left to do, and only
+// then remove the old key.
+func runRewrap(ctx context.Context, a *app, args []string) error {
+	flags := flag.NewFlagSet("rewrap", flag.ExitOnError)
+	flags.Parse(args)
+
+	encryptionUseCase := usecase.NewEncryptionUseCase(repository.NewEncryptionRepository(a.db), a.cfg)
+	result, err := encryptionUseCase.Rewrap(ctx)
+	if result != nil {
+		fmt.Printf("re-wrapped %d data keys, skipped %d, failed %d\n", result.Rewrapped, result.Skipped, result.Failed)
+	}
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d data keys could not be re-wrapped", result.Failed)
+	}
+	if result.Skipped > 0 {
+		fmt.Println("some records changed while running; run rewrap again")
+	}
+	return nil
+}
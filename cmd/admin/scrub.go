@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fileupload/internal/repository"
+	"fileupload/internal/usecase"
+	"flag"
+	"fmt"
+)
+
+// runScrub checks stored content against the database, like the scheduled
+// scrub of the API, and prints every issue found. It fails when an issue is
+// left unfixed, so that it can be run from a scheduler that alerts on
+// failure.
+func runScrub(ctx context.Context, a *app, args []string) error {
+	flags := flag.NewFlagSet("scrub", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "restore missing or corrupt copies from a valid copy on the other backend")
+	quarantine := flags.Bool("quarantine", false, "move orphaned content to the quarantine directory or prefix")
+	flags.Parse(args)
+
+	scrubUseCase := usecase.NewScrubUseCase(repository.NewStorageRepository(a.db), a.cfg)
+	report, err := scrubUseCase.Scrub(ctx, usecase.ScrubOptions{Repair: *repair, Quarantine: *quarantine})
+	if report == nil {
+		return err
+	}
+
+	unfixed := 0
+	for _, issue := range report.Issues {
+		line := fmt.Sprintf("%-8s %-6s %s", issue.Problem, issue.Backend, issue.Location)
+		if issue.Kind != "" {
+			line += fmt.Sprintf(" (%s %s)", issue.Kind, issue.ID)
+		}
+		if issue.Detail != "" {
+			line += ": " + issue.Detail
+		}
+		if issue.Fixed {
+			line += " [fixed]"
+		} else {
+			unfixed++
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("checked %d records, found %d issues, %d left unfixed\n", report.Checked, len(report.Issues), unfixed)
+
+	if err != nil {
+		return err
+	}
+	if unfixed > 0 {
+		return fmt.Errorf("%d integrity issues left unfixed", unfixed)
+	}
+	return nil
+}
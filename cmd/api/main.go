@@ -6,12 +6,13 @@ import (
 	"fileupload/internal/delivery/http/route"
 	"fileupload/internal/repository"
 	"fileupload/internal/usecase"
+	"fileupload/pkg/lifecycle"
 	"fileupload/pkg/logger"
 	"fileupload/pkg/minio"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -22,17 +23,55 @@ import (
 
 func main() {
 	// Load configuration
-	cfg := config.LoadConfig()
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		logger.Log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Configure logging before anything else writes to it
+	if err := logger.Setup(logger.Options{
+		Level:      cfg.Settings().LogLevel,
+		Format:     cfg.LogFormat,
+		File:       cfg.LogFile,
+		MaxSizeMB:  cfg.LogMaxSizeMB,
+		MaxBackups: cfg.LogMaxBackups,
+	}); err != nil {
+		logger.Log.Fatalf("Failed to configure logger: %v", err)
+	}
+	if err := logger.SetupUpload(logger.Options{
+		Level:      cfg.Settings().LogLevel,
+		Format:     cfg.LogFormat,
+		File:       cfg.UploadLogFile,
+		MaxSizeMB:  cfg.LogMaxSizeMB,
+		MaxBackups: cfg.LogMaxBackups,
+	}); err != nil {
+		logger.Log.Fatalf("Failed to configure upload logger: %v", err)
+	}
 
 	logger.Log.Info("Aplikasi dimulai")
-	minio.Init(cfg.MinioEndpoint, cfg.MinioAccessKey, cfg.MinioSecretKey, cfg.MinioUseSSL)
+	if len(cfg.APIKeys) == 0 {
+		logger.Log.Warn("No API keys configured; requests are not authenticated and share the anonymous owner")
+	}
+	if cfg.EnabledMinio {
+		minio.Init(cfg.MinioEndpoint, cfg.MinioAccessKey, cfg.MinioSecretKey, cfg.MinioUseSSL)
+	}
 
 	db, err := gorm.Open(postgres.Open(cfg.DBConnection), &gorm.Config{})
 	if err != nil {
 		logger.Log.Fatal("Failed to connect to database: ", err)
 	}
 
-	db.AutoMigrate(&repository.UploadModel{}, &repository.FileModel{})
+	db.AutoMigrate(
+		&repository.UploadModel{},
+		&repository.FileModel{},
+		&repository.IdempotencyModel{},
+		&repository.FolderModel{},
+		&repository.FileVersionModel{},
+		&repository.ShareLinkModel{},
+		&repository.ShareAccessModel{},
+		&repository.ArchiveJobModel{},
+		&repository.RateLimitBucketModel{},
+	)
 
 	if err := os.MkdirAll(cfg.UploadTempDir, os.ModePerm); err != nil {
 		logger.Log.Fatalf("Failed to create temporary upload directory: %v", err)
@@ -43,15 +82,50 @@ func main() {
 
 	// Initialize repositories
 	fileRepo := repository.NewFileRepository(db)
+	if backfilled, err := fileRepo.BackfillFileVersions(context.Background()); err != nil {
+		logger.Log.Fatalf("Failed to backfill file versions: %v", err)
+	} else if backfilled > 0 {
+		logger.Log.Infof("Recorded %d files created before versioning as version 1", backfilled)
+	}
+	idempotencyRepo := repository.NewIdempotencyRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	shareRepo := repository.NewShareRepository(db)
+	archiveRepo := repository.NewArchiveRepository(db)
+	rateLimitRepo := repository.NewMemoryRateLimitRepository(cfg.RateLimitMaxBuckets)
+	if cfg.RateLimitStore == "postgres" {
+		rateLimitRepo = repository.NewRateLimitRepository(db)
+	}
 
 	// Initialize use cases
-	fileUseCase := usecase.NewFileUseCase(fileRepo, cfg)
+	fileUseCase := usecase.NewFileUseCase(fileRepo, folderRepo, cfg)
+	healthUseCase := usecase.NewHealthUseCase(fileRepo, cfg)
+	idempotencyUseCase := usecase.NewIdempotencyUseCase(idempotencyRepo, cfg)
+	folderUseCase := usecase.NewFolderUseCase(folderRepo, fileRepo, cfg)
+	shareUseCase := usecase.NewShareUseCase(shareRepo, fileUseCase)
+	archiveUseCase := usecase.NewArchiveUseCase(archiveRepo, fileRepo, folderRepo, cfg)
+	rateLimitUseCase := usecase.NewRateLimitUseCase(rateLimitRepo, cfg)
+	scrubUseCase := usecase.NewScrubUseCase(repository.NewStorageRepository(db), cfg)
+
+	// Complete finalizations interrupted by a crash before taking traffic
+	if err := fileUseCase.RecoverFinalizations(context.Background()); err != nil {
+		logger.Log.Errorf("Failed to recover interrupted finalizations: %v", err)
+	}
+	if err := fileUseCase.RecoverImports(context.Background()); err != nil {
+		logger.Log.Errorf("Failed to recover interrupted URL imports: %v", err)
+	}
+	if err := archiveUseCase.RecoverArchiveJobs(context.Background()); err != nil {
+		logger.Log.Errorf("Failed to recover interrupted archive jobs: %v", err)
+	}
 
 	// Setup Gin
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.Recovery())
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Log.Fatalf("Invalid trusted proxies: %v", err)
+	}
 
 	// Register routes
-	route.SetupRoutes(r, fileUseCase)
+	route.SetupRoutes(r, fileUseCase, healthUseCase, idempotencyUseCase, folderUseCase, shareUseCase, archiveUseCase, rateLimitUseCase, cfg)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -61,9 +135,57 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Server is running on port %s", cfg.ServerPort)
+		logger.Log.Infof("Server is running on port %s", cfg.ServerPort)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			logger.Log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Components are stopped in registration order: first the HTTP server
+	// stops accepting connections, then in-flight uploads drain, and only
+	// then are the resources they depend on released
+	shutdown := lifecycle.NewManager()
+	shutdown.Register("http-server", server.Shutdown)
+	shutdown.Register("uploads", fileUseCase.Drain)
+	shutdown.Register("archives", archiveUseCase.Drain)
+	// Work abandoned by a crashed instance is only recovered once its lease
+	// has expired, which may well be after startup
+	shutdown.Register("finalize-recovery", lifecycle.Periodic(usecase.RecoveryInterval, func(ctx context.Context) {
+		if err := fileUseCase.RecoverFinalizations(ctx); err != nil {
+			logger.Log.Errorf("Failed to recover interrupted finalizations: %v", err)
+		}
+	}))
+	shutdown.Register("import-recovery", lifecycle.Periodic(usecase.RecoveryInterval, func(ctx context.Context) {
+		if err := fileUseCase.RecoverImports(ctx); err != nil {
+			logger.Log.Errorf("Failed to recover interrupted URL imports: %v", err)
+		}
+	}))
+	shutdown.Register("archive-recovery", lifecycle.Periodic(usecase.RecoveryInterval, func(ctx context.Context) {
+		if err := archiveUseCase.RecoverArchiveJobs(ctx); err != nil {
+			logger.Log.Errorf("Failed to recover interrupted archive jobs: %v", err)
+		}
+	}))
+	shutdown.Register("idempotency-purge", lifecycle.Periodic(time.Hour, idempotencyUseCase.PurgeExpired))
+	shutdown.Register("version-prune", lifecycle.Periodic(time.Hour, fileUseCase.PruneVersions))
+	shutdown.Register("archive-purge", lifecycle.Periodic(time.Hour, archiveUseCase.PurgeExpired))
+	shutdown.Register("rate-limit-purge", lifecycle.Periodic(10*time.Minute, rateLimitUseCase.PurgeIdle))
+	if cfg.ScrubInterval > 0 {
+		shutdown.Register("integrity-scrub", lifecycle.Periodic(cfg.ScrubInterval, scrubUseCase.ScheduledScrub))
+	}
+	shutdown.Register("database", func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.Close()
+	})
+
+	// Apply changes to the config file on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			reloadConfig(cfg)
 		}
 	}()
 
@@ -71,14 +193,39 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	logger.Log.Info("Shutting down server...")
+
+	// Report not-ready so that no new traffic is routed to this instance,
+	// and refuse new uploads while the in-flight ones finish
+	healthUseCase.SetShuttingDown()
+	fileUseCase.BeginShutdown()
+	archiveUseCase.BeginShutdown()
 
 	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	if err := shutdown.Shutdown(ctx); err != nil {
+		logger.Log.Errorf("Server forced to shutdown: %v", err)
+		os.Exit(1)
 	}
 
-	log.Println("Server exited")
+	logger.Log.Info("Server exited")
+}
+
+// reloadConfig applies the runtime settings of a changed config file. An
+// invalid file is reported and the current configuration is kept.
+func reloadConfig(cfg *config.Config) {
+	restartRequired, err := cfg.Reload()
+	if err != nil {
+		logger.Log.Errorf("Failed to reload configuration, keeping the current one: %v", err)
+		return
+	}
+	if err := logger.SetLevel(cfg.Settings().LogLevel); err != nil {
+		logger.Log.Errorf("Failed to apply log level: %v", err)
+	}
+	if len(restartRequired) > 0 {
+		logger.Log.Warnf("Configuration reloaded; changes to %s take effect after a restart", strings.Join(restartRequired, ", "))
+		return
+	}
+	logger.Log.Info("Configuration reloaded")
 }
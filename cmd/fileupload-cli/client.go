@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAttempts is the number of times a request that failed for a
+	// transient reason is sent before giving up
+	maxAttempts = 5
+	// maxRetryDelay bounds the wait between attempts, including one asked
+	// for by a Retry-After header
+	maxRetryDelay = 30 * time.Second
+)
+
+// client calls the HTTP API of the service with the API key of one owner
+type client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+func newClient(baseURL, apiKey string) *client {
+	return &client{
+		baseURL: strings.TrimRight(baseURL, "/") + "/api",
+		apiKey:  apiKey,
+		http:    &http.Client{},
+	}
+}
+
+// keyID identifies the API key of the client without revealing it, so that
+// it can be written to the state file
+func (c *client) keyID() string {
+	if c.apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(c.apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// apiError is an error answered by the server
+type apiError struct {
+	Status     int
+	Message    string
+	retryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.Status)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+}
+
+// isNotFound reports whether err is a 404 answered by the server
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+// request describes a call to the API. Body is created anew for each
+// attempt, so that a failed request can be sent again.
+type request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   func() io.Reader
+	Length int64
+}
+
+// do sends a request, retrying network errors, 429s and 5xx answers with a
+// growing delay, and returns the response of the first attempt that was not
+// one. Any other error status is returned as an *apiError.
+func (c *client) do(ctx context.Context, req request) (*http.Response, error) {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+		if err == nil {
+			err = readAPIError(resp)
+		}
+
+		var apiErr *apiError
+		transient := !errors.As(err, &apiErr) ||
+			apiErr.Status == http.StatusTooManyRequests || apiErr.Status >= http.StatusInternalServerError
+		if !transient || attempt == maxAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := delay
+		if apiErr != nil && apiErr.retryAfter > 0 {
+			wait = apiErr.retryAfter
+		}
+		select {
+		case <-time.After(min(wait, maxRetryDelay)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (c *client) send(ctx context.Context, req request) (*http.Response, error) {
+	var body io.Reader
+	if req.Body != nil {
+		body = req.Body()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, c.baseURL+req.Path, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.Header {
+		httpReq.Header[key] = values
+	}
+	if req.Length > 0 {
+		httpReq.ContentLength = req.Length
+	}
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return c.http.Do(httpReq)
+}
+
+// readAPIError turns an error response into an *apiError and closes it
+func readAPIError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	apiErr := &apiError{Status: resp.StatusCode}
+	var body struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) == nil {
+		apiErr.Message = body.Error
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.retryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// doJSON sends a request with an optional JSON body and decodes the JSON
+// answer into out, unless it is nil
+func (c *client) doJSON(ctx context.Context, method, path string, in, out any) error {
+	req := request{Method: method, Path: path}
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		req.Header = http.Header{"Content-Type": {"application/json"}}
+		req.Body = func() io.Reader { return bytes.NewReader(data) }
+	}
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return decodeJSON(resp, out)
+}
+
+// decodeJSON decodes the JSON body of a response into out
+func decodeJSON(resp *http.Response, out any) error {
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s %s: %w", resp.Request.Method, resp.Request.URL.Path, err)
+	}
+	return nil
+}
+
+// fileInfo is a file as answered by the API
+type fileInfo struct {
+	ID        string            `json:"file_id"`
+	Name      string            `json:"file_name"`
+	FolderID  *string           `json:"folder_id"`
+	Size      int64             `json:"size"`
+	MimeType  string            `json:"mime_type"`
+	Checksum  string            `json:"checksum"`
+	Metadata  map[string]string `json:"metadata"`
+	Tags      []string          `json:"tags"`
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// uploadStatus is the state of an upload as answered by the API
+type uploadStatus struct {
+	ID           string `json:"upload_id"`
+	Status       string `json:"status"`
+	UploadedSize int64  `json:"uploaded_size"`
+	TotalSize    int64  `json:"total_size"`
+	Error        string `json:"error"`
+}
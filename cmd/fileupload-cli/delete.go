@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// runDelete deletes files, with all their versions. Every file is attempted
+// even if an earlier one fails.
+func runDelete(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	connect := clientFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: fileupload-cli delete [flags] <file-id>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no file ID given")
+	}
+	c := connect()
+
+	failed := 0
+	for _, fileID := range flags.Args() {
+		if err := c.doJSON(ctx, http.MethodDelete, "/files/"+fileID, nil, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to delete %s: %v\n", fileID, err)
+			failed++
+			continue
+		}
+		fmt.Printf("deleted %s\n", fileID)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files could not be deleted", failed, flags.NArg())
+	}
+	return nil
+}
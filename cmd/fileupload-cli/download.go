@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// runDownload downloads the current or an earlier version of a file and
+// checks it against the checksum sent by the server. The file is written
+// next to its destination and only renamed into place once verified.
+func runDownload(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	connect := clientFlags(flags)
+	output := flags.String("o", "", "destination file or directory (default: the file name in the current directory)")
+	version := flags.Int("version", 0, "version to download (default: the current one)")
+	quiet := flags.Bool("quiet", false, "do not show progress")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: fileupload-cli download [flags] <file-id>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected one file ID")
+	}
+	fileID := flags.Arg(0)
+	c := connect()
+
+	var file fileInfo
+	if err := c.doJSON(ctx, http.MethodGet, "/files/"+fileID, nil, &file); err != nil {
+		return fmt.Errorf("failed to get file %s: %w", fileID, err)
+	}
+
+	dest := *output
+	if info, err := os.Stat(dest); dest == "" || (err == nil && info.IsDir()) {
+		dest = filepath.Join(dest, filepath.Base(file.Name))
+	}
+
+	downloadPath := "/files/" + fileID + "/download"
+	if *version > 0 {
+		downloadPath = "/files/" + fileID + "/versions/" + strconv.Itoa(*version) + "/download"
+	}
+	resp, err := c.do(ctx, request{Method: http.MethodGet, Path: downloadPath})
+	if err != nil {
+		return fmt.Errorf("failed to download file %s: %w", fileID, err)
+	}
+	defer resp.Body.Close()
+
+	// The size of an earlier version is only known from the response, which
+	// has none when it is compressed
+	total := file.Size
+	if *version > 0 {
+		total = max(resp.ContentLength, 0)
+	}
+	prog := newProgress(total, 1, *quiet)
+	sum, written, err := writeDownload(dest, resp.Body, prog)
+	prog.finish()
+	if err != nil {
+		return err
+	}
+
+	expected := resp.Header.Get("X-Checksum-SHA256")
+	if expected == "" {
+		fmt.Printf("downloaded %s (%s), checksum not verified: the server has none\n", dest, formatSize(written))
+		return nil
+	}
+	if sum != expected {
+		os.Remove(dest)
+		return fmt.Errorf("checksum mismatch: downloaded %s, expected %s", sum, expected)
+	}
+	fmt.Printf("downloaded %s (%s, sha256 %s)\n", dest, formatSize(written), sum)
+	return nil
+}
+
+// writeDownload writes src to dest through a temporary file and returns the
+// checksum and size of what was written
+func writeDownload(dest string, src io.Reader, prog *progress) (string, int64, error) {
+	partPath := dest + ".part"
+	out, err := os.Create(partPath)
+	if err != nil {
+		return "", 0, err
+	}
+
+	sum := sha256.New()
+	written, err := io.Copy(io.MultiWriter(out, sum, progressWriter{prog}), src)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partPath, dest)
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return "", 0, err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), written, nil
+}
+
+// progressWriter counts the bytes written through it as transferred
+type progressWriter struct {
+	prog *progress
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	w.prog.add(int64(len(p)))
+	return len(p), nil
+}
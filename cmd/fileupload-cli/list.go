@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runList prints a page of files, optionally filtered by tags and metadata
+func runList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	connect := clientFlags(flags)
+	page := flags.Int("page", 1, "page number")
+	pageSize := flags.Int("page-size", 20, "files per page (max 100)")
+	var tags listFlag
+	flags.Var(&tags, "tag", "only files having this tag (repeatable, or comma-separated)")
+	metadata := mapFlag{}
+	flags.Var(metadata, "metadata", "only files whose metadata has key=value (repeatable)")
+	flags.Parse(args)
+
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(*page))
+	query.Set("page_size", strconv.Itoa(*pageSize))
+	for _, tag := range tags {
+		query.Add("tag", tag)
+	}
+	for key, value := range metadata {
+		query.Set("metadata["+key+"]", value)
+	}
+
+	var list struct {
+		Files    []fileInfo `json:"files"`
+		Total    int64      `json:"total"`
+		Page     int        `json:"page"`
+		PageSize int        `json:"page_size"`
+	}
+	if err := connect().doJSON(ctx, http.MethodGet, "/files?"+query.Encode(), nil, &list); err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE ID\tSIZE\tVERSION\tUPDATED\tNAME\tTAGS")
+	for _, file := range list.Files {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", file.ID, formatSize(file.Size), file.Version,
+			file.UpdatedAt.Local().Format(time.DateTime), file.Name, strings.Join(file.Tags, ","))
+	}
+	w.Flush()
+
+	pages := int64(1)
+	if list.PageSize > 0 {
+		pages = max((list.Total+int64(list.PageSize)-1)/int64(list.PageSize), 1)
+	}
+	fmt.Printf("page %d of %d, %d files\n", list.Page, pages, list.Total)
+	return nil
+}
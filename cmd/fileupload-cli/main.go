@@ -0,0 +1,142 @@
+// Command fileupload-cli uploads, downloads, lists and deletes files through
+// the HTTP API of the service. Uploads use the chunked /api/uploads protocol
+// and resume where they stopped when run again.
+//
+// The server and API key are taken from the FILEUPLOAD_SERVER and
+// FILEUPLOAD_API_KEY environment variables, or the -server and -api-key flags
+// of each command.
+//
+// Usage:
+//
+//	fileupload-cli <command> [flags] [arguments]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"upload", "upload files or directories in chunks, resuming earlier attempts", runUpload},
+	{"download", "download a file and verify its checksum", runDownload},
+	{"list", "list files", runList},
+	{"delete", "delete files", runDelete},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	// An interrupted upload keeps its state and resumes on the next run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd.run(ctx, os.Args[2:]); err != nil {
+		if errors.Is(err, context.Canceled) {
+			err = errors.New("interrupted")
+		}
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", cmd.name, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: fileupload-cli <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+// clientFlags adds the flags selecting the server and API key to flags and
+// returns a function building the client once they are parsed
+func clientFlags(flags *flag.FlagSet) func() *client {
+	server := flags.String("server", envOr("FILEUPLOAD_SERVER", "http://localhost:8080"), "base URL of the API server")
+	apiKey := flags.String("api-key", os.Getenv("FILEUPLOAD_API_KEY"), "API key of the owner (prefer FILEUPLOAD_API_KEY, which is not visible to other users)")
+	return func() *client {
+		return newClient(*server, *apiKey)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// listFlag is a flag that can be repeated; each value may also hold several
+// comma-separated items
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// mapFlag is a repeatable key=value flag
+type mapFlag map[string]string
+
+func (m mapFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for key, value := range m {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m mapFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	m[key] = val
+	return nil
+}
+
+// formatSize renders a number of bytes with a binary unit
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
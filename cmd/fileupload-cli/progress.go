@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn
+const progressInterval = 500 * time.Millisecond
+
+// progress draws the bytes and files transferred so far on a single line of
+// stderr. Nothing is drawn when stderr is not a terminal, so that the output
+// of scripted runs holds only the result lines.
+type progress struct {
+	total      int64
+	files      int
+	done       atomic.Int64
+	filesDone  atomic.Int64
+	start      time.Time
+	enabled    bool
+	mu         sync.Mutex
+	stop       chan struct{}
+	wg         sync.WaitGroup
+	lineLength int
+}
+
+func newProgress(total int64, files int, quiet bool) *progress {
+	p := &progress{
+		total:   total,
+		files:   files,
+		start:   time.Now(),
+		enabled: !quiet && isTerminal(os.Stderr),
+		stop:    make(chan struct{}),
+	}
+	if p.enabled {
+		p.wg.Add(1)
+		go p.run()
+	}
+	return p
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progress) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			p.draw()
+			p.mu.Unlock()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// add records n more bytes transferred; n is negative when bytes have to
+// be sent again
+func (p *progress) add(n int64) {
+	p.done.Add(n)
+}
+
+// fileDone records that a file is finished, successfully or not
+func (p *progress) fileDone() {
+	p.filesDone.Add(1)
+}
+
+// println prints a result line to stdout above the progress line
+func (p *progress) println(format string, args ...any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Printf(format+"\n", args...)
+	if p.enabled {
+		p.draw()
+	}
+}
+
+// finish draws the final state of the progress line and stops redrawing it
+func (p *progress) finish() {
+	if !p.enabled {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+func (p *progress) clear() {
+	if p.enabled && p.lineLength > 0 {
+		fmt.Fprintf(os.Stderr, "\r%*s\r", p.lineLength, "")
+		p.lineLength = 0
+	}
+}
+
+func (p *progress) draw() {
+	done := p.done.Load()
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(done) / float64(p.total) * 100
+	}
+	line := fmt.Sprintf("%5.1f%%  %s / %s", percent, formatSize(done), formatSize(p.total))
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		line += fmt.Sprintf("  %s/s", formatSize(int64(float64(done)/elapsed)))
+	}
+	if p.files > 1 {
+		line += fmt.Sprintf("  %d/%d files", p.filesDone.Load(), p.files)
+	}
+
+	p.clear()
+	fmt.Fprint(os.Stderr, line)
+	p.lineLength = len(line)
+}
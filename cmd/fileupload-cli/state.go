@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateEntry is an upload started for a local file. It is only resumed
+// while the file, server and API key are the ones it was started with; the
+// key is only recorded by a fingerprint.
+type stateEntry struct {
+	Server   string    `json:"server"`
+	KeyID    string    `json:"key_id"`
+	UploadID string    `json:"upload_id"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+}
+
+// uploadState is the state file of the upload command: the uploads started
+// and not yet finalized, by absolute path of the local file. It is written
+// after every change so that an interrupted run loses nothing.
+type uploadState struct {
+	path    string
+	mu      sync.Mutex
+	Uploads map[string]*stateEntry `json:"uploads"`
+}
+
+// loadState reads a state file, which need not exist yet
+func loadState(path string) (*uploadState, error) {
+	state := &uploadState{path: path, Uploads: map[string]*stateEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	if state.Uploads == nil {
+		state.Uploads = map[string]*stateEntry{}
+	}
+	return state, nil
+}
+
+func (s *uploadState) get(file string) *stateEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Uploads[file]
+}
+
+func (s *uploadState) put(file string, entry *stateEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Uploads[file] = entry
+	return s.save()
+}
+
+func (s *uploadState) remove(file string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Uploads[file]; !ok {
+		return nil
+	}
+	delete(s.Uploads, file)
+	return s.save()
+}
+
+// save writes the state next to the state file and renames it into place,
+// so that the file is never left half written. The file is removed once no
+// upload is left in it.
+func (s *uploadState) save() error {
+	if len(s.Uploads) == 0 {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove state file: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fileupload/config"
+	"fileupload/pkg/utils"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// maxResyncs is the number of times in a row an upload re-reads its progress
+// from the server after a chunk was refused before giving up
+const maxResyncs = 3
+
+// uploadOptions are the settings shared by every file of an upload run
+type uploadOptions struct {
+	chunkSize int64
+	metadata  map[string]string
+	tags      []string
+}
+
+// uploadJob is one local file to upload and the folder it goes to
+type uploadJob struct {
+	path       string
+	size       int64
+	folderPath string
+}
+
+// runUpload uploads files, and the files of directories, through the chunked
+// upload protocol. Files are uploaded -parallel at a time, each one chunk
+// after the other since the server only accepts chunks in order. Uploads
+// that were interrupted are resumed from the progress recorded by the server,
+// and the checksum of every file is compared with the one computed by the
+// server once it is finalized.
+func runUpload(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	connect := clientFlags(flags)
+	chunkSize := flags.String("chunk-size", "8MB", "size of each chunk, e.g. 512KB or 16MB")
+	parallel := flags.Int("parallel", 4, "number of files uploaded at once")
+	statePath := flags.String("state", ".fileupload-state.json", "file recording the uploads to resume")
+	folderPath := flags.String("folder-path", "", "folder to upload into, e.g. reports/2026 (created as needed)")
+	quiet := flags.Bool("quiet", false, "do not show progress")
+	var tags listFlag
+	flags.Var(&tags, "tag", "tag to add to every file (repeatable, or comma-separated)")
+	metadata := mapFlag{}
+	flags.Var(metadata, "metadata", "key=value metadata to add to every file (repeatable)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: fileupload-cli upload [flags] <file or directory>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no file or directory given")
+	}
+	size, err := config.ParseSize(*chunkSize, 1)
+	if err != nil || size < 1 {
+		return fmt.Errorf("invalid chunk size %q", *chunkSize)
+	}
+	if *parallel < 1 {
+		*parallel = 1
+	}
+	opts := uploadOptions{chunkSize: size, metadata: metadata, tags: tags}
+
+	state, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	jobs, err := collectUploads(flags.Args(), *folderPath, *statePath)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, job := range jobs {
+		total += job.size
+	}
+	c := connect()
+	prog := newProgress(total, len(jobs), *quiet)
+
+	queue := make(chan uploadJob)
+	var failed int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < *parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				file, err := uploadFile(ctx, c, state, prog, job, opts)
+				prog.fileDone()
+				if err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					prog.println("FAILED   %s: %v", job.path, err)
+					continue
+				}
+				prog.println("uploaded %s -> %s (sha256 %s)", job.path, file.ID, file.Checksum)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		select {
+		case queue <- job:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+	prog.finish()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed, run again to resume", failed, len(jobs))
+	}
+	return nil
+}
+
+// collectUploads lists the files to upload. The files of a directory go to a
+// folder named after it, keeping its layout; the state file is left out.
+func collectUploads(args []string, folderPath, statePath string) ([]uploadJob, error) {
+	stateAbs, _ := filepath.Abs(statePath)
+
+	var jobs []uploadJob
+	for _, arg := range args {
+		root, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			jobs = append(jobs, uploadJob{path: root, size: info.Size(), folderPath: folderPath})
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() || p == stateAbs || p == stateAbs+".tmp" {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			folder := path.Join(folderPath, filepath.Base(root), filepath.ToSlash(rel))
+			jobs = append(jobs, uploadJob{path: p, size: info.Size(), folderPath: folder})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+// uploadFile uploads one file, resuming the upload recorded for it in the
+// state file if the server still has it, and returns the stored file
+func uploadFile(ctx context.Context, c *client, state *uploadState, prog *progress, job uploadJob, opts uploadOptions) (*fileInfo, error) {
+	f, err := os.Open(job.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != job.size {
+		return nil, errors.New("file changed while uploading")
+	}
+	if info.Size() == 0 {
+		// There is no chunk to send for an empty file
+		return uploadEmptyFile(ctx, c, job, opts)
+	}
+
+	uploadID, offset, err := resumeUpload(ctx, c, state, job.path, info)
+	if err != nil {
+		return nil, err
+	}
+	if uploadID == "" {
+		if uploadID, err = initiateUpload(ctx, c, job, opts); err != nil {
+			return nil, err
+		}
+		err := state.put(job.path, &stateEntry{
+			Server:   c.baseURL,
+			KeyID:    c.keyID(),
+			UploadID: uploadID,
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The checksum covers every byte the server has, including those sent
+	// by an earlier run
+	sum := sha256.New()
+	if err := hashRange(f, sum, 0, offset); err != nil {
+		return nil, err
+	}
+	prog.add(offset)
+
+	if err := sendChunks(ctx, c, f, sum, prog, uploadID, offset, info.Size(), opts.chunkSize); err != nil {
+		return nil, err
+	}
+
+	var file fileInfo
+	if err := c.doJSON(ctx, http.MethodPost, "/uploads/"+uploadID+"/finalize", nil, &file); err != nil {
+		return nil, fmt.Errorf("failed to finalize upload %s: %w", uploadID, err)
+	}
+	// The upload is over on the server whatever the checksum says
+	if err := state.remove(job.path); err != nil {
+		return nil, err
+	}
+
+	local := hex.EncodeToString(sum.Sum(nil))
+	if file.Checksum != "" && file.Checksum != local {
+		return nil, fmt.Errorf("checksum mismatch: local %s, stored %s (file %s)", local, file.Checksum, file.ID)
+	}
+	file.Checksum = local
+	return &file, nil
+}
+
+// uploadEmptyFile uploads a file without content in a single request. An
+// idempotency key keeps a retried request from storing the file twice.
+func uploadEmptyFile(ctx context.Context, c *client, job uploadJob, opts uploadOptions) (*fileInfo, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if job.folderPath != "" {
+		form.WriteField("folder_path", job.folderPath)
+	}
+	if len(opts.metadata) > 0 {
+		data, err := json.Marshal(opts.metadata)
+		if err != nil {
+			return nil, err
+		}
+		form.WriteField("metadata", string(data))
+	}
+	for _, tag := range opts.tags {
+		form.WriteField("tags", tag)
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`,
+		quoteEscaper.Replace(filepath.Base(job.path))))
+	header.Set("Content-Type", utils.GetMimeType(job.path))
+	if _, err := form.CreatePart(header); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	data := body.Bytes()
+	resp, err := c.do(ctx, request{
+		Method: http.MethodPost,
+		Path:   "/files",
+		Header: http.Header{
+			"Content-Type":    {form.FormDataContentType()},
+			"Idempotency-Key": {uuid.NewString()},
+		},
+		Body:   func() io.Reader { return bytes.NewReader(data) },
+		Length: int64(len(data)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload empty file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var file fileInfo
+	if err := decodeJSON(resp, &file); err != nil {
+		return nil, err
+	}
+	local := hex.EncodeToString(sha256.New().Sum(nil))
+	if file.Checksum != "" && file.Checksum != local {
+		return nil, fmt.Errorf("checksum mismatch: local %s, stored %s (file %s)", local, file.Checksum, file.ID)
+	}
+	file.Checksum = local
+	return &file, nil
+}
+
+// quoteEscaper escapes a file name for a Content-Disposition header the way
+// mime/multipart does
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// resumeUpload returns the upload recorded for a file in the state file and
+// the number of bytes the server already has, or an empty ID when there is
+// none to resume
+func resumeUpload(ctx context.Context, c *client, state *uploadState, filePath string, info os.FileInfo) (string, int64, error) {
+	entry := state.get(filePath)
+	if entry == nil {
+		return "", 0, nil
+	}
+	if entry.Server != c.baseURL || entry.KeyID != c.keyID() ||
+		entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return "", 0, state.remove(filePath)
+	}
+
+	var status uploadStatus
+	err := c.doJSON(ctx, http.MethodGet, "/uploads/"+entry.UploadID, nil, &status)
+	if isNotFound(err) {
+		return "", 0, state.remove(filePath)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get status of upload %s: %w", entry.UploadID, err)
+	}
+
+	switch status.Status {
+	case "failed":
+		return "", 0, state.remove(filePath)
+	case "finalizing", "completed":
+		return entry.UploadID, info.Size(), nil
+	}
+	return entry.UploadID, status.UploadedSize, nil
+}
+
+func initiateUpload(ctx context.Context, c *client, job uploadJob, opts uploadOptions) (string, error) {
+	req := map[string]any{
+		"file_name": filepath.Base(job.path),
+		"file_size": job.size,
+		"mime_type": utils.GetMimeType(job.path),
+	}
+	if job.folderPath != "" {
+		req["folder_path"] = job.folderPath
+	}
+	if len(opts.metadata) > 0 {
+		req["metadata"] = opts.metadata
+	}
+	if len(opts.tags) > 0 {
+		req["tags"] = opts.tags
+	}
+
+	var status uploadStatus
+	if err := c.doJSON(ctx, http.MethodPost, "/uploads", req, &status); err != nil {
+		return "", fmt.Errorf("failed to initiate upload: %w", err)
+	}
+	return status.ID, nil
+}
+
+// sendChunks sends the file from offset on, adding every byte the server
+// accepts to sum. A refused chunk makes it re-read the progress of the
+// upload, since the server may have stored the chunk before the answer was
+// lost.
+func sendChunks(ctx context.Context, c *client, f *os.File, sum hash.Hash, prog *progress, uploadID string, offset, size, chunkSize int64) error {
+	buf := make([]byte, min(chunkSize, size))
+	resyncs := 0
+	for offset < size {
+		chunk := buf[:min(chunkSize, size-offset)]
+		if _, err := f.ReadAt(chunk, offset); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		var status uploadStatus
+		err := sendChunk(ctx, c, uploadID, chunk, offset, size, &status)
+		if err == nil && status.UploadedSize == offset+int64(len(chunk)) {
+			sum.Write(chunk)
+			prog.add(int64(len(chunk)))
+			offset += int64(len(chunk))
+			resyncs = 0
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			err = fmt.Errorf("server has %d bytes after chunk at %d", status.UploadedSize, offset)
+		}
+
+		resyncs++
+		if resyncs > maxResyncs {
+			return err
+		}
+		if err := c.doJSON(ctx, http.MethodGet, "/uploads/"+uploadID, nil, &status); err != nil {
+			return fmt.Errorf("failed to get status of upload %s: %w", uploadID, err)
+		}
+		if status.UploadedSize == offset {
+			return err
+		}
+
+		// The server has more, or less, than what was acknowledged: the sum
+		// is brought to where the server is
+		if status.UploadedSize < offset {
+			sum.Reset()
+			prog.add(-offset)
+			offset = 0
+		}
+		if err := hashRange(f, sum, offset, status.UploadedSize); err != nil {
+			return err
+		}
+		prog.add(status.UploadedSize - offset)
+		offset = status.UploadedSize
+	}
+	return nil
+}
+
+func sendChunk(ctx context.Context, c *client, uploadID string, chunk []byte, offset, size int64, status *uploadStatus) error {
+	end := offset + int64(len(chunk)) - 1
+	resp, err := c.do(ctx, request{
+		Method: http.MethodPut,
+		Path:   "/uploads/" + uploadID + "/chunks",
+		Header: http.Header{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", offset, end, size)},
+		},
+		Body:   func() io.Reader { return bytes.NewReader(chunk) },
+		Length: int64(len(chunk)),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeJSON(resp, status)
+}
+
+// hashRange adds the bytes of f between from and to to sum
+func hashRange(f *os.File, sum hash.Hash, from, to int64) error {
+	if to <= from {
+		return nil
+	}
+	_, err := io.Copy(sum, io.NewSectionReader(f, from, to-from))
+	return err
+}
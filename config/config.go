@@ -1,49 +1,368 @@
 package config
 
 import (
+	"errors"
+	"fileupload/pkg/encryption"
+	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Config is the application configuration. It is built from defaults, then
+// the YAML file named by CONFIG_FILE (see config.example.yaml), then
+// environment variables, each layer overriding the previous one.
+//
+// The exported fields are fixed for the lifetime of the process. Settings
+// that are safe to change while running are read through Settings and are
+// replaced by Reload.
 type Config struct {
 	ServerPort     string
 	DBConnection   string
 	UploadTempDir  string
 	UploadFinalDir string
-	MaxFileSize    int64
 	MinioEndpoint  string
 	MinioAccessKey string
 	MinioSecretKey string
 	MinioUseSSL    bool
 	EnabledMinio   bool
+	MinioBucket    string
+
+	// ShutdownTimeout bounds how long in-flight requests and uploads are
+	// given to finish once a termination signal is received
+	ShutdownTimeout time.Duration
+
+	// TrustedProxies are the addresses or CIDRs of the reverse proxies whose
+	// X-Forwarded-For header gives the client IP. Requests from any other
+	// address are attributed to the address they come from.
+	TrustedProxies []string
+
+	// APIKeys maps every owner to the API key that authenticates it. Without
+	// any, authentication is disabled and every request is anonymous.
+	APIKeys map[string]string
+
+	// IdempotencyTTL is how long a response stored for an Idempotency-Key
+	// is replayed to retries
+	IdempotencyTTL time.Duration
+
+	// File versioning. MaxFileVersions caps how many versions are kept per
+	// file and FileVersionRetention how long they are kept; zero disables
+	// either limit. The current version is never pruned.
+	MaxFileVersions      int
+	FileVersionRetention time.Duration
+
+	// ArchiveTTL is how long archives built in the background are kept
+	ArchiveTTL time.Duration
+
+	// Integrity scrubbing. Every ScrubInterval (zero disables it) stored
+	// content is checked against its records. ScrubRepair restores missing or
+	// corrupt copies from a valid replica and ScrubQuarantine moves orphaned
+	// content, older than ScrubOrphanMinAge, to QuarantineDir (or under
+	// "quarantine/" in MinIO); otherwise both are only reported.
+	ScrubInterval     time.Duration
+	ScrubRepair       bool
+	ScrubQuarantine   bool
+	ScrubOrphanMinAge time.Duration
+	QuarantineDir     string
+
+	// Server-side fetches of remote URLs. The allowlists are in Settings.
+	URLFetchTimeout      time.Duration
+	URLFetchMaxRedirects int
+
+	// RateLimitStore keeps the rate limit buckets: "memory" limits each
+	// instance on its own, "postgres" shares the limits between instances
+	RateLimitStore string
+	// RateLimitMaxBuckets bounds the number of clients the memory store
+	// keeps a bucket for
+	RateLimitMaxBuckets int
+
+	// Encryption at rest. When EncryptionEnabled is set, new content is
+	// encrypted under its own data key, wrapped by the master key
+	// EncryptionActiveKey. EncryptionKeys holds every master key by ID, from
+	// the config file, the environment and EncryptionKeyFile; keys that are
+	// no longer active are kept to read content that was not re-wrapped yet.
+	EncryptionEnabled   bool
+	EncryptionActiveKey string
+	EncryptionKeyFile   string
+	EncryptionKeys      map[string][]byte
+
+	// Logging. The level is in Settings.
+	LogFormat     string
+	LogFile       string
+	UploadLogFile string
+	LogMaxSizeMB  int
+	LogMaxBackups int
+
+	// File is the path of the config file, empty when none is used
+	File string
+
+	settings atomic.Pointer[Settings]
+	keyring  *encryption.Keyring
+}
+
+// Settings are the part of the configuration that Reload may change. A
+// Settings value is never modified once published, so callers should fetch
+// it once per operation and use that snapshot throughout.
+type Settings struct {
+	// Size limits, in bytes. MaxFileSize applies to every upload and is
+	// lowered per upload mode by MaxDirectUploadSize and MaxChunkedUploadSize
+	// and per MIME type ("image/png") or family ("image/*") by MimeSizeLimits.
+	// MaxRequestBodySize caps every request body and MaxChunkSize a single
+	// chunk of a chunked upload. Zero disables a limit, except MaxFileSize.
+	MaxFileSize          int64
+	MaxDirectUploadSize  int64
+	MaxChunkedUploadSize int64
+	MimeSizeLimits       map[string]int64
+	MaxRequestBodySize   int64
+	MaxChunkSize         int64
+
+	// ArchiveMaxFiles caps the number of files in one ZIP archive
+	ArchiveMaxFiles int
+
+	// Limits applied when an uploaded archive is extracted. The total size
+	// is also capped at ExtractMaxRatio times the size of the archive.
+	ExtractMaxFiles     int
+	ExtractMaxTotalSize int64
+	ExtractMaxRatio     int
+
+	// Destinations in private, loopback and other internal ranges are
+	// refused to URL fetches unless listed in these allowlists
+	URLFetchAllowCIDRs []string
+	URLFetchAllowHosts []string
+
+	// Every client may send RateLimitPerMinute requests per minute on
+	// average, in bursts of up to RateLimitBurst; zero disables the limit
+	RateLimitPerMinute int
+	RateLimitBurst     int
+
+	// MaxActiveUploads caps the uploads an owner may have in progress at
+	// once; uploads idle for longer than ActiveUploadIdleTimeout no longer
+	// count. Zero disables either.
+	MaxActiveUploads        int
+	ActiveUploadIdleTimeout time.Duration
+
+	// CORS policy. Origins are "*", exact ("https://app.example.com") or
+	// wildcard subdomains ("https://*.example.com"). "*" cannot be combined
+	// with credentials, which browsers reject.
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSExposedHeaders   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+
+	// Content whose MIME type ("text/csv") or family ("text/*") is listed in
+	// CompressMimeTypes is stored compressed with CompressionAlgorithm, "gzip"
+	// or "zstd"; "none" disables compression. Content is always served and
+	// checksummed as uploaded. Video, audio and PDF content is never
+	// compressed, since it is mostly read by range and compressed content
+	// must be decompressed from its start to reach an offset.
+	CompressionAlgorithm string
+	CompressMimeTypes    []string
+
+	// MinFreeDisk is the free space, in bytes, below which the service
+	// reports itself not ready
+	MinFreeDisk int64
+
+	LogLevel string
+}
+
+// Settings returns the current runtime settings
+func (c *Config) Settings() *Settings {
+	return c.settings.Load()
 }
 
-func LoadConfig() *Config {
+// Keyring returns the master encryption keys, nil when none are configured
+func (c *Config) Keyring() *encryption.Keyring {
+	return c.keyring
+}
+
+// LoadConfig reads the configuration and validates it. All problems found
+// are reported together.
+func LoadConfig() (*Config, error) {
 	err := godotenv.Load()
 	if err != nil {
 		log.Println("Warning: .env file not found")
 	}
 
-	return &Config{
-		ServerPort:     getEnv("SERVER_PORT", "8080"),
-		DBConnection:   getEnv("DB_CONNECTION", "host=localhost user=postgres password=postgres dbname=fileuploader port=5432 sslmode=disable"),
-		UploadTempDir:  getEnv("UPLOAD_TEMP_DIR", "./uploads/temp"),
-		UploadFinalDir: getEnv("UPLOAD_FINAL_DIR", "./uploads/files"),
-		MinioEndpoint:  getEnv("MINIO_ENDPOINT", "localhost:9000"),
-		MinioAccessKey: getEnv("MINIO_ACCESS_KEY", "Q3AM3TQ867SPQQA43P2F"),
-		MinioSecretKey: getEnv("MINIO_SECRET_KEY", "zuf+tfteSls5A6y2sxDzsv8+M+3w=="),
-		MinioUseSSL:    getEnv("MINIO_USE_SSL", "false") == "true",
-		MaxFileSize:    100 * 1024 * 1024, // 100MB default
-		EnabledMinio:   getEnv("ENABLE_MINIO", "false") == "true",
+	cfg, settings, err := load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
 	}
+	cfg.settings.Store(settings)
+	return cfg, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// Reload reads the config file again and applies its Settings. Environment
+// variables keep the values the process started with. Nothing is applied
+// when the new configuration is invalid. Changes to other fields only take
+// effect after a restart; their names are returned so they can be reported.
+func (c *Config) Reload() ([]string, error) {
+	next, settings, err := load(c.File)
+	if err != nil {
+		return nil, err
+	}
+	c.settings.Store(settings)
+
+	var restartRequired []string
+	current, updated := reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		if field.IsExported() && !reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			restartRequired = append(restartRequired, field.Name)
+		}
 	}
-	return value
+	return restartRequired, nil
+}
+
+// load builds and validates a configuration from the defaults, the given
+// config file and the environment
+func load(file string) (*Config, *Settings, error) {
+	cfg, settings := defaults()
+	cfg.File = file
+
+	if file != "" {
+		if err := applyFile(cfg, settings, file); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var errs []error
+	applyEnv(cfg, settings, &errs)
+	errs = append(errs, validate(cfg, settings)...)
+	if err := loadKeyring(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return cfg, settings, nil
+}
+
+func defaults() (*Config, *Settings) {
+	cfg := &Config{
+		ServerPort:           "8080",
+		DBConnection:         "host=localhost user=postgres password=postgres dbname=fileuploader port=5432 sslmode=disable",
+		UploadTempDir:        "./uploads/temp",
+		UploadFinalDir:       "./uploads/files",
+		MinioEndpoint:        "localhost:9000",
+		MinioBucket:          "uploads",
+		ShutdownTimeout:      30 * time.Second,
+		IdempotencyTTL:       24 * time.Hour,
+		MaxFileVersions:      10,
+		ArchiveTTL:           24 * time.Hour,
+		ScrubInterval:        24 * time.Hour,
+		ScrubOrphanMinAge:    24 * time.Hour,
+		QuarantineDir:        "./uploads/quarantine",
+		URLFetchTimeout:      10 * time.Minute,
+		URLFetchMaxRedirects: 5,
+		RateLimitStore:       "memory",
+		RateLimitMaxBuckets:  100000,
+		APIKeys:              map[string]string{},
+		EncryptionKeys:       map[string][]byte{},
+		LogFormat:            "text",
+		UploadLogFile:        "logs/upload.log",
+		LogMaxSizeMB:         100,
+		LogMaxBackups:        5,
+	}
+	settings := &Settings{
+		MaxFileSize:             100 * MB,
+		MimeSizeLimits:          map[string]int64{},
+		MaxRequestBodySize:      1024 * MB,
+		MaxChunkSize:            64 * MB,
+		ArchiveMaxFiles:         1000,
+		ExtractMaxFiles:         1000,
+		ExtractMaxTotalSize:     1024 * MB,
+		ExtractMaxRatio:         100,
+		RateLimitPerMinute:      600,
+		RateLimitBurst:          100,
+		MaxActiveUploads:        20,
+		ActiveUploadIdleTimeout: 24 * time.Hour,
+		CORSAllowedOrigins:      []string{"*"},
+		CORSAllowedMethods:      []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		CORSAllowedHeaders: []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization",
+			"Content-Range", "Range", "X-Request-ID", "Idempotency-Key", "X-Share-Password"},
+		CORSExposedHeaders: []string{"X-Request-ID", "Idempotent-Replayed", "Retry-After", "RateLimit-Limit",
+			"RateLimit-Remaining", "RateLimit-Reset", "Location", "Upload-Offset", "Content-Disposition", "ETag",
+			"X-Checksum-SHA256"},
+		CORSMaxAge:           10 * time.Minute,
+		CompressionAlgorithm: "none",
+		CompressMimeTypes: []string{"text/*", "application/json", "application/x-ndjson", "application/xml",
+			"application/yaml"},
+		MinFreeDisk: 100 * MB,
+		LogLevel:    "info",
+	}
+	return cfg, settings
+}
+
+// applyEnv overrides the configuration with the environment variables that
+// are set. Sizes in variables ending in _MB may also carry a unit.
+func applyEnv(cfg *Config, s *Settings, errs *[]error) {
+	e := envReader{errs: errs}
+	e.string("SERVER_PORT", &cfg.ServerPort)
+	e.string("DB_CONNECTION", &cfg.DBConnection)
+	e.string("UPLOAD_TEMP_DIR", &cfg.UploadTempDir)
+	e.string("UPLOAD_FINAL_DIR", &cfg.UploadFinalDir)
+	e.string("MINIO_ENDPOINT", &cfg.MinioEndpoint)
+	e.string("MINIO_ACCESS_KEY", &cfg.MinioAccessKey)
+	e.string("MINIO_SECRET_KEY", &cfg.MinioSecretKey)
+	e.bool("MINIO_USE_SSL", &cfg.MinioUseSSL)
+	e.bool("ENABLE_MINIO", &cfg.EnabledMinio)
+	e.string("MINIO_BUCKET_NAME", &cfg.MinioBucket)
+	e.duration("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+	e.list("TRUSTED_PROXIES", &cfg.TrustedProxies)
+	e.secretMap("API_KEYS", &cfg.APIKeys)
+	e.duration("IDEMPOTENCY_TTL", &cfg.IdempotencyTTL)
+	e.int("MAX_FILE_VERSIONS", &cfg.MaxFileVersions)
+	e.duration("FILE_VERSION_RETENTION", &cfg.FileVersionRetention)
+	e.duration("ARCHIVE_TTL", &cfg.ArchiveTTL)
+	e.duration("SCRUB_INTERVAL", &cfg.ScrubInterval)
+	e.bool("SCRUB_REPAIR", &cfg.ScrubRepair)
+	e.bool("SCRUB_QUARANTINE", &cfg.ScrubQuarantine)
+	e.duration("SCRUB_ORPHAN_MIN_AGE", &cfg.ScrubOrphanMinAge)
+	e.string("QUARANTINE_DIR", &cfg.QuarantineDir)
+	e.duration("URL_FETCH_TIMEOUT", &cfg.URLFetchTimeout)
+	e.int("URL_FETCH_MAX_REDIRECTS", &cfg.URLFetchMaxRedirects)
+	e.string("RATE_LIMIT_STORE", &cfg.RateLimitStore)
+	e.int("RATE_LIMIT_MAX_BUCKETS", &cfg.RateLimitMaxBuckets)
+	e.bool("ENCRYPTION_ENABLED", &cfg.EncryptionEnabled)
+	e.string("ENCRYPTION_ACTIVE_KEY", &cfg.EncryptionActiveKey)
+	e.string("ENCRYPTION_KEY_FILE", &cfg.EncryptionKeyFile)
+	e.keyMap("ENCRYPTION_KEYS", &cfg.EncryptionKeys)
+	e.string("LOG_FORMAT", &cfg.LogFormat)
+	e.string("LOG_FILE", &cfg.LogFile)
+	e.string("UPLOAD_LOG_FILE", &cfg.UploadLogFile)
+	e.int("LOG_MAX_SIZE_MB", &cfg.LogMaxSizeMB)
+	e.int("LOG_MAX_BACKUPS", &cfg.LogMaxBackups)
+
+	e.size("MAX_FILE_SIZE_MB", &s.MaxFileSize, MB)
+	e.size("MAX_DIRECT_UPLOAD_SIZE_MB", &s.MaxDirectUploadSize, MB)
+	e.size("MAX_CHUNKED_UPLOAD_SIZE_MB", &s.MaxChunkedUploadSize, MB)
+	e.sizeMap("MIME_SIZE_LIMITS_MB", &s.MimeSizeLimits, MB)
+	e.size("MAX_REQUEST_BODY_MB", &s.MaxRequestBodySize, MB)
+	e.size("MAX_CHUNK_SIZE_MB", &s.MaxChunkSize, MB)
+	e.int("ARCHIVE_MAX_FILES", &s.ArchiveMaxFiles)
+	e.int("EXTRACT_MAX_FILES", &s.ExtractMaxFiles)
+	e.size("EXTRACT_MAX_TOTAL_SIZE_MB", &s.ExtractMaxTotalSize, MB)
+	e.int("EXTRACT_MAX_RATIO", &s.ExtractMaxRatio)
+	e.list("URL_FETCH_ALLOW_CIDRS", &s.URLFetchAllowCIDRs)
+	e.list("URL_FETCH_ALLOW_HOSTS", &s.URLFetchAllowHosts)
+	e.int("RATE_LIMIT_PER_MINUTE", &s.RateLimitPerMinute)
+	e.int("RATE_LIMIT_BURST", &s.RateLimitBurst)
+	e.int("MAX_ACTIVE_UPLOADS_PER_OWNER", &s.MaxActiveUploads)
+	e.duration("ACTIVE_UPLOAD_IDLE_TIMEOUT", &s.ActiveUploadIdleTimeout)
+	e.list("CORS_ALLOWED_ORIGINS", &s.CORSAllowedOrigins)
+	e.list("CORS_ALLOWED_METHODS", &s.CORSAllowedMethods)
+	e.list("CORS_ALLOWED_HEADERS", &s.CORSAllowedHeaders)
+	e.list("CORS_EXPOSED_HEADERS", &s.CORSExposedHeaders)
+	e.bool("CORS_ALLOW_CREDENTIALS", &s.CORSAllowCredentials)
+	e.duration("CORS_MAX_AGE", &s.CORSMaxAge)
+	e.string("COMPRESSION_ALGORITHM", &s.CompressionAlgorithm)
+	e.list("COMPRESSION_MIME_TYPES", &s.CompressMimeTypes)
+	e.size("MIN_FREE_DISK_MB", &s.MinFreeDisk, MB)
+	e.string("LOG_LEVEL", &s.LogLevel)
 }
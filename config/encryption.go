@@ -0,0 +1,41 @@
+package config
+
+import (
+	"bytes"
+	"fileupload/pkg/encryption"
+	"fmt"
+)
+
+// loadKeyring adds the keys of the key file to the configured master keys
+// and builds the keyring from them
+func loadKeyring(cfg *Config) error {
+	if cfg.EncryptionKeyFile != "" {
+		keys, err := encryption.LoadKeyFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return err
+		}
+		for id, key := range keys {
+			if existing, ok := cfg.EncryptionKeys[id]; ok && !bytes.Equal(existing, key) {
+				return fmt.Errorf("encryption key %q is defined twice with different values", id)
+			}
+			cfg.EncryptionKeys[id] = key
+		}
+	}
+
+	if cfg.EncryptionEnabled && cfg.EncryptionActiveKey == "" {
+		return fmt.Errorf("encryption active key is required when encryption is enabled")
+	}
+	if len(cfg.EncryptionKeys) == 0 {
+		if cfg.EncryptionActiveKey != "" {
+			return fmt.Errorf("encryption active key %q: %w", cfg.EncryptionActiveKey, encryption.ErrUnknownKey)
+		}
+		return nil
+	}
+
+	keyring, err := encryption.NewKeyring(cfg.EncryptionKeys, cfg.EncryptionActiveKey)
+	if err != nil {
+		return fmt.Errorf("invalid encryption keys: %w", err)
+	}
+	cfg.keyring = keyring
+	return nil
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"fileupload/pkg/encryption"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envReader overrides configuration values with the environment variables
+// that are set, collecting the invalid ones instead of ignoring them
+type envReader struct {
+	errs *[]error
+}
+
+func (e envReader) fail(key, value string, err error) {
+	*e.errs = append(*e.errs, fmt.Errorf("%s=%q: %w", key, value, err))
+}
+
+func (e envReader) string(key string, dst *string) {
+	if value := os.Getenv(key); value != "" {
+		*dst = value
+	}
+}
+
+func (e envReader) bool(key string, dst *bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.fail(key, value, fmt.Errorf("not a boolean"))
+		return
+	}
+	*dst = b
+}
+
+func (e envReader) int(key string, dst *int) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.fail(key, value, fmt.Errorf("not an integer"))
+		return
+	}
+	*dst = n
+}
+
+func (e envReader) size(key string, dst *int64, unit int64) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	n, err := ParseSize(value, unit)
+	if err != nil {
+		e.fail(key, value, err)
+		return
+	}
+	*dst = n
+}
+
+func (e envReader) duration(key string, dst *time.Duration) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	d, err := ParseDuration(value)
+	if err != nil {
+		e.fail(key, value, err)
+		return
+	}
+	*dst = d
+}
+
+// list reads a comma-separated list, ignoring empty items
+func (e envReader) list(key string, dst *[]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*dst = items
+}
+
+// sizeMap reads a comma-separated list of key=size pairs, e.g.
+// "image/*=20,application/pdf=50"
+func (e envReader) sizeMap(key string, dst *map[string]int64, unit int64) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	sizes := make(map[string]int64)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(item, "=")
+		if !ok {
+			e.fail(key, value, fmt.Errorf("entry %q is not key=size", item))
+			continue
+		}
+		n, err := ParseSize(raw, unit)
+		if err != nil {
+			e.fail(key, value, err)
+			continue
+		}
+		sizes[strings.ToLower(strings.TrimSpace(name))] = n
+	}
+	*dst = sizes
+}
+
+// secretMap reads a comma-separated list of name=secret pairs. Errors never
+// include the value.
+func (e envReader) secretMap(key string, dst *map[string]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	secrets := make(map[string]string)
+	for i, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, secret, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			*e.errs = append(*e.errs, fmt.Errorf("%s: entry %d is not name=secret", key, i+1))
+			continue
+		}
+		secrets[strings.TrimSpace(name)] = strings.TrimSpace(secret)
+	}
+	*dst = secrets
+}
+
+// keyMap reads a comma-separated list of id=key pairs of base64-encoded
+// encryption keys. Errors never include the value.
+func (e envReader) keyMap(key string, dst *map[string][]byte) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	keys := make(map[string][]byte)
+	for i, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		id, raw, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(id) == "" {
+			*e.errs = append(*e.errs, fmt.Errorf("%s: entry %d is not id=key", key, i+1))
+			continue
+		}
+		k, err := encryption.ParseKey(raw)
+		if err != nil {
+			*e.errs = append(*e.errs, fmt.Errorf("%s: key %q: %w", key, strings.TrimSpace(id), err))
+			continue
+		}
+		keys[strings.TrimSpace(id)] = k
+	}
+	*dst = keys
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"errors"
+	"fileupload/pkg/encryption"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the schema of the config file, documented in
+// config.example.yaml. Absent keys leave the defaults in place and unknown
+// keys are rejected.
+type fileConfig struct {
+	Server struct {
+		Port            *string   `yaml:"port"`
+		ShutdownTimeout *Duration `yaml:"shutdown_timeout"`
+		TrustedProxies  []string  `yaml:"trusted_proxies"`
+	} `yaml:"server"`
+
+	Auth struct {
+		APIKeys map[string]string `yaml:"api_keys"`
+	} `yaml:"auth"`
+
+	Database struct {
+		DSN *string `yaml:"dsn"`
+	} `yaml:"database"`
+
+	Storage struct {
+		TempDir  *string `yaml:"temp_dir"`
+		FinalDir *string `yaml:"final_dir"`
+	} `yaml:"storage"`
+
+	Minio struct {
+		Enabled   *bool   `yaml:"enabled"`
+		Endpoint  *string `yaml:"endpoint"`
+		AccessKey *string `yaml:"access_key"`
+		SecretKey *string `yaml:"secret_key"`
+		UseSSL    *bool   `yaml:"use_ssl"`
+		Bucket    *string `yaml:"bucket"`
+	} `yaml:"minio"`
+
+	Limits struct {
+		MaxFileSize          *Size           `yaml:"max_file_size"`
+		MaxDirectUploadSize  *Size           `yaml:"max_direct_upload_size"`
+		MaxChunkedUploadSize *Size           `yaml:"max_chunked_upload_size"`
+		MimeTypes            map[string]Size `yaml:"mime_types"`
+		MaxRequestBodySize   *Size           `yaml:"max_request_body_size"`
+		MaxChunkSize         *Size           `yaml:"max_chunk_size"`
+		MinFreeDisk          *Size           `yaml:"min_free_disk"`
+	} `yaml:"limits"`
+
+	Idempotency struct {
+		TTL *Duration `yaml:"ttl"`
+	} `yaml:"idempotency"`
+
+	Versions struct {
+		MaxVersions *int      `yaml:"max_versions"`
+		Retention   *Duration `yaml:"retention"`
+	} `yaml:"versions"`
+
+	Archives struct {
+		MaxFiles *int      `yaml:"max_files"`
+		TTL      *Duration `yaml:"ttl"`
+	} `yaml:"archives"`
+
+	Scrub struct {
+		Interval      *Duration `yaml:"interval"`
+		Repair        *bool     `yaml:"repair"`
+		Quarantine    *bool     `yaml:"quarantine"`
+		OrphanMinAge  *Duration `yaml:"orphan_min_age"`
+		QuarantineDir *string   `yaml:"quarantine_dir"`
+	} `yaml:"scrub"`
+
+	Extract struct {
+		MaxFiles     *int  `yaml:"max_files"`
+		MaxTotalSize *Size `yaml:"max_total_size"`
+		MaxRatio     *int  `yaml:"max_ratio"`
+	} `yaml:"extract"`
+
+	URLFetch struct {
+		Timeout      *Duration `yaml:"timeout"`
+		MaxRedirects *int      `yaml:"max_redirects"`
+		AllowCIDRs   []string  `yaml:"allow_cidrs"`
+		AllowHosts   []string  `yaml:"allow_hosts"`
+	} `yaml:"url_fetch"`
+
+	RateLimit struct {
+		Store      *string `yaml:"store"`
+		MaxBuckets *int    `yaml:"max_buckets"`
+		PerMinute  *int    `yaml:"per_minute"`
+		Burst      *int    `yaml:"burst"`
+	} `yaml:"rate_limit"`
+
+	Uploads struct {
+		MaxActivePerOwner *int      `yaml:"max_active_per_owner"`
+		IdleTimeout       *Duration `yaml:"idle_timeout"`
+	} `yaml:"uploads"`
+
+	CORS struct {
+		AllowedOrigins   []string  `yaml:"allowed_origins"`
+		AllowedMethods   []string  `yaml:"allowed_methods"`
+		AllowedHeaders   []string  `yaml:"allowed_headers"`
+		ExposedHeaders   []string  `yaml:"exposed_headers"`
+		AllowCredentials *bool     `yaml:"allow_credentials"`
+		MaxAge           *Duration `yaml:"max_age"`
+	} `yaml:"cors"`
+
+	Compression struct {
+		Algorithm *string  `yaml:"algorithm"`
+		MimeTypes []string `yaml:"mime_types"`
+	} `yaml:"compression"`
+
+	Encryption struct {
+		Enabled   *bool             `yaml:"enabled"`
+		ActiveKey *string           `yaml:"active_key"`
+		KeyFile   *string           `yaml:"key_file"`
+		Keys      map[string]string `yaml:"keys"`
+	} `yaml:"encryption"`
+
+	Logging struct {
+		Level      *string `yaml:"level"`
+		Format     *string `yaml:"format"`
+		File       *string `yaml:"file"`
+		UploadFile *string `yaml:"upload_file"`
+		MaxSizeMB  *int    `yaml:"max_size_mb"`
+		MaxBackups *int    `yaml:"max_backups"`
+	} `yaml:"logging"`
+}
+
+// Size is a size in the config file, either a number of bytes or a string
+// with a unit such as "64MB"
+type Size int64
+
+func (s *Size) UnmarshalYAML(node *yaml.Node) error {
+	n, err := ParseSize(node.Value, 1)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*s = Size(n)
+	return nil
+}
+
+// Duration is a duration in the config file such as "90s", "12h" or "7d"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// applyFile overrides the defaults with the values set in a config file
+func applyFile(cfg *Config, s *Settings, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	var fc fileConfig
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	set(&cfg.ServerPort, fc.Server.Port)
+	setDuration(&cfg.ShutdownTimeout, fc.Server.ShutdownTimeout)
+	setList(&cfg.TrustedProxies, fc.Server.TrustedProxies)
+	for owner, key := range fc.Auth.APIKeys {
+		cfg.APIKeys[owner] = key
+	}
+	set(&cfg.DBConnection, fc.Database.DSN)
+	set(&cfg.UploadTempDir, fc.Storage.TempDir)
+	set(&cfg.UploadFinalDir, fc.Storage.FinalDir)
+	set(&cfg.EnabledMinio, fc.Minio.Enabled)
+	set(&cfg.MinioEndpoint, fc.Minio.Endpoint)
+	set(&cfg.MinioAccessKey, fc.Minio.AccessKey)
+	set(&cfg.MinioSecretKey, fc.Minio.SecretKey)
+	set(&cfg.MinioUseSSL, fc.Minio.UseSSL)
+	set(&cfg.MinioBucket, fc.Minio.Bucket)
+	setDuration(&cfg.IdempotencyTTL, fc.Idempotency.TTL)
+	set(&cfg.MaxFileVersions, fc.Versions.MaxVersions)
+	setDuration(&cfg.FileVersionRetention, fc.Versions.Retention)
+	setDuration(&cfg.ArchiveTTL, fc.Archives.TTL)
+	setDuration(&cfg.ScrubInterval, fc.Scrub.Interval)
+	set(&cfg.ScrubRepair, fc.Scrub.Repair)
+	set(&cfg.ScrubQuarantine, fc.Scrub.Quarantine)
+	setDuration(&cfg.ScrubOrphanMinAge, fc.Scrub.OrphanMinAge)
+	set(&cfg.QuarantineDir, fc.Scrub.QuarantineDir)
+	setDuration(&cfg.URLFetchTimeout, fc.URLFetch.Timeout)
+	set(&cfg.URLFetchMaxRedirects, fc.URLFetch.MaxRedirects)
+	set(&cfg.RateLimitStore, fc.RateLimit.Store)
+	set(&cfg.RateLimitMaxBuckets, fc.RateLimit.MaxBuckets)
+	set(&cfg.EncryptionEnabled, fc.Encryption.Enabled)
+	set(&cfg.EncryptionActiveKey, fc.Encryption.ActiveKey)
+	set(&cfg.EncryptionKeyFile, fc.Encryption.KeyFile)
+	for id, value := range fc.Encryption.Keys {
+		key, err := encryption.ParseKey(value)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: encryption key %q: %w", path, id, err)
+		}
+		cfg.EncryptionKeys[id] = key
+	}
+	set(&cfg.LogFormat, fc.Logging.Format)
+	set(&cfg.LogFile, fc.Logging.File)
+	set(&cfg.UploadLogFile, fc.Logging.UploadFile)
+	set(&cfg.LogMaxSizeMB, fc.Logging.MaxSizeMB)
+	set(&cfg.LogMaxBackups, fc.Logging.MaxBackups)
+
+	setSize(&s.MaxFileSize, fc.Limits.MaxFileSize)
+	setSize(&s.MaxDirectUploadSize, fc.Limits.MaxDirectUploadSize)
+	setSize(&s.MaxChunkedUploadSize, fc.Limits.MaxChunkedUploadSize)
+	for mimeType, size := range fc.Limits.MimeTypes {
+		s.MimeSizeLimits[strings.ToLower(mimeType)] = int64(size)
+	}
+	setSize(&s.MaxRequestBodySize, fc.Limits.MaxRequestBodySize)
+	setSize(&s.MaxChunkSize, fc.Limits.MaxChunkSize)
+	setSize(&s.MinFreeDisk, fc.Limits.MinFreeDisk)
+	set(&s.ArchiveMaxFiles, fc.Archives.MaxFiles)
+	set(&s.ExtractMaxFiles, fc.Extract.MaxFiles)
+	setSize(&s.ExtractMaxTotalSize, fc.Extract.MaxTotalSize)
+	set(&s.ExtractMaxRatio, fc.Extract.MaxRatio)
+	setList(&s.URLFetchAllowCIDRs, fc.URLFetch.AllowCIDRs)
+	setList(&s.URLFetchAllowHosts, fc.URLFetch.AllowHosts)
+	set(&s.RateLimitPerMinute, fc.RateLimit.PerMinute)
+	set(&s.RateLimitBurst, fc.RateLimit.Burst)
+	set(&s.MaxActiveUploads, fc.Uploads.MaxActivePerOwner)
+	setDuration(&s.ActiveUploadIdleTimeout, fc.Uploads.IdleTimeout)
+	setList(&s.CORSAllowedOrigins, fc.CORS.AllowedOrigins)
+	setList(&s.CORSAllowedMethods, fc.CORS.AllowedMethods)
+	setList(&s.CORSAllowedHeaders, fc.CORS.AllowedHeaders)
+	setList(&s.CORSExposedHeaders, fc.CORS.ExposedHeaders)
+	set(&s.CORSAllowCredentials, fc.CORS.AllowCredentials)
+	setDuration(&s.CORSMaxAge, fc.CORS.MaxAge)
+	set(&s.CompressionAlgorithm, fc.Compression.Algorithm)
+	setList(&s.CompressMimeTypes, fc.Compression.MimeTypes)
+	set(&s.LogLevel, fc.Logging.Level)
+
+	return nil
+}
+
+func set[T any](dst *T, value *T) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+// setList replaces a list that is present in the file, even if empty
+func setList(dst *[]string, value []string) {
+	if value != nil {
+		*dst = value
+	}
+}
+
+func setSize(dst *int64, value *Size) {
+	if value != nil {
+		*dst = int64(*value)
+	}
+}
+
+func setDuration(dst *time.Duration, value *Duration) {
+	if value != nil {
+		*dst = time.Duration(*value)
+	}
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Size units. Units are binary: 1KB is 1024 bytes.
+const (
+	KB int64 = 1 << (10 * (iota + 1))
+	MB
+	GB
+	TB
+)
+
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   KB,
+	"kb":  KB,
+	"kib": KB,
+	"m":   MB,
+	"mb":  MB,
+	"mib": MB,
+	"g":   GB,
+	"gb":  GB,
+	"gib": GB,
+	"t":   TB,
+	"tb":  TB,
+	"tib": TB,
+}
+
+// ParseSize parses a size such as "512", "64MB", "1.5 GiB" or "10k". A number
+// without a unit is counted in defaultUnit.
+func ParseSize(s string, defaultUnit int64) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	number, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+	if unit == "" {
+		multiplier = defaultUnit
+	}
+	size := n * float64(multiplier)
+	if size > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return int64(size), nil
+}
+
+// ParseDuration parses a duration as time.ParseDuration does, additionally
+// accepting days ("7d") and weeks ("2w") as whole leading units, e.g.
+// "1d12h"
+func ParseDuration(value string) (time.Duration, error) {
+	s := strings.TrimSpace(value)
+	var total time.Duration
+	for _, unit := range []struct {
+		suffix string
+		length time.Duration
+	}{{"w", 7 * 24 * time.Hour}, {"d", 24 * time.Hour}} {
+		i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) })
+		if i <= 0 || !strings.HasPrefix(s[i:], unit.suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		total += time.Duration(n) * unit.length
+		s = s[i+len(unit.suffix):]
+	}
+	if s == "" {
+		return total, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return total + d, nil
+}
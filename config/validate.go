@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fileupload/pkg/compression"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// minAPIKeyLength is the length below which an API key is too easy to guess
+const minAPIKeyLength = 16
+
+// validate reports every invalid or inconsistent value of a configuration
+func validate(cfg *Config, s *Settings) []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(cfg.ServerPort)
+	check(err == nil && port > 0 && port < 65536, "server port %q is not a valid port", cfg.ServerPort)
+	check(cfg.DBConnection != "", "database connection is required")
+	check(cfg.UploadTempDir != "", "upload temp dir is required")
+	check(cfg.UploadFinalDir != "", "upload final dir is required")
+
+	if cfg.EnabledMinio {
+		check(cfg.MinioEndpoint != "", "minio endpoint is required when minio is enabled")
+		check(cfg.MinioAccessKey != "", "minio access key is required when minio is enabled")
+		check(cfg.MinioSecretKey != "", "minio secret key is required when minio is enabled")
+		check(cfg.MinioBucket != "", "minio bucket is required when minio is enabled")
+	}
+
+	check(cfg.ShutdownTimeout > 0, "shutdown timeout must be positive")
+	check(cfg.IdempotencyTTL > 0, "idempotency TTL must be positive")
+	check(cfg.MaxFileVersions >= 0, "max file versions must not be negative")
+	check(cfg.FileVersionRetention >= 0, "file version retention must not be negative")
+	check(cfg.ArchiveTTL > 0, "archive TTL must be positive")
+	check(cfg.ScrubInterval >= 0, "scrub interval must not be negative")
+	check(cfg.ScrubOrphanMinAge > 0, "scrub orphan min age must be positive")
+	check(!cfg.ScrubQuarantine || cfg.QuarantineDir != "", "quarantine dir is required when quarantining is enabled")
+	check(cfg.URLFetchTimeout > 0, "URL fetch timeout must be positive")
+	check(cfg.URLFetchMaxRedirects >= 0, "URL fetch max redirects must not be negative")
+	check(cfg.RateLimitStore == "memory" || cfg.RateLimitStore == "postgres", "rate limit store %q must be memory or postgres", cfg.RateLimitStore)
+	owners := make(map[string]string, len(cfg.APIKeys))
+	for owner, key := range cfg.APIKeys {
+		check(len(key) >= minAPIKeyLength, "API key of owner %q must be at least %d characters", owner, minAPIKeyLength)
+		other, duplicate := owners[key]
+		check(!duplicate, "owners %q and %q have the same API key", other, owner)
+		owners[key] = owner
+	}
+	check(cfg.RateLimitMaxBuckets > 0, "rate limit max buckets must be positive")
+	for _, proxy := range cfg.TrustedProxies {
+		_, prefixErr := netip.ParsePrefix(proxy)
+		_, addrErr := netip.ParseAddr(proxy)
+		check(prefixErr == nil || addrErr == nil, "trusted proxy %q is not an IP address or CIDR", proxy)
+	}
+	check(cfg.LogFormat == "text" || cfg.LogFormat == "json", "log format %q must be text or json", cfg.LogFormat)
+	check(cfg.LogMaxSizeMB >= 0, "log max size must not be negative")
+	check(cfg.LogMaxBackups >= 0, "log max backups must not be negative")
+
+	check(s.MaxFileSize > 0, "max file size must be positive")
+	check(s.MaxDirectUploadSize >= 0, "max direct upload size must not be negative")
+	check(s.MaxChunkedUploadSize >= 0, "max chunked upload size must not be negative")
+	check(s.MaxRequestBodySize >= 0, "max request body size must not be negative")
+	check(s.MaxChunkSize >= 0, "max chunk size must not be negative")
+	check(s.MinFreeDisk >= 0, "min free disk must not be negative")
+	for mimeType, size := range s.MimeSizeLimits {
+		family, subtype, ok := strings.Cut(mimeType, "/")
+		check(ok && family != "" && subtype != "" && family != "*", "MIME size limit key %q must be a type or type/*", mimeType)
+		check(size > 0, "MIME size limit of %q must be positive", mimeType)
+	}
+	check(s.ArchiveMaxFiles > 0, "archive max files must be positive")
+	check(s.ExtractMaxFiles > 0, "extract max files must be positive")
+	check(s.ExtractMaxTotalSize > 0, "extract max total size must be positive")
+	check(s.ExtractMaxRatio >= 0, "extract max ratio must not be negative")
+	check(s.RateLimitPerMinute >= 0, "rate limit per minute must not be negative")
+	check(s.RateLimitBurst >= 0, "rate limit burst must not be negative")
+	check(s.RateLimitPerMinute == 0 || s.RateLimitBurst > 0, "rate limit burst must be positive when rate limiting is enabled")
+	check(s.MaxActiveUploads >= 0, "max active uploads must not be negative")
+	check(s.ActiveUploadIdleTimeout >= 0, "active upload idle timeout must not be negative")
+	for _, cidr := range s.URLFetchAllowCIDRs {
+		_, err := netip.ParsePrefix(cidr)
+		check(err == nil, "URL fetch allowlist entry %q is not a CIDR", cidr)
+	}
+	for _, origin := range s.CORSAllowedOrigins {
+		check(validOrigin(origin), "CORS origin %q must be *, scheme://host[:port] or scheme://*.domain", origin)
+		check(origin != "*" || !s.CORSAllowCredentials, "CORS origin * cannot be combined with credentials")
+	}
+	check(len(s.CORSAllowedMethods) > 0, "CORS allowed methods must not be empty")
+	check(s.CORSMaxAge >= 0, "CORS max age must not be negative")
+	check(s.CompressionAlgorithm == "none" || compression.Valid(s.CompressionAlgorithm),
+		"compression algorithm %q must be none, gzip or zstd", s.CompressionAlgorithm)
+	for _, mimeType := range s.CompressMimeTypes {
+		family, subtype, ok := strings.Cut(mimeType, "/")
+		check(ok && family != "" && subtype != "" && family != "*", "compressed MIME type %q must be a type or type/*", mimeType)
+	}
+	_, err = logrus.ParseLevel(s.LogLevel)
+	check(err == nil, "log level %q is not valid", s.LogLevel)
+
+	return errs
+}
+
+// validOrigin reports whether origin is "*" or an origin without path, whose
+// host may start with "*." to match subdomains
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+	return err == nil && u.Scheme != "" && u.Host != "" && u.Path == "" && u.RawQuery == "" && u.User == nil
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"errors"
+	"fileupload/internal/delivery/http/middleware"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/usecase"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ArchiveHandler struct {
+	archiveUseCase usecase.ArchiveUseCase
+}
+
+func NewArchiveHandler(archiveUseCase usecase.ArchiveUseCase) *ArchiveHandler {
+	return &ArchiveHandler{
+		archiveUseCase: archiveUseCase,
+	}
+}
+
+// CreateArchive godoc
+// @Summary Download several files as a ZIP archive
+// @Description Stream a ZIP archive of the given files, or of every file below a folder. With async set, the archive is built in the background and a download link is returned instead.
+// @Tags files
+// @Accept json
+// @Produce application/zip
+// @Produce json
+// @Param request body CreateArchiveRequest true "Files or folder to archive"
+// @Success 200 {file} binary
+// @Success 202 {object} ArchiveJobResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/archive [post]
+func (h *ArchiveHandler) CreateArchive(c *gin.Context) {
+	var req struct {
+		FileIDs  []uuid.UUID `json:"file_ids"`
+		FolderID *uuid.UUID  `json:"folder_id"`
+		Async    bool        `json:"async"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	archiveReq := usecase.ArchiveRequest{
+		FileIDs:  req.FileIDs,
+		FolderID: req.FolderID,
+	}
+
+	if req.Async {
+		job, err := h.archiveUseCase.StartArchiveJob(c.Request.Context(), middleware.GetOwner(c), archiveReq)
+		if respondShuttingDown(c, err) {
+			return
+		}
+		if err != nil {
+			respondArchiveError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, archiveJobResponse(job))
+		return
+	}
+
+	archive, err := h.archiveUseCase.PrepareArchive(c.Request.Context(), middleware.GetOwner(c), archiveReq)
+	if err != nil {
+		respondArchiveError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archive.Name}))
+	c.Status(http.StatusOK)
+	if err := h.archiveUseCase.WriteArchive(c.Request.Context(), c.Writer, archive); err != nil {
+		// The response is already under way; the truncated archive is all
+		// the client can be told
+		c.Error(err)
+	}
+}
+
+// GetArchive godoc
+// @Summary Get an archive job
+// @Description Get the status of an archive built in the background
+// @Tags files
+// @Produce json
+// @Param archive_id path string true "Archive ID"
+// @Success 200 {object} ArchiveJobResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /archives/{archive_id} [get]
+func (h *ArchiveHandler) GetArchive(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("archive_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid archive ID"})
+		return
+	}
+
+	job, err := h.archiveUseCase.GetArchiveJob(c.Request.Context(), middleware.GetOwner(c), id)
+	if err != nil {
+		respondArchiveError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, archiveJobResponse(job))
+}
+
+// DownloadArchive godoc
+// @Summary Download an archive
+// @Description Download an archive built in the background
+// @Tags files
+// @Produce application/zip
+// @Param archive_id path string true "Archive ID"
+// @Success 200 {file} binary
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /archives/{archive_id}/download [get]
+func (h *ArchiveHandler) DownloadArchive(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("archive_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid archive ID"})
+		return
+	}
+
+	content, err := h.archiveUseCase.OpenArchive(c.Request.Context(), middleware.GetOwner(c), id)
+	if err != nil {
+		respondArchiveError(c, err)
+		return
+	}
+	defer content.Reader.Close()
+
+	serveContent(c, content)
+}
+
+func archiveJobResponse(job *entity.ArchiveJob) gin.H {
+	response := gin.H{
+		"archive_id":   job.ID,
+		"name":         job.Name,
+		"status":       job.Status,
+		"file_count":   job.FileCount,
+		"status_url":   "/api/archives/" + job.ID.String(),
+		"created_at":   job.CreatedAt,
+		"completed_at": job.CompletedAt,
+		"expires_at":   job.ExpiresAt,
+	}
+	switch job.Status {
+	case "completed":
+		response["size"] = job.Size
+		response["download_url"] = "/api/archives/" + job.ID.String() + "/download"
+	case "failed":
+		response["error"] = job.Error
+	}
+	return response
+}
+
+// respondArchiveError maps errors of the archive operations to HTTP statuses
+func respondArchiveError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrArchiveNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrArchiveNotReady):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrInvalidArchiveRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		respondFileError(c, err)
+	}
+}
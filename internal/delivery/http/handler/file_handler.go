@@ -1,9 +1,16 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+	"fileupload/internal/delivery/http/middleware"
+	"fileupload/internal/domain/entity"
 	"fileupload/internal/usecase"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -29,13 +36,21 @@ func NewFileHandler(fileUseCase usecase.FileUseCase) *FileHandler {
 // @Param request body InitiateUploadRequest true "Upload information"
 // @Success 201 {object} InitiateUploadResponse
 // @Failure 400 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /uploads [post]
 func (h *FileHandler) InitiateUpload(c *gin.Context) {
 	var req struct {
-		FileName string `json:"file_name" binding:"required"`
-		FileSize int64  `json:"file_size" binding:"required,min=1"`
-		MimeType string `json:"mime_type" binding:"required"`
+		FileName     string            `json:"file_name" binding:"required"`
+		FileSize     int64             `json:"file_size" binding:"required,min=1"`
+		MimeType     string            `json:"mime_type" binding:"required"`
+		Metadata     map[string]string `json:"metadata"`
+		Tags         []string          `json:"tags"`
+		FolderID     *uuid.UUID        `json:"folder_id"`
+		FolderPath   string            `json:"folder_path"`
+		TargetFileID *uuid.UUID        `json:"target_file_id"`
+		Extract      bool              `json:"extract"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -43,9 +58,20 @@ func (h *FileHandler) InitiateUpload(c *gin.Context) {
 		return
 	}
 
-	upload, err := h.fileUseCase.InitiateUpload(req.FileName, req.FileSize, req.MimeType)
+	opts := usecase.UploadOptions{
+		Metadata:     req.Metadata,
+		Tags:         req.Tags,
+		FolderID:     req.FolderID,
+		FolderPath:   req.FolderPath,
+		TargetFileID: req.TargetFileID,
+		Extract:      req.Extract,
+	}
+	upload, err := h.fileUseCase.InitiateUpload(c.Request.Context(), middleware.GetOwner(c), req.FileName, req.FileSize, req.MimeType, opts)
+	if respondShuttingDown(c, err) {
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondFileError(c, err)
 		return
 	}
 
@@ -54,6 +80,8 @@ func (h *FileHandler) InitiateUpload(c *gin.Context) {
 		"file_name":  upload.OriginalName,
 		"total_size": upload.TotalSize,
 		"status":     upload.Status,
+		"metadata":   upload.Metadata,
+		"tags":       upload.Tags,
 		"created_at": upload.CreatedAt,
 	})
 }
@@ -70,6 +98,7 @@ func (h *FileHandler) InitiateUpload(c *gin.Context) {
 // @Success 200 {object} UploadChunkResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /uploads/{upload_id}/chunks [post]
 func (h *FileHandler) UploadChunk(c *gin.Context) {
@@ -87,6 +116,9 @@ func (h *FileHandler) UploadChunk(c *gin.Context) {
 	}
 
 	file, err := c.FormFile("file")
+	if respondTooLarge(c, err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file upload error: %v", err)})
 		return
@@ -100,9 +132,63 @@ func (h *FileHandler) UploadChunk(c *gin.Context) {
 	}
 	defer src.Close()
 
-	upload, err := h.fileUseCase.ProcessChunk(uploadID, src, contentRange)
+	h.processChunk(c, uploadID, src, contentRange)
+}
+
+// UploadChunkRaw godoc
+// @Summary Upload a chunk of a file as a raw body
+// @Description Upload a chunk of a file as an application/octet-stream body, streamed straight to storage. The chunk position is given by the Content-Range header.
+// @Tags files
+// @Accept application/octet-stream
+// @Produce json
+// @Param upload_id path string true "Upload ID"
+// @Param chunk body []byte true "Chunk bytes"
+// @Param Content-Range header string true "Content range (e.g., bytes 0-1023/10240)"
+// @Success 200 {object} UploadChunkResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /uploads/{upload_id}/chunks [put]
+func (h *FileHandler) UploadChunkRaw(c *gin.Context) {
+	uploadID, err := uuid.Parse(c.Param("upload_id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid upload ID"})
+		return
+	}
+
+	contentRange := c.GetHeader("Content-Range")
+	if contentRange == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content-Range header is required"})
+		return
+	}
+
+	// A declared length that disagrees with the range is rejected before any
+	// byte is written
+	var start, end, total int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err == nil &&
+		c.Request.ContentLength >= 0 && c.Request.ContentLength != end-start+1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content-Length does not match Content-Range"})
+		return
+	}
+
+	h.processChunk(c, uploadID, c.Request.Body, contentRange)
+}
+
+func (h *FileHandler) processChunk(c *gin.Context, uploadID uuid.UUID, src io.Reader, contentRange string) {
+	upload, err := h.fileUseCase.ProcessChunk(c.Request.Context(), middleware.GetOwner(c), uploadID, src, contentRange)
+	if respondShuttingDown(c, err) {
+		return
+	}
+	if respondTooLarge(c, err) {
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, usecase.ErrUploadNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -124,6 +210,7 @@ func (h *FileHandler) UploadChunk(c *gin.Context) {
 // @Success 200 {object} FinalizeUploadResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /uploads/{upload_id}/finalize [post]
 func (h *FileHandler) FinalizeUpload(c *gin.Context) {
@@ -134,10 +221,18 @@ func (h *FileHandler) FinalizeUpload(c *gin.Context) {
 		return
 	}
 
-	file, err := h.fileUseCase.FinalizeUpload(uploadID)
+	file, err := h.fileUseCase.FinalizeUpload(c.Request.Context(), middleware.GetOwner(c), uploadID)
+	if respondShuttingDown(c, err) {
+		return
+	}
 	if err != nil {
 		status := http.StatusInternalServerError
-		if strings.Contains(err.Error(), "upload incomplete") ||
+		if errors.Is(err, usecase.ErrNotAnArchive) || errors.Is(err, usecase.ErrUnsafeArchive) {
+			status = http.StatusUnprocessableEntity
+		} else if errors.Is(err, usecase.ErrUploadBusy) {
+			c.Header("Retry-After", "5")
+			status = http.StatusConflict
+		} else if strings.Contains(err.Error(), "upload incomplete") ||
 			strings.Contains(err.Error(), "already completed") ||
 			strings.Contains(err.Error(), "has failed") {
 			status = http.StatusBadRequest
@@ -148,13 +243,7 @@ func (h *FileHandler) FinalizeUpload(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"file_id":    file.ID,
-		"file_name":  file.OriginalName,
-		"size":       file.Size,
-		"mime_type":  file.MimeType,
-		"created_at": file.CreatedAt,
-	})
+	c.JSON(http.StatusOK, fileResponse(file))
 }
 
 // GetUploadStatus godoc
@@ -176,7 +265,7 @@ func (h *FileHandler) GetUploadStatus(c *gin.Context) {
 		return
 	}
 
-	upload, err := h.fileUseCase.GetUploadStatus(uploadID)
+	upload, err := h.fileUseCase.GetUploadStatus(c.Request.Context(), middleware.GetOwner(c), uploadID)
 	if err != nil {
 		status := http.StatusInternalServerError
 		if strings.Contains(err.Error(), "not found") {
@@ -187,16 +276,27 @@ func (h *FileHandler) GetUploadStatus(c *gin.Context) {
 	}
 
 	response := gin.H{
-		"upload_id":      upload.ID,
-		"file_name":      upload.OriginalName,
-		"status":         upload.Status,
-		"uploaded_size":  upload.UploadedSize,
-		"total_size":     upload.TotalSize,
-		"upload_percent": float64(upload.UploadedSize) / float64(upload.TotalSize) * 100,
-		"created_at":     upload.CreatedAt,
-		"updated_at":     upload.UpdatedAt,
+		"upload_id":     upload.ID,
+		"file_name":     upload.OriginalName,
+		"status":        upload.Status,
+		"uploaded_size": upload.UploadedSize,
+		"total_size":    upload.TotalSize,
+		"metadata":      upload.Metadata,
+		"tags":          upload.Tags,
+		"created_at":    upload.CreatedAt,
+		"updated_at":    upload.UpdatedAt,
 	}
 
+	// The size of a URL import is unknown until the server has answered
+	if upload.TotalSize > 0 {
+		response["upload_percent"] = float64(upload.UploadedSize) / float64(upload.TotalSize) * 100
+	}
+	if upload.SourceURL != "" {
+		response["source_url"] = upload.SourceURL
+	}
+	if upload.Error != "" {
+		response["error"] = upload.Error
+	}
 	if upload.CompletedAt != nil {
 		response["completed_at"] = upload.CompletedAt
 	}
@@ -205,37 +305,547 @@ func (h *FileHandler) GetUploadStatus(c *gin.Context) {
 }
 
 // UploadFile godoc
-// @Summary Upload a file (regular, non-chunked method)
-// @Description Upload a file using the standard multipart/form-data method
+// @Summary Upload files (regular, non-chunked method)
+// @Description Upload one or more files using the standard multipart/form-data method. Parts are streamed to storage as they arrive. A single "file" part is answered with the file; several parts (named "file" or "files") are answered with a result per file.
 // @Tags files
 // @Accept multipart/form-data
 // @Produce json
-// @Param file formData file true "File to upload"
+// @Param file formData file true "File to upload (repeat the part, or use \"files\", to upload several)"
+// @Param metadata formData string false "JSON object of string metadata"
+// @Param tags formData []string false "Tags (repeated field or comma-separated)"
+// @Param folder_id formData string false "Target folder ID"
+// @Param folder_path formData string false "Target folder path, e.g. reports/2026/q3 (created as needed)"
+// @Param target_file_id formData string false "Store the upload as a new version of this file"
+// @Param extract formData bool false "Expand a ZIP or tar(.gz) archive into individual files"
+// @Param all_or_nothing formData bool false "Store no file at all if any of them fails"
 // @Success 200 {object} FileResponse
+// @Success 207 {object} BatchUploadResponse
 // @Failure 400 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /files [post]
 func (h *FileHandler) UploadFile(c *gin.Context) {
-	// Get the file from form data
-	file, fileHeader, err := c.Request.FormFile("file")
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to get file: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to read form: %v", err)})
 		return
 	}
-	defer file.Close()
 
-	fileEntity, err := h.fileUseCase.DirectUpload(file, fileHeader)
+	ctx := c.Request.Context()
 
+	// Options may be sent before or after the files, so they are only read
+	// once every part has been consumed
+	var (
+		staged []*usecase.StagedUpload
+		failed []batchFailure
+		parts  int
+		single = true
+		form   = url.Values{}
+	)
+	discard := func() { h.fileUseCase.DiscardUploads(ctx, staged) }
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			discard()
+			if !respondTooLarge(c, err) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to read form: %v", err)})
+			}
+			return
+		}
+
+		name := part.FormName()
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormValueSize+1))
+			part.Close()
+			if err == nil && len(value) > maxFormValueSize {
+				err = fmt.Errorf("field %q is too large", name)
+			}
+			if err != nil {
+				discard()
+				if !respondTooLarge(c, err) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to read form: %v", err)})
+				}
+				return
+			}
+			form.Add(name, string(value))
+			continue
+		}
+		if name != "file" && name != "files" {
+			part.Close()
+			continue
+		}
+
+		single = parts == 0 && name == "file"
+		index := parts
+		parts++
+
+		upload, err := h.fileUseCase.StageUpload(ctx, part, part.FileName(), part.Header.Get("Content-Type"))
+		part.Close()
+		if respondShuttingDown(c, err) {
+			discard()
+			return
+		}
+		if err != nil {
+			failed = append(failed, batchFailure{index: index, fileName: part.FileName(), err: err})
+			continue
+		}
+		staged = append(staged, upload)
+	}
+
+	if parts == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file: no file part in form"})
+		return
+	}
+
+	opts, err := formUploadOptions(form)
+	var atomic bool
+	if err == nil {
+		atomic, err = formBool(form, "all_or_nothing")
+	}
+	if err != nil {
+		discard()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if single {
+		h.respondSingleUpload(c, staged, failed, opts)
+		return
+	}
+
+	// In all-or-nothing mode a file that could not even be stored fails the
+	// whole request before anything is committed
+	var results []usecase.UploadResult
+	if atomic && len(failed) > 0 {
+		discard()
+		results = make([]usecase.UploadResult, len(staged))
+	} else {
+		results, err = h.fileUseCase.CommitUploads(ctx, middleware.GetOwner(c), staged, opts, atomic)
+		if respondShuttingDown(c, err) {
+			discard()
+			return
+		}
+		if err != nil {
+			discard()
+			respondFileError(c, err)
+			return
+		}
+	}
+
+	c.JSON(batchUploadResponse(parts, staged, failed, results, atomic))
+}
+
+// respondSingleUpload answers a form with a single "file" part the way a
+// single file upload always has been answered
+func (h *FileHandler) respondSingleUpload(c *gin.Context, staged []*usecase.StagedUpload, failed []batchFailure, opts usecase.UploadOptions) {
+	var fileEntity *entity.File
+	var err error
+	if len(failed) > 0 {
+		err = failed[0].err
+	} else {
+		var results []usecase.UploadResult
+		results, err = h.fileUseCase.CommitUploads(c.Request.Context(), middleware.GetOwner(c), staged, opts, true)
+		if err != nil {
+			h.fileUseCase.DiscardUploads(c.Request.Context(), staged)
+		} else {
+			fileEntity, err = results[0].File, results[0].Err
+		}
+	}
+
+	if respondShuttingDown(c, err) || respondTooLarge(c, err) {
+		return
+	}
+	if errors.Is(err, usecase.ErrInvalidAttributes) || errors.Is(err, usecase.ErrInvalidFolderName) ||
+		errors.Is(err, usecase.ErrFolderNotFound) || errors.Is(err, usecase.ErrFileNotFound) ||
+		errors.Is(err, usecase.ErrInvalidUploadOptions) || errors.Is(err, usecase.ErrNotAnArchive) ||
+		errors.Is(err, usecase.ErrUnsafeArchive) {
+		respondFileError(c, err)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to create file record: %v", err)})
 		return
 	}
 
+	c.JSON(http.StatusOK, fileResponse(fileEntity))
+}
+
+// batchFailure is a file part of a multi-file upload that could not be
+// stored
+type batchFailure struct {
+	index    int
+	fileName string
+	err      error
+}
+
+// batchUploadResponse builds the per-file results of a multi-file upload,
+// in the order of the parts. It is a 200 when every file was stored and a
+// 207 otherwise.
+func batchUploadResponse(parts int, staged []*usecase.StagedUpload, failed []batchFailure, results []usecase.UploadResult, atomic bool) (int, gin.H) {
+	items := make([]gin.H, parts)
+	for _, f := range failed {
+		item := gin.H{"index": f.index, "file_name": f.fileName, "status": "failed", "error": f.err.Error()}
+		var limitErr *usecase.SizeLimitError
+		if errors.As(f.err, &limitErr) {
+			item["limit_bytes"] = limitErr.Limit
+		}
+		items[f.index] = item
+	}
+
+	next := 0
+	created := 0
+	for i, upload := range staged {
+		for items[next] != nil {
+			next++
+		}
+		item := gin.H{"index": next, "file_name": upload.OriginalName}
+		switch result := results[i]; {
+		case result.Err == nil && result.File != nil:
+			item["status"] = "created"
+			item["file"] = fileResponse(result.File)
+			created++
+		case result.Err == nil || errors.Is(result.Err, usecase.ErrBatchAborted):
+			item["status"] = "rolled_back"
+			item["error"] = usecase.ErrBatchAborted.Error()
+		default:
+			item["status"] = "failed"
+			item["error"] = result.Err.Error()
+		}
+		items[next] = item
+	}
+
+	status := http.StatusOK
+	if created < parts {
+		status = http.StatusMultiStatus
+	}
+	return status, gin.H{
+		"files":          items,
+		"created":        created,
+		"failed":         parts - created,
+		"all_or_nothing": atomic,
+	}
+}
+
+// ImportFromURL godoc
+// @Summary Upload a file from a remote URL
+// @Description Fetch an HTTP(S) URL server-side in the background. The returned upload is followed with the upload status endpoint. Internal destinations are refused.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Param request body ImportFromURLRequest true "Source URL and upload options"
+// @Success 202 {object} UploadStatusResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /files/from-url [post]
+func (h *FileHandler) ImportFromURL(c *gin.Context) {
+	var req struct {
+		URL          string            `json:"url" binding:"required"`
+		FileName     string            `json:"file_name"`
+		Metadata     map[string]string `json:"metadata"`
+		Tags         []string          `json:"tags"`
+		FolderID     *uuid.UUID        `json:"folder_id"`
+		FolderPath   string            `json:"folder_path"`
+		TargetFileID *uuid.UUID        `json:"target_file_id"`
+		Extract      bool              `json:"extract"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := usecase.UploadOptions{
+		Metadata:     req.Metadata,
+		Tags:         req.Tags,
+		FolderID:     req.FolderID,
+		FolderPath:   req.FolderPath,
+		TargetFileID: req.TargetFileID,
+		Extract:      req.Extract,
+	}
+	upload, err := h.fileUseCase.ImportFromURL(c.Request.Context(), middleware.GetOwner(c), req.URL, req.FileName, opts)
+	if respondShuttingDown(c, err) {
+		return
+	}
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"upload_id":  upload.ID,
+		"file_name":  upload.OriginalName,
+		"source_url": upload.SourceURL,
+		"status":     upload.Status,
+		"status_url": "/api/uploads/" + upload.ID.String(),
+		"created_at": upload.CreatedAt,
+	})
+}
+
+// GetFile godoc
+// @Summary Get a file
+// @Description Get the details of a file owned by the caller
+// @Tags files
+// @Produce json
+// @Param file_id path string true "File ID"
+// @Success 200 {object} FileResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/{file_id} [get]
+func (h *FileHandler) GetFile(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	file, err := h.fileUseCase.GetFile(c.Request.Context(), middleware.GetOwner(c), fileID)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, fileResponse(file))
+}
+
+// ListFiles godoc
+// @Summary List files
+// @Description List the caller's files, optionally filtered by tags and metadata
+// @Tags files
+// @Produce json
+// @Param tag query []string false "Only files having all of these tags"
+// @Param metadata[key] query string false "Only files whose metadata key equals the value"
+// @Param page query int false "Page number (default 1)"
+// @Param page_size query int false "Page size (default 20, max 100)"
+// @Success 200 {object} FileListResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /files [get]
+func (h *FileHandler) ListFiles(c *gin.Context) {
+	var req struct {
+		Tags     []string `form:"tag"`
+		Page     int      `form:"page"`
+		PageSize int      `form:"page_size"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list, err := h.fileUseCase.ListFiles(c.Request.Context(), middleware.GetOwner(c), usecase.FileQuery{
+		Tags:     req.Tags,
+		Metadata: c.QueryMap("metadata"),
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	files := make([]gin.H, 0, len(list.Files))
+	for _, file := range list.Files {
+		files = append(files, fileResponse(file))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"file_id":    fileEntity.ID,
-		"file_name":  fileEntity.OriginalName,
-		"size":       fileEntity.Size,
-		"mime_type":  fileEntity.MimeType,
-		"created_at": fileEntity.CreatedAt,
+		"files":     files,
+		"total":     list.Total,
+		"page":      list.Page,
+		"page_size": list.PageSize,
+	})
+}
+
+// UpdateFile godoc
+// @Summary Edit file metadata and tags
+// @Description Merge metadata (null values remove a key) and optionally replace tags
+// @Tags files
+// @Accept json
+// @Produce json
+// @Param file_id path string true "File ID"
+// @Param request body UpdateFileRequest true "Metadata and tags"
+// @Success 200 {object} FileResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/{file_id} [patch]
+func (h *FileHandler) UpdateFile(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	var req struct {
+		Metadata map[string]*string `json:"metadata"`
+		Tags     []string           `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := h.fileUseCase.UpdateFileAttributes(c.Request.Context(), middleware.GetOwner(c), fileID, usecase.FileAttributesPatch{
+		Metadata: req.Metadata,
+		Tags:     req.Tags,
 	})
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, fileResponse(file))
+}
+
+// DeleteFile godoc
+// @Summary Delete a file
+// @Description Delete a file with all of its versions and share links
+// @Tags files
+// @Param file_id path string true "File ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/{file_id} [delete]
+func (h *FileHandler) DeleteFile(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	if err := h.fileUseCase.DeleteFile(c.Request.Context(), middleware.GetOwner(c), fileID); err != nil {
+		respondFileError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// fileResponse is the JSON representation of a file
+func fileResponse(file *entity.File) gin.H {
+	return gin.H{
+		"file_id":    file.ID,
+		"file_name":  file.OriginalName,
+		"folder_id":  file.FolderID,
+		"size":       file.Size,
+		"mime_type":  file.MimeType,
+		"checksum":   file.Checksum,
+		"metadata":   file.Metadata,
+		"tags":       file.Tags,
+		"version":    file.CurrentVersion,
+		"created_at": file.CreatedAt,
+		"updated_at": file.UpdatedAt,
+	}
+}
+
+// maxFormValueSize bounds the size of a non-file field of a streamed
+// multipart upload
+const maxFormValueSize = 64 << 10
+
+// formUploadOptions reads the optional metadata (a JSON object) and tags
+// (repeated and/or comma-separated) fields of a multipart upload form
+func formUploadOptions(form url.Values) (usecase.UploadOptions, error) {
+	var opts usecase.UploadOptions
+
+	if raw := form.Get("metadata"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts.Metadata); err != nil {
+			return opts, fmt.Errorf("metadata must be a JSON object of strings: %v", err)
+		}
+	}
+
+	for _, field := range form["tags"] {
+		opts.Tags = append(opts.Tags, strings.Split(field, ",")...)
+	}
+
+	if raw := form.Get("folder_id"); raw != "" {
+		folderID, err := uuid.Parse(raw)
+		if err != nil {
+			return opts, errors.New("invalid folder ID")
+		}
+		opts.FolderID = &folderID
+	}
+	opts.FolderPath = form.Get("folder_path")
+
+	if raw := form.Get("target_file_id"); raw != "" {
+		targetFileID, err := uuid.Parse(raw)
+		if err != nil {
+			return opts, errors.New("invalid target file ID")
+		}
+		opts.TargetFileID = &targetFileID
+	}
+
+	extract, err := formBool(form, "extract")
+	if err != nil {
+		return opts, err
+	}
+	opts.Extract = extract
+
+	return opts, nil
+}
+
+// formBool reads an optional boolean field of a form
+func formBool(form url.Values, field string) (bool, error) {
+	raw := form.Get(field)
+	if raw == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean", field)
+	}
+	return value, nil
+}
+
+// respondFileError maps errors of the file operations to HTTP statuses
+func respondFileError(c *gin.Context, err error) {
+	if respondTooLarge(c, err) {
+		return
+	}
+	if errors.Is(err, usecase.ErrTooManyUploads) {
+		// Uploads in progress usually complete within this time
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, usecase.ErrFileNotFound), errors.Is(err, usecase.ErrFolderNotFound),
+		errors.Is(err, usecase.ErrVersionNotFound), errors.Is(err, usecase.ErrContentNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, usecase.ErrInvalidAttributes), errors.Is(err, usecase.ErrInvalidFolderName),
+		errors.Is(err, usecase.ErrInvalidUploadOptions), errors.Is(err, usecase.ErrInvalidSourceURL):
+		status = http.StatusBadRequest
+	case errors.Is(err, usecase.ErrNotAnArchive), errors.Is(err, usecase.ErrUnsafeArchive):
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// respondShuttingDown answers with 503 and a Retry-After hint when err reports
+// that the server is draining, so clients retry against another instance
+func respondShuttingDown(c *gin.Context, err error) bool {
+	if !errors.Is(err, usecase.ErrShuttingDown) {
+		return false
+	}
+	c.Header("Retry-After", "5")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	return true
+}
+
+// respondTooLarge answers with 413 when err reports an exceeded size limit,
+// advertising the limit that applies
+func respondTooLarge(c *gin.Context, err error) bool {
+	var limitErr *usecase.SizeLimitError
+	var bodyErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &limitErr):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "limit_bytes": limitErr.Limit})
+	case errors.As(err, &bodyErr):
+		middleware.RespondBodyTooLarge(c, bodyErr.Limit)
+	default:
+		return false
+	}
+	return true
 }
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"fileupload/internal/delivery/http/middleware"
+	"fileupload/internal/usecase"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ListVersions godoc
+// @Summary List file versions
+// @Description List the versions of a file, newest first
+// @Tags files
+// @Produce json
+// @Param file_id path string true "File ID"
+// @Success 200 {object} FileVersionListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/{file_id}/versions [get]
+func (h *FileHandler) ListVersions(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	file, versions, err := h.fileUseCase.ListVersions(c.Request.Context(), middleware.GetOwner(c), fileID)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+
+	items := make([]gin.H, 0, len(versions))
+	for _, version := range versions {
+		items = append(items, gin.H{
+			"version":    version.Version,
+			"file_name":  version.OriginalName,
+			"size":       version.Size,
+			"mime_type":  version.MimeType,
+			"checksum":   version.Checksum,
+			"current":    version.Version == file.CurrentVersion,
+			"created_at": version.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":         file.ID,
+		"current_version": file.CurrentVersion,
+		"versions":        items,
+	})
+}
+
+// PromoteVersion godoc
+// @Summary Restore a file version
+// @Description Make an earlier version the current content of a file
+// @Tags files
+// @Produce json
+// @Param file_id path string true "File ID"
+// @Param version path int true "Version number"
+// @Success 200 {object} FileResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/{file_id}/versions/{version}/promote [post]
+func (h *FileHandler) PromoteVersion(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	file, err := h.fileUseCase.PromoteVersion(c.Request.Context(), middleware.GetOwner(c), fileID, version)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, fileResponse(file))
+}
+
+// DownloadFile godoc
+// @Summary Download a file
+// @Description Download the current content of a file. Range and conditional requests are supported.
+// @Tags files
+// @Produce octet-stream
+// @Param file_id path string true "File ID"
+// @Success 200 {file} binary
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/{file_id}/download [get]
+func (h *FileHandler) DownloadFile(c *gin.Context) {
+	h.serveFileVersion(c, 0)
+}
+
+// DownloadVersion godoc
+// @Summary Download a file version
+// @Description Download the content of a specific version of a file
+// @Tags files
+// @Produce octet-stream
+// @Param file_id path string true "File ID"
+// @Param version path int true "Version number"
+// @Success 200 {file} binary
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/{file_id}/versions/{version}/download [get]
+func (h *FileHandler) DownloadVersion(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+	h.serveFileVersion(c, version)
+}
+
+func (h *FileHandler) serveFileVersion(c *gin.Context, version int) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	content, err := h.fileUseCase.OpenFile(c.Request.Context(), middleware.GetOwner(c), fileID, version)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	defer content.Reader.Close()
+
+	serveContent(c, content)
+}
+
+// serveContent streams stored content as an attachment, letting
+// http.ServeContent handle Range and conditional requests. Content stored
+// compressed is sent as stored, with its Content-Encoding, to clients that
+// accept that coding and do not ask for a range; others get it decompressed.
+func serveContent(c *gin.Context, content *usecase.FileContent) {
+	if content.MimeType != "" {
+		c.Header("Content-Type", content.MimeType)
+	}
+	if content.Checksum != "" {
+		c.Header("X-Checksum-SHA256", content.Checksum)
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": content.Name}))
+
+	var body io.ReadSeeker = content.Reader
+	etag := content.ETag
+	if content.Encoding != "" {
+		c.Header("Vary", "Accept-Encoding")
+		if c.GetHeader("Range") == "" && acceptsEncoding(c.GetHeader("Accept-Encoding"), content.Encoding) {
+			c.Header("Content-Encoding", content.Encoding)
+			body = content.Encoded
+			// Both representations must not share an ETag
+			if etag != "" {
+				etag += "-" + content.Encoding
+			}
+		}
+	}
+	if etag != "" {
+		c.Header("ETag", strconv.Quote(etag))
+	}
+	http.ServeContent(c.Writer, c.Request, content.Name, content.ModTime, body)
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header accepts a
+// content coding, either by name or through "*"
+func acceptsEncoding(header, coding string) bool {
+	accepted := false
+	for _, item := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, coding) && name != "*" {
+			continue
+		}
+		ok := true
+		if key, value, found := strings.Cut(strings.TrimSpace(params), "="); found && strings.EqualFold(strings.TrimSpace(key), "q") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			ok = err == nil && q > 0
+		}
+		// The coding named explicitly takes precedence over "*"
+		if name != "*" {
+			return ok
+		}
+		accepted = ok
+	}
+	return accepted
+}
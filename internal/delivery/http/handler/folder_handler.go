@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"errors"
+	"fileupload/internal/delivery/http/middleware"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/usecase"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// rootFolderParam addresses the top level in folder routes
+const rootFolderParam = "root"
+
+type FolderHandler struct {
+	folderUseCase usecase.FolderUseCase
+}
+
+func NewFolderHandler(folderUseCase usecase.FolderUseCase) *FolderHandler {
+	return &FolderHandler{
+		folderUseCase: folderUseCase,
+	}
+}
+
+// CreateFolder godoc
+// @Summary Create a folder
+// @Description Create a folder by name below parent_id, or every missing folder of path
+// @Tags folders
+// @Accept json
+// @Produce json
+// @Param request body CreateFolderRequest true "Folder name and parent, or path"
+// @Success 201 {object} FolderResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /folders [post]
+func (h *FolderHandler) CreateFolder(c *gin.Context) {
+	var req struct {
+		Name     string     `json:"name"`
+		ParentID *uuid.UUID `json:"parent_id"`
+		Path     string     `json:"path"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var folder *entity.Folder
+	var err error
+	switch {
+	case req.Path != "" && req.Name == "" && req.ParentID == nil:
+		folder, err = h.folderUseCase.CreateFolderPath(c.Request.Context(), middleware.GetOwner(c), req.Path)
+	case req.Path == "" && req.Name != "":
+		folder, err = h.folderUseCase.CreateFolder(c.Request.Context(), middleware.GetOwner(c), req.ParentID, req.Name)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "either name (with optional parent_id) or path is required"})
+		return
+	}
+	if err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, folderResponse(folder))
+}
+
+// GetFolder godoc
+// @Summary Get a folder
+// @Description Get a folder including its recursive size and file count
+// @Tags folders
+// @Produce json
+// @Param folder_id path string true "Folder ID"
+// @Success 200 {object} FolderResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /folders/{folder_id} [get]
+func (h *FolderHandler) GetFolder(c *gin.Context) {
+	folderID, err := uuid.Parse(c.Param("folder_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	folder, err := h.folderUseCase.GetFolder(c.Request.Context(), middleware.GetOwner(c), folderID)
+	if err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, folderResponse(folder))
+}
+
+// RenameFolder godoc
+// @Summary Rename a folder
+// @Tags folders
+// @Accept json
+// @Produce json
+// @Param folder_id path string true "Folder ID"
+// @Param request body RenameFolderRequest true "New name"
+// @Success 200 {object} FolderResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /folders/{folder_id} [patch]
+func (h *FolderHandler) RenameFolder(c *gin.Context) {
+	folderID, err := uuid.Parse(c.Param("folder_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folder, err := h.folderUseCase.RenameFolder(c.Request.Context(), middleware.GetOwner(c), folderID, req.Name)
+	if err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, folderResponse(folder))
+}
+
+// MoveFolder godoc
+// @Summary Move a folder
+// @Description Move a folder below parent_id, or to the top level when parent_id is null
+// @Tags folders
+// @Accept json
+// @Produce json
+// @Param folder_id path string true "Folder ID"
+// @Param request body MoveFolderRequest true "New parent"
+// @Success 200 {object} FolderResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /folders/{folder_id}/move [post]
+func (h *FolderHandler) MoveFolder(c *gin.Context) {
+	folderID, err := uuid.Parse(c.Param("folder_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	var req struct {
+		ParentID *uuid.UUID `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folder, err := h.folderUseCase.MoveFolder(c.Request.Context(), middleware.GetOwner(c), folderID, req.ParentID)
+	if err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, folderResponse(folder))
+}
+
+// DeleteFolder godoc
+// @Summary Delete a folder
+// @Description Delete an empty folder, or with recursive=true the folder and everything in it
+// @Tags folders
+// @Param folder_id path string true "Folder ID"
+// @Param recursive query bool false "Delete subfolders and files too"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /folders/{folder_id} [delete]
+func (h *FolderHandler) DeleteFolder(c *gin.Context) {
+	folderID, err := uuid.Parse(c.Param("folder_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+		return
+	}
+
+	recursive := c.Query("recursive") == "true"
+	if err := h.folderUseCase.DeleteFolder(c.Request.Context(), middleware.GetOwner(c), folderID, recursive); err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListContents godoc
+// @Summary List folder contents
+// @Description List subfolders (with sizes) followed by files; use "root" for the top level
+// @Tags folders
+// @Produce json
+// @Param folder_id path string true "Folder ID or root"
+// @Param page query int false "Page number (default 1)"
+// @Param page_size query int false "Page size (default 20, max 100)"
+// @Success 200 {object} FolderContentsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /folders/{folder_id}/contents [get]
+func (h *FolderHandler) ListContents(c *gin.Context) {
+	var folderID *uuid.UUID
+	if param := c.Param("folder_id"); param != rootFolderParam {
+		id, err := uuid.Parse(param)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid folder ID"})
+			return
+		}
+		folderID = &id
+	}
+
+	var req struct {
+		Page     int `form:"page"`
+		PageSize int `form:"page_size"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contents, err := h.folderUseCase.ListContents(c.Request.Context(), middleware.GetOwner(c), folderID, req.Page, req.PageSize)
+	if err != nil {
+		respondFolderError(c, err)
+		return
+	}
+
+	folders := make([]gin.H, 0, len(contents.Folders))
+	for _, folder := range contents.Folders {
+		folders = append(folders, folderResponse(folder))
+	}
+	files := make([]gin.H, 0, len(contents.Files))
+	for _, file := range contents.Files {
+		files = append(files, fileResponse(file))
+	}
+
+	response := gin.H{
+		"folders":   folders,
+		"files":     files,
+		"total":     contents.Total,
+		"page":      contents.Page,
+		"page_size": contents.PageSize,
+	}
+	if contents.Folder != nil {
+		response["folder"] = folderResponse(contents.Folder)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// folderResponse is the JSON representation of a folder
+func folderResponse(folder *entity.Folder) gin.H {
+	return gin.H{
+		"folder_id":  folder.ID,
+		"name":       folder.Name,
+		"parent_id":  folder.ParentID,
+		"size":       folder.Size,
+		"file_count": folder.FileCount,
+		"created_at": folder.CreatedAt,
+		"updated_at": folder.UpdatedAt,
+	}
+}
+
+// respondFolderError maps errors of the folder operations to HTTP statuses
+func respondFolderError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, usecase.ErrFolderNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, usecase.ErrInvalidFolderName):
+		status = http.StatusBadRequest
+	case errors.Is(err, usecase.ErrFolderExists), errors.Is(err, usecase.ErrFolderNotEmpty),
+		errors.Is(err, usecase.ErrFolderCycle):
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
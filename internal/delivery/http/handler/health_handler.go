@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"fileupload/internal/usecase"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	healthUseCase usecase.HealthUseCase
+}
+
+func NewHealthHandler(healthUseCase usecase.HealthUseCase) *HealthHandler {
+	return &HealthHandler{
+		healthUseCase: healthUseCase,
+	}
+}
+
+// Liveness godoc
+// @Summary Liveness probe
+// @Description Reports that the process is alive; does not check dependencies
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /healthz [get]
+func (h *HealthHandler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// Readiness godoc
+// @Summary Readiness probe
+// @Description Checks the database, upload directories and MinIO (when enabled)
+// @Tags health
+// @Produce json
+// @Success 200 {object} usecase.HealthReport
+// @Failure 503 {object} usecase.HealthReport
+// @Router /readyz [get]
+func (h *HealthHandler) Readiness(c *gin.Context) {
+	report := h.healthUseCase.Readiness(c.Request.Context())
+
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"errors"
+	"fileupload/internal/delivery/http/middleware"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/usecase"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SharePasswordHeader carries the password of a protected share link. A
+// browser form can post it as the password field instead. It is never read
+// from the query string, which ends up in access logs and browser history.
+const SharePasswordHeader = "X-Share-Password"
+
+type ShareHandler struct {
+	shareUseCase usecase.ShareUseCase
+}
+
+func NewShareHandler(shareUseCase usecase.ShareUseCase) *ShareHandler {
+	return &ShareHandler{
+		shareUseCase: shareUseCase,
+	}
+}
+
+// CreateShareLink godoc
+// @Summary Create a share link
+// @Description Create a public link to a file, optionally protected by a password, an expiry and a download limit. The token is only returned once.
+// @Tags shares
+// @Accept json
+// @Produce json
+// @Param file_id path string true "File ID"
+// @Param request body CreateShareLinkRequest false "Link restrictions"
+// @Success 201 {object} ShareLinkResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/{file_id}/shares [post]
+func (h *ShareHandler) CreateShareLink(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	var req struct {
+		Password     string     `json:"password"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+		ExpiresIn    int64      `json:"expires_in" binding:"min=0"`
+		MaxDownloads int        `json:"max_downloads" binding:"min=0"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.ExpiresAt != nil && req.ExpiresIn > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at and expires_in are mutually exclusive"})
+		return
+	}
+
+	opts := usecase.ShareOptions{
+		Password:     req.Password,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		opts.ExpiresAt = &expiresAt
+	}
+
+	link, token, err := h.shareUseCase.CreateShareLink(c.Request.Context(), middleware.GetOwner(c), fileID, opts)
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	response := shareLinkResponse(link)
+	response["token"] = token
+	response["url"] = "/s/" + token
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListShareLinks godoc
+// @Summary List share links
+// @Description List the share links of a file, newest first
+// @Tags shares
+// @Produce json
+// @Param file_id path string true "File ID"
+// @Success 200 {object} ShareLinkListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /files/{file_id}/shares [get]
+func (h *ShareHandler) ListShareLinks(c *gin.Context) {
+	fileID, err := uuid.Parse(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file ID"})
+		return
+	}
+
+	links, err := h.shareUseCase.ListShareLinks(c.Request.Context(), middleware.GetOwner(c), fileID)
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	items := make([]gin.H, 0, len(links))
+	for _, link := range links {
+		items = append(items, shareLinkResponse(link))
+	}
+	c.JSON(http.StatusOK, gin.H{"shares": items})
+}
+
+// RevokeShareLink godoc
+// @Summary Revoke a share link
+// @Description Revoke a share link; its access log is kept
+// @Tags shares
+// @Param share_id path string true "Share link ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /shares/{share_id} [delete]
+func (h *ShareHandler) RevokeShareLink(c *gin.Context) {
+	linkID, err := uuid.Parse(c.Param("share_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share ID"})
+		return
+	}
+
+	if err := h.shareUseCase.RevokeShareLink(c.Request.Context(), middleware.GetOwner(c), linkID); err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListShareAccesses godoc
+// @Summary Get the access log of a share link
+// @Description List every attempt to use a share link, newest first
+// @Tags shares
+// @Produce json
+// @Param share_id path string true "Share link ID"
+// @Param page query int false "Page number (default 1)"
+// @Param page_size query int false "Page size (default 20, max 100)"
+// @Success 200 {object} ShareAccessListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /shares/{share_id}/accesses [get]
+func (h *ShareHandler) ListShareAccesses(c *gin.Context) {
+	linkID, err := uuid.Parse(c.Param("share_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share ID"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	accesses, total, err := h.shareUseCase.ListShareAccesses(c.Request.Context(), middleware.GetOwner(c), linkID, page, pageSize)
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	items := make([]gin.H, 0, len(accesses))
+	for _, access := range accesses {
+		items = append(items, gin.H{
+			"outcome":     access.Outcome,
+			"remote_addr": access.RemoteAddr,
+			"user_agent":  access.UserAgent,
+			"request_id":  access.RequestID,
+			"accessed_at": access.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"accesses": items,
+		"total":    total,
+	})
+}
+
+// DownloadShared godoc
+// @Summary Download a shared file
+// @Description Download the file behind a share link. No owner is required; the link's password, if any, is read from the X-Share-Password header or, for POST, the password form field. Range requests starting past the first byte continue a download and are not counted against the download limit.
+// @Tags shares
+// @Accept x-www-form-urlencoded
+// @Produce octet-stream
+// @Param token path string true "Share token"
+// @Param X-Share-Password header string false "Share link password"
+// @Param password formData string false "Share link password (POST only)"
+// @Success 200 {file} binary
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Router /s/{token} [get]
+// @Router /s/{token} [post]
+func (h *ShareHandler) DownloadShared(c *gin.Context) {
+	password := c.GetHeader(SharePasswordHeader)
+	if password == "" && c.Request.Method == http.MethodPost {
+		password = c.PostForm("password")
+	}
+
+	client := usecase.ShareClient{
+		RemoteAddr:   c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		RequestID:    c.GetString(middleware.RequestIDKey),
+		Continuation: continuesDownload(c.GetHeader("Range"), c.GetHeader("If-Range")),
+	}
+	content, err := h.shareUseCase.OpenSharedFile(c.Request.Context(), c.Param("token"), password, client)
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+	defer content.Reader.Close()
+
+	c.Header("Cache-Control", "no-store")
+	serveContent(c, content)
+}
+
+// continuesDownload reports whether a request only asks for content past the
+// start of a file. Anything that may be answered with the whole file counts
+// as a new download: no range, a range from 0, a suffix range, which can
+// cover the whole file, and an If-Range condition, which falls back to it.
+func continuesDownload(rangeHeader, ifRange string) bool {
+	spec, ok := strings.CutPrefix(rangeHeader, "bytes=")
+	if !ok || ifRange != "" {
+		return false
+	}
+	for _, item := range strings.Split(spec, ",") {
+		first, _, _ := strings.Cut(strings.TrimSpace(item), "-")
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func shareLinkResponse(link *entity.ShareLink) gin.H {
+	return gin.H{
+		"share_id":       link.ID,
+		"file_id":        link.FileID,
+		"has_password":   link.PasswordHash != "",
+		"expires_at":     link.ExpiresAt,
+		"max_downloads":  link.MaxDownloads,
+		"download_count": link.DownloadCount,
+		"revoked_at":     link.RevokedAt,
+		"created_at":     link.CreatedAt,
+	}
+}
+
+// respondShareError maps errors of the share link operations to HTTP statuses
+func respondShareError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrShareNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrShareUnavailable):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrSharePasswordRequired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrInvalidShareOptions):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		respondFileError(c, err)
+	}
+}
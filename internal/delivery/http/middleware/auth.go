@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"fileupload/pkg/logger"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	OwnerKey = "owner"
+
+	anonymousOwner = "anonymous"
+)
+
+// AuthMiddleware identifies the owner of a request from the API key sent as
+// "Authorization: Bearer <key>". apiKeys maps every owner to its key. Requests
+// without a valid key are refused with 401. When no key is configured, every
+// request is served as the anonymous owner, which suits a single-tenant
+// deployment. The owner is never taken from anything the client asserts.
+func AuthMiddleware(apiKeys map[string]string) gin.HandlerFunc {
+	// Keys are looked up by their hash, so that the lookup takes no longer
+	// for a key sharing a prefix with a valid one
+	owners := make(map[[sha256.Size]byte]string, len(apiKeys))
+	for owner, key := range apiKeys {
+		owners[sha256.Sum256([]byte(key))] = owner
+	}
+
+	return func(c *gin.Context) {
+		owner := anonymousOwner
+		if len(owners) > 0 {
+			scheme, key, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+			found, ok := owners[sha256.Sum256([]byte(strings.TrimSpace(key)))]
+			if !strings.EqualFold(scheme, "Bearer") || !ok {
+				c.Header("WWW-Authenticate", `Bearer realm="fileupload"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "a valid API key is required"})
+				return
+			}
+			owner = found
+		}
+
+		c.Set(OwnerKey, owner)
+		ctx := logger.WithFields(c.Request.Context(), logrus.Fields{"owner": owner})
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// GetOwner returns the owner authenticated by AuthMiddleware for the current
+// request
+func GetOwner(c *gin.Context) string {
+	if owner := c.GetString(OwnerKey); owner != "" {
+		return owner
+	}
+	return anonymousOwner
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimitMiddleware caps the size of request bodies at the number of bytes
+// returned by limit, which is read for every request so that it follows
+// configuration reloads. Reads past the limit fail with an
+// *http.MaxBytesError, which handlers answer with 413. Nested limits apply
+// the lowest one. A limit of zero disables it.
+func BodyLimitMiddleware(limit func() int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit := limit(); limit > 0 && c.Request.Body != nil {
+			if c.Request.ContentLength > limit {
+				RespondBodyTooLarge(c, limit)
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Next()
+	}
+}
+
+// RespondBodyTooLarge aborts the request with 413, advertising the limit
+func RespondBodyTooLarge(c *gin.Context, limit int64) {
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error":       "request body too large",
+		"limit_bytes": limit,
+	})
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSOptions is the CORS policy. An allowed origin is "*", an exact origin
+// such as "https://app.example.com" or a wildcard such as
+// "https://*.example.com", which matches any subdomain but not the domain
+// itself. AllowedHeaders may be "*" to allow any request header.
+type CORSOptions struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORSMiddleware applies the CORS policy returned by options, which is read
+// for every request so that it follows configuration reloads. The matched
+// origin is echoed back, so responses vary by Origin. Preflights from an
+// origin, or for a method or header, that is not allowed are refused with
+// 403; other requests from such origins are served without CORS headers,
+// which keeps browsers from exposing the response.
+func CORSMiddleware(options func() CORSOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts := options()
+		c.Writer.Header().Add("Vary", "Origin")
+
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if origin == "" || !opts.allowsOrigin(origin) {
+			if preflight {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+				return
+			}
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		if opts.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(opts.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+			}
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		method := c.GetHeader("Access-Control-Request-Method")
+		if !containsFold(opts.AllowedMethods, method) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "method not allowed by CORS policy"})
+			return
+		}
+		requested := c.GetHeader("Access-Control-Request-Headers")
+		if !containsFold(opts.AllowedHeaders, "*") {
+			for _, name := range strings.Split(requested, ",") {
+				if name = strings.TrimSpace(name); name != "" && !containsFold(opts.AllowedHeaders, name) {
+					c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "header " + name + " not allowed by CORS policy"})
+					return
+				}
+			}
+			requested = strings.Join(opts.AllowedHeaders, ", ")
+		}
+
+		header.Set("Access-Control-Allow-Methods", strings.Join(opts.AllowedMethods, ", "))
+		if requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+		if opts.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+func (o CORSOptions) allowsOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	for _, allowed := range o.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && strings.EqualFold(scheme, u.Scheme) && strings.HasSuffix(strings.ToLower(u.Host), "."+strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
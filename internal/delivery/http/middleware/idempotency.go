@@ -0,0 +1,308 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/usecase"
+	"fileupload/pkg/logger"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	maxFingerprintBodyBytes   = 1 << 20 // body prefix compared before the request runs
+	maxStoredResponseBodySize = 1 << 20
+)
+
+// IdempotencyMiddleware makes a route safe to retry: a request carrying an
+// Idempotency-Key that was already answered gets the stored response back
+// instead of being executed again. Server errors release the key so that
+// the request can be retried for real, and so does a handler that panics.
+func IdempotencyMiddleware(idempotencyUseCase usecase.IdempotencyUseCase) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		hash, digest, err := fingerprintRequest(c.Request)
+		if err != nil {
+			respondBodyReadError(c, err)
+			return
+		}
+
+		record := &entity.IdempotencyRecord{
+			Key:         key,
+			Owner:       GetOwner(c),
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			RequestHash: hash,
+		}
+
+		ctx := c.Request.Context()
+		replay, err := idempotencyUseCase.Begin(ctx, record)
+		switch {
+		case errors.Is(err, usecase.ErrIdempotencyKeyInUse):
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, usecase.ErrIdempotencyKeyReused):
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if replay != nil {
+			// Requests that only share their first bytes are told apart
+			// by the hash of the whole body
+			bodyHash, err := digest.Sum()
+			if err != nil {
+				respondBodyReadError(c, err)
+				return
+			}
+			if replay.BodyHash != bodyHash {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": usecase.ErrIdempotencyKeyReused.Error()})
+				return
+			}
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(replay.StatusCode, replay.ContentType, replay.ResponseBody)
+			c.Abort()
+			return
+		}
+
+		// The outcome must be stored even if the client has gone away,
+		// since that is exactly the case a retry will follow
+		storeCtx := context.WithoutCancel(ctx)
+
+		// Until a response is stored the key is released however the
+		// request ends, a panicking handler included, so that it can be
+		// retried. A process that dies outright stops renewing the lease,
+		// which lets the next retry take the key over.
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := idempotencyUseCase.Release(storeCtx, record); err != nil {
+				logger.FromContext(ctx).WithError(err).WithField("idempotency_key", key).Error("failed to release idempotency key")
+			}
+		}()
+		stopRenewing := renewIdempotencyLease(storeCtx, idempotencyUseCase, record)
+		defer stopRenewing()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		c.Next()
+
+		// Server errors and 429s are transient and must not be replayed
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests || recorder.overflow {
+			return
+		}
+
+		// The rest of the body, if the handler left any, is read so that
+		// the stored hash covers all of it. A body that cannot be read
+		// cannot be recognized later, so its key is released instead.
+		bodyHash, err := digest.Sum()
+		if err != nil {
+			return
+		}
+
+		record.BodyHash = bodyHash
+		record.StatusCode = status
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.ResponseBody = recorder.body.Bytes()
+		if err := idempotencyUseCase.Complete(storeCtx, record); err != nil {
+			logger.FromContext(ctx).WithError(err).WithField("idempotency_key", key).Error("failed to complete idempotency key")
+			return
+		}
+		completed = true
+	}
+}
+
+// renewIdempotencyLease keeps the reservation of record alive until the
+// returned function is called
+func renewIdempotencyLease(ctx context.Context, idempotencyUseCase usecase.IdempotencyUseCase, record *entity.IdempotencyRecord) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(usecase.IdempotencyRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			held, err := idempotencyUseCase.Renew(ctx, record)
+			if err != nil {
+				logger.FromContext(ctx).WithError(err).WithField("idempotency_key", record.Key).Warn("failed to renew idempotency key")
+				continue
+			}
+			if !held {
+				logger.FromContext(ctx).WithField("idempotency_key", record.Key).Warn("idempotency key is no longer held by this request")
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// responseRecorder keeps a copy of the response body while writing it
+type responseRecorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseRecorder) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > maxStoredResponseBodySize {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+func respondBodyReadError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		RespondBodyTooLarge(c, tooLarge.Limit)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+}
+
+// fingerprintRequest hashes what identifies a request before it runs:
+// method, path, media type, body length and up to maxFingerprintBodyBytes of
+// body. The consumed part of the body is put back for the handler. The
+// returned digest hashes the whole body as the handler reads it.
+//
+// A multipart boundary is random per attempt for most clients, so it is
+// normalized away before hashing.
+func fingerprintRequest(r *http.Request) (string, *bodyDigest, error) {
+	h := sha256.New()
+	io.WriteString(h, r.Method+"\n"+r.URL.Path+"\n"+strconv.FormatInt(r.ContentLength, 10)+"\n")
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	io.WriteString(h, mediaType+"\n")
+
+	digest := &bodyDigest{hash: sha256.New(), body: http.NoBody}
+	var w io.Writer = digest.hash
+	var boundary []byte
+	if params["boundary"] != "" {
+		boundary = []byte(params["boundary"])
+		digest.replacer = &boundaryReplacer{w: digest.hash, old: boundary, new: []byte("boundary")}
+		w = digest.replacer
+	}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		digest.body = io.TeeReader(r.Body, w)
+		prefix, err := io.ReadAll(io.LimitReader(digest.body, maxFingerprintBodyBytes))
+		if err != nil {
+			return "", nil, err
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(prefix), digest.body), r.Body}
+
+		if boundary != nil {
+			prefix = bytes.ReplaceAll(prefix, boundary, []byte("boundary"))
+		}
+		h.Write(prefix)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), digest, nil
+}
+
+// bodyDigest hashes a request body as it streams through to the handler
+type bodyDigest struct {
+	hash     hash.Hash
+	replacer *boundaryReplacer // nil unless the body is multipart
+	body     io.Reader         // the body, teed into the hash
+}
+
+// Sum reads whatever the handler left of the body and returns the hash of
+// all of it
+func (d *bodyDigest) Sum() (string, error) {
+	if _, err := io.Copy(io.Discard, d.body); err != nil {
+		return "", err
+	}
+	if d.replacer != nil {
+		d.replacer.flush()
+	}
+	return hex.EncodeToString(d.hash.Sum(nil)), nil
+}
+
+// boundaryReplacer writes to w with every occurrence of old replaced by new,
+// also when an occurrence is split across writes
+type boundaryReplacer struct {
+	w        io.Writer
+	old, new []byte
+	pending  []byte
+}
+
+func (r *boundaryReplacer) Write(p []byte) (int, error) {
+	buf := append(r.pending, p...)
+	for {
+		i := bytes.Index(buf, r.old)
+		if i < 0 {
+			break
+		}
+		r.w.Write(buf[:i])
+		r.w.Write(r.new)
+		buf = buf[i+len(r.old):]
+	}
+	// The end of buf may be the start of an occurrence completed by the
+	// next write, so it is held back
+	keep := min(len(buf), len(r.old)-1)
+	r.w.Write(buf[:len(buf)-keep])
+	r.pending = append([]byte(nil), buf[len(buf)-keep:]...)
+	return len(p), nil
+}
+
+func (r *boundaryReplacer) flush() {
+	r.w.Write(r.pending)
+	r.pending = nil
+}
@@ -7,32 +7,25 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// CORS middleware
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Content-Range, Range")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	}
-}
-
-// CheckContentTypeMiddleware ensures content type is multipart/form-data for file uploads
+// CheckContentTypeMiddleware ensures content type is multipart/form-data for
+// posted chunks and application/octet-stream for raw (PUT) chunks
 func CheckContentTypeMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.Method == "POST" && c.FullPath() == "/api/uploads/:upload_id/chunks" {
+		if c.FullPath() == "/api/uploads/:upload_id/chunks" {
 			contentType := c.GetHeader("Content-Type")
-			if !strings.Contains(contentType, "multipart/form-data") {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Content-Type must be multipart/form-data"})
-				c.Abort()
-				return
+			switch c.Request.Method {
+			case "POST":
+				if !strings.Contains(contentType, "multipart/form-data") {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Content-Type must be multipart/form-data"})
+					c.Abort()
+					return
+				}
+			case "PUT":
+				if !strings.HasPrefix(contentType, "application/octet-stream") {
+					c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/octet-stream"})
+					c.Abort()
+					return
+				}
 			}
 		}
 		c.Next()
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fileupload/internal/usecase"
+	"fileupload/pkg/logger"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+)
+
+// RateLimitMiddleware limits the request rate of every client. On routes
+// behind AuthMiddleware, which it must follow, clients are identified by their
+// owner; anonymous clients by their IP address. The state of the client's bucket is advertised in
+// RateLimit-* headers and refused requests get a 429 with Retry-After.
+// Requests are let through when the limit cannot be checked, so that the
+// store is not a single point of failure.
+func RateLimitMiddleware(rateLimitUseCase usecase.RateLimitUseCase) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		result, err := rateLimitUseCase.Allow(ctx, rateLimitKey(c))
+		if err != nil {
+			logger.FromContext(ctx).WithError(err).Warn("failed to check rate limit, allowing request")
+			c.Next()
+			return
+		}
+
+		if result.Limit > 0 {
+			c.Header(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+			c.Header(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+			c.Header(RateLimitResetHeader, ceilSeconds(result.ResetAfter))
+		}
+		if !result.Allowed {
+			c.Header("Retry-After", ceilSeconds(result.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimitKey identifies the client a request is counted against. Owners
+// keep one bucket wherever their requests come from, and clients sharing an
+// address behind a proxy do not share the bucket of an owner.
+func rateLimitKey(c *gin.Context) string {
+	if owner := c.GetString(OwnerKey); owner != "" && owner != anonymousOwner {
+		return "owner:" + owner
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// ceilSeconds formats d as a whole number of seconds, rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
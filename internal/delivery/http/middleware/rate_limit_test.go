@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name  string
+		owner string
+		want  string
+	}{
+		{"authenticated owner", "alice", "owner:alice"},
+		{"anonymous owner", anonymousOwner, "ip:192.0.2.1"},
+		{"before authentication", "", "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			c.Request.RemoteAddr = "192.0.2.1:1234"
+			if tt.owner != "" {
+				c.Set(OwnerKey, tt.owner)
+			}
+			if got := rateLimitKey(c); got != tt.want {
+				t.Fatalf("rateLimitKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
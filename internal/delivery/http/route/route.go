@@ -1,6 +1,7 @@
 package route
 
 import (
+	"fileupload/config"
 	"fileupload/internal/delivery/http/handler"
 	"fileupload/internal/delivery/http/middleware"
 	"fileupload/internal/usecase"
@@ -8,26 +9,109 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine, fileUseCase usecase.FileUseCase) {
+func SetupRoutes(r *gin.Engine, fileUseCase usecase.FileUseCase, healthUseCase usecase.HealthUseCase, idempotencyUseCase usecase.IdempotencyUseCase, folderUseCase usecase.FolderUseCase, shareUseCase usecase.ShareUseCase, archiveUseCase usecase.ArchiveUseCase, rateLimitUseCase usecase.RateLimitUseCase, cfg *config.Config) {
 	// Apply global middleware
-	r.Use(middleware.CORSMiddleware())
+	r.Use(middleware.RequestIDMiddleware())
+	r.Use(middleware.RequestLoggerMiddleware())
+	r.Use(middleware.CORSMiddleware(func() middleware.CORSOptions {
+		settings := cfg.Settings()
+		return middleware.CORSOptions{
+			AllowedOrigins:   settings.CORSAllowedOrigins,
+			AllowedMethods:   settings.CORSAllowedMethods,
+			AllowedHeaders:   settings.CORSAllowedHeaders,
+			ExposedHeaders:   settings.CORSExposedHeaders,
+			AllowCredentials: settings.CORSAllowCredentials,
+			MaxAge:           settings.CORSMaxAge,
+		}
+	}))
 	r.Use(middleware.CheckContentTypeMiddleware())
+	r.Use(middleware.BodyLimitMiddleware(func() int64 { return cfg.Settings().MaxRequestBodySize }))
 
 	// Create handlers
 	fileHandler := handler.NewFileHandler(fileUseCase)
+	healthHandler := handler.NewHealthHandler(healthUseCase)
+	folderHandler := handler.NewFolderHandler(folderUseCase)
+	shareHandler := handler.NewShareHandler(shareUseCase)
+	archiveHandler := handler.NewArchiveHandler(archiveUseCase)
+
+	// Probe routes
+	r.GET("/healthz", healthHandler.Liveness)
+	r.GET("/readyz", healthHandler.Readiness)
+
+	// Every client is rate limited, except on probe routes. Share links are
+	// anonymous and counted by IP address; API requests by owner.
+	rateLimit := middleware.RateLimitMiddleware(rateLimitUseCase)
+
+	// Public share links
+	r.GET("/s/:token", rateLimit, shareHandler.DownloadShared)
+	r.POST("/s/:token", rateLimit, shareHandler.DownloadShared)
+
+	// Chunk bodies are capped at the chunk size, plus room for the multipart
+	// framing of posted chunks
+	chunkBodyLimit := middleware.BodyLimitMiddleware(func() int64 {
+		if maxChunkSize := cfg.Settings().MaxChunkSize; maxChunkSize > 0 {
+			return maxChunkSize + 64*1024
+		}
+		return 0
+	})
+
+	// Retries of these routes are made safe with an Idempotency-Key header
+	idempotent := middleware.IdempotencyMiddleware(idempotencyUseCase)
 
 	// API routes
-	api := r.Group("/api")
+	api := r.Group("/api", middleware.AuthMiddleware(cfg.APIKeys), rateLimit)
 	{
 		// Upload routes
 		uploads := api.Group("/uploads")
 		{
-			uploads.POST("", fileHandler.InitiateUpload)
+			uploads.POST("", idempotent, fileHandler.InitiateUpload)
 			uploads.GET("/:upload_id", fileHandler.GetUploadStatus)
-			uploads.POST("/:upload_id/chunks", fileHandler.UploadChunk)
-			uploads.POST("/:upload_id/finalize", fileHandler.FinalizeUpload)
+			uploads.POST("/:upload_id/chunks", chunkBodyLimit, fileHandler.UploadChunk)
+			uploads.PUT("/:upload_id/chunks", chunkBodyLimit, fileHandler.UploadChunkRaw)
+			uploads.POST("/:upload_id/finalize", idempotent, fileHandler.FinalizeUpload)
+		}
+
+		// File routes
+		files := api.Group("/files")
+		{
+			files.POST("", idempotent, fileHandler.UploadFile)
+			files.GET("", fileHandler.ListFiles)
+			files.POST("/archive", archiveHandler.CreateArchive)
+			files.POST("/from-url", idempotent, fileHandler.ImportFromURL)
+			files.GET("/:file_id", fileHandler.GetFile)
+			files.PATCH("/:file_id", fileHandler.UpdateFile)
+			files.DELETE("/:file_id", fileHandler.DeleteFile)
+			files.GET("/:file_id/download", fileHandler.DownloadFile)
+			files.GET("/:file_id/versions", fileHandler.ListVersions)
+			files.GET("/:file_id/versions/:version/download", fileHandler.DownloadVersion)
+			files.POST("/:file_id/versions/:version/promote", fileHandler.PromoteVersion)
+			files.POST("/:file_id/shares", shareHandler.CreateShareLink)
+			files.GET("/:file_id/shares", shareHandler.ListShareLinks)
 		}
 
-		api.POST("/files", fileHandler.UploadFile)
+		// Share link routes
+		shares := api.Group("/shares")
+		{
+			shares.DELETE("/:share_id", shareHandler.RevokeShareLink)
+			shares.GET("/:share_id/accesses", shareHandler.ListShareAccesses)
+		}
+
+		// Archive routes
+		archives := api.Group("/archives")
+		{
+			archives.GET("/:archive_id", archiveHandler.GetArchive)
+			archives.GET("/:archive_id/download", archiveHandler.DownloadArchive)
+		}
+
+		// Folder routes
+		folders := api.Group("/folders")
+		{
+			folders.POST("", folderHandler.CreateFolder)
+			folders.GET("/:folder_id", folderHandler.GetFolder)
+			folders.PATCH("/:folder_id", folderHandler.RenameFolder)
+			folders.DELETE("/:folder_id", folderHandler.DeleteFolder)
+			folders.POST("/:folder_id/move", folderHandler.MoveFolder)
+			folders.GET("/:folder_id/contents", folderHandler.ListContents)
+		}
 	}
 }
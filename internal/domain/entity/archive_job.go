@@ -0,0 +1,31 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ArchiveJob is a ZIP archive built in the background. Once completed, the
+// archive can be downloaded until it expires.
+type ArchiveJob struct {
+	ID          uuid.UUID
+	Owner       string
+	Name        string
+	Status      string // pending, running, completed or failed
+	Error       string
+	FileIDs     []uuid.UUID
+	FolderID    *uuid.UUID
+	Path        string
+	Encryption  *Encryption
+	Size        int64
+	FileCount   int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	CompletedAt *time.Time
+	ExpiresAt   time.Time
+	// LeaseHolder is the instance building the archive until
+	// LeaseExpiresAt, which it keeps renewing
+	LeaseHolder    string
+	LeaseExpiresAt *time.Time
+}
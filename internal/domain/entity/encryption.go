@@ -0,0 +1,10 @@
+package entity
+
+// Encryption describes how stored content is encrypted: with a data key,
+// kept wrapped by the master key KeyID, in segments of SegmentSize bytes of
+// plaintext. Content stored in plaintext has no Encryption.
+type Encryption struct {
+	KeyID       string
+	WrappedKey  []byte
+	SegmentSize int
+}
@@ -6,14 +6,31 @@ import (
 	"github.com/google/uuid"
 )
 
+// Storage backends that can hold content. Content on local disk is at its
+// Path; content in MinIO is under its FileName in the configured bucket.
+const (
+	BackendLocal = "local"
+	BackendMinio = "minio"
+)
+
 type File struct {
-	ID           uuid.UUID
-	FileName     string
-	OriginalName string
-	Size         int64
-	MimeType     string
-	Path         string
-	UploadID     uuid.UUID
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID             uuid.UUID
+	FileName       string
+	OriginalName   string
+	Owner          string
+	FolderID       *uuid.UUID
+	Size           int64
+	MimeType       string
+	Metadata       map[string]string
+	Tags           []string
+	Path           string
+	Backend        string // backend holding the content, BackendLocal or BackendMinio
+	Encryption     *Encryption
+	Compression    string // algorithm the content is stored compressed with, empty if it is not
+	CompressedSize int64  // size of the compressed content; Size is always the size as uploaded
+	Checksum       string // hex-encoded SHA-256 of the content as uploaded, empty if unknown
+	UploadID       uuid.UUID
+	CurrentVersion int // number of the version the file currently points to
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
@@ -0,0 +1,27 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileVersion is one stored revision of a file's content. The File itself
+// mirrors the fields of its current version.
+type FileVersion struct {
+	ID             uuid.UUID
+	FileID         uuid.UUID
+	Version        int
+	FileName       string
+	OriginalName   string
+	Size           int64
+	MimeType       string
+	Path           string
+	Backend        string
+	Encryption     *Encryption
+	Compression    string
+	CompressedSize int64
+	Checksum       string
+	UploadID       uuid.UUID
+	CreatedAt      time.Time
+}
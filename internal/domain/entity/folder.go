@@ -0,0 +1,21 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Folder struct {
+	ID        uuid.UUID
+	Owner     string
+	ParentID  *uuid.UUID // nil for top-level folders
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Computed on read: total size and number of files in the folder and
+	// all of its subfolders
+	Size      int64
+	FileCount int64
+}
@@ -0,0 +1,27 @@
+package entity
+
+import "time"
+
+// IdempotencyRecord remembers the outcome of a request sent with an
+// Idempotency-Key so that retries of the same request get the same response
+type IdempotencyRecord struct {
+	Key          string
+	Owner        string
+	Method       string
+	Path         string
+	RequestHash  string // method, path, length and body prefix, known up front
+	BodyHash     string // whole body, known once the request has been read
+	Completed    bool   // false while the original request is still running
+	StatusCode   int
+	ContentType  string
+	ResponseBody []byte
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	// LeaseExpiresAt is renewed while the original request runs. A record
+	// still in progress past its lease belongs to a request that died, and
+	// its key can be taken over.
+	LeaseExpiresAt time.Time
+	// Token identifies the request that reserved the key, so that a request
+	// whose key was taken over cannot touch the new reservation
+	Token string
+}
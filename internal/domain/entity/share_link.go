@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink grants unauthenticated access to a file through a random token.
+// Only a hash of the token is stored; the token itself is shown once, when
+// the link is created.
+type ShareLink struct {
+	ID            uuid.UUID
+	FileID        uuid.UUID
+	Owner         string
+	TokenHash     string
+	PasswordHash  string
+	ExpiresAt     *time.Time
+	MaxDownloads  int // 0 means unlimited
+	DownloadCount int
+	RevokedAt     *time.Time
+	CreatedAt     time.Time
+}
+
+// ShareAccess records one attempt to use a share link
+type ShareAccess struct {
+	ID          uuid.UUID
+	ShareLinkID uuid.UUID
+	Outcome     string
+	RemoteAddr  string
+	UserAgent   string
+	RequestID   string
+	CreatedAt   time.Time
+}
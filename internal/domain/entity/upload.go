@@ -10,12 +10,30 @@ type Upload struct {
 	ID           uuid.UUID
 	FileName     string
 	OriginalName string
+	Owner        string
+	FolderID     *uuid.UUID
+	TargetFileID *uuid.UUID // set when the upload is a new version of an existing file
+	Extract      bool       // expand the uploaded archive into individual files
+	SourceURL    string     // set when the content is fetched from a remote URL (credentials redacted)
 	TotalSize    int64
 	UploadedSize int64
 	MimeType     string
-	Status       string // "pending", "uploading", "completed", "failed"
+	Metadata     map[string]string
+	Tags         []string
+	Status       string // "pending", "uploading", "finalizing", "completed", "failed"
+	FinalizeStep string // while finalizing: "moving", "moved", "replicated"
+	Error        string // why the upload failed
 	TempPath     string
+	FinalPath    string
+	Encryption   *Encryption // of the content at FinalPath, chosen when finalizing starts
+	Compression  string      // of the content at FinalPath, chosen when finalizing starts
+	Checksum     string      // SHA-256 of the content, known once it is at FinalPath
+	Version      int64       // incremented on every update, for optimistic locking
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 	CompletedAt  *time.Time
+	// LeaseHolder is the instance running background work on the upload (an
+	// import or a finalization) until LeaseExpiresAt, which it keeps renewing
+	LeaseHolder    string
+	LeaseExpiresAt *time.Time
 }
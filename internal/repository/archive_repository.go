@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"fileupload/internal/domain/entity"
+	"fileupload/pkg/logger"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ArchiveJobModel struct {
+	ID             uuid.UUID `gorm:"type:uuid;primary_key"`
+	Owner          string    `gorm:"not null;index"`
+	Name           string
+	Status         string `gorm:"index"`
+	Error          string
+	FileIDs        JSONStrings `gorm:"type:jsonb"`
+	FolderID       *uuid.UUID  `gorm:"type:uuid"`
+	Path           string
+	Encryption     *JSONEncryption `gorm:"type:jsonb"`
+	Size           int64
+	FileCount      int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	CompletedAt    *time.Time
+	ExpiresAt      time.Time `gorm:"index"`
+	LeaseHolder    string
+	LeaseExpiresAt *time.Time
+}
+
+type ArchiveRepository interface {
+	CreateArchiveJob(ctx context.Context, job *entity.ArchiveJob) error
+	GetArchiveJob(ctx context.Context, id uuid.UUID) (*entity.ArchiveJob, error)
+	// UpdateArchiveJob stores the status, result and timestamps of job
+	UpdateArchiveJob(ctx context.Context, job *entity.ArchiveJob) error
+	// ClaimArchiveJobLease gives the lease of a job to holder if it is free
+	// or expired, and reports whether it did
+	ClaimArchiveJobLease(ctx context.Context, id uuid.UUID, holder string, until time.Time) (bool, error)
+	// RenewArchiveJobLease extends the lease of a job while holder has it
+	RenewArchiveJobLease(ctx context.Context, id uuid.UUID, holder string, until time.Time) (bool, error)
+	ListArchiveJobsByStatus(ctx context.Context, statuses ...string) ([]*entity.ArchiveJob, error)
+	ListExpiredArchiveJobs(ctx context.Context, now time.Time) ([]*entity.ArchiveJob, error)
+	DeleteArchiveJobs(ctx context.Context, ids []uuid.UUID) error
+}
+
+type archiveRepository struct {
+	db *gorm.DB
+}
+
+func NewArchiveRepository(db *gorm.DB) ArchiveRepository {
+	return &archiveRepository{
+		db: db,
+	}
+}
+
+func (r *archiveRepository) CreateArchiveJob(ctx context.Context, job *entity.ArchiveJob) error {
+	err := r.db.WithContext(ctx).Create(toArchiveJobModel(job)).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("archive_id", job.ID).Error("failed to insert archive job")
+	}
+	return err
+}
+
+func (r *archiveRepository) GetArchiveJob(ctx context.Context, id uuid.UUID) (*entity.ArchiveJob, error) {
+	var model ArchiveJobModel
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&model).Error
+	if err != nil {
+		return nil, err
+	}
+	return toArchiveJobEntity(&model), nil
+}
+
+func (r *archiveRepository) UpdateArchiveJob(ctx context.Context, job *entity.ArchiveJob) error {
+	return r.db.WithContext(ctx).Model(&ArchiveJobModel{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":       job.Status,
+		"error":        job.Error,
+		"path":         job.Path,
+		"encryption":   toJSONEncryption(job.Encryption),
+		"size":         job.Size,
+		"file_count":   job.FileCount,
+		"updated_at":   job.UpdatedAt,
+		"completed_at": job.CompletedAt,
+		"expires_at":   job.ExpiresAt,
+	}).Error
+}
+
+func (r *archiveRepository) ClaimArchiveJobLease(ctx context.Context, id uuid.UUID, holder string, until time.Time) (bool, error) {
+	return claimLease(r.db.WithContext(ctx), &ArchiveJobModel{}, id, holder, until)
+}
+
+func (r *archiveRepository) RenewArchiveJobLease(ctx context.Context, id uuid.UUID, holder string, until time.Time) (bool, error) {
+	return renewLease(r.db.WithContext(ctx), &ArchiveJobModel{}, id, holder, until)
+}
+
+func (r *archiveRepository) ListArchiveJobsByStatus(ctx context.Context, statuses ...string) ([]*entity.ArchiveJob, error) {
+	var models []ArchiveJobModel
+	if err := r.db.WithContext(ctx).Where("status IN ?", statuses).Find(&models).Error; err != nil {
+		return nil, err
+	}
+	return toArchiveJobEntities(models), nil
+}
+
+func (r *archiveRepository) ListExpiredArchiveJobs(ctx context.Context, now time.Time) ([]*entity.ArchiveJob, error) {
+	var models []ArchiveJobModel
+	if err := r.db.WithContext(ctx).Where("expires_at <= ?", now).Find(&models).Error; err != nil {
+		return nil, err
+	}
+	return toArchiveJobEntities(models), nil
+}
+
+func (r *archiveRepository) DeleteArchiveJobs(ctx context.Context, ids []uuid.UUID) error {
+	return r.db.WithContext(ctx).Where("id IN ?", ids).Delete(&ArchiveJobModel{}).Error
+}
+
+func toArchiveJobModel(job *entity.ArchiveJob) *ArchiveJobModel {
+	fileIDs := make(JSONStrings, 0, len(job.FileIDs))
+	for _, id := range job.FileIDs {
+		fileIDs = append(fileIDs, id.String())
+	}
+
+	return &ArchiveJobModel{
+		ID:             job.ID,
+		Owner:          job.Owner,
+		Name:           job.Name,
+		Status:         job.Status,
+		Error:          job.Error,
+		FileIDs:        fileIDs,
+		FolderID:       job.FolderID,
+		Path:           job.Path,
+		Encryption:     toJSONEncryption(job.Encryption),
+		Size:           job.Size,
+		FileCount:      job.FileCount,
+		CreatedAt:      job.CreatedAt,
+		UpdatedAt:      job.UpdatedAt,
+		CompletedAt:    job.CompletedAt,
+		ExpiresAt:      job.ExpiresAt,
+		LeaseHolder:    job.LeaseHolder,
+		LeaseExpiresAt: job.LeaseExpiresAt,
+	}
+}
+
+func toArchiveJobEntity(model *ArchiveJobModel) *entity.ArchiveJob {
+	fileIDs := make([]uuid.UUID, 0, len(model.FileIDs))
+	for _, raw := range model.FileIDs {
+		if id, err := uuid.Parse(raw); err == nil {
+			fileIDs = append(fileIDs, id)
+		}
+	}
+
+	return &entity.ArchiveJob{
+		ID:             model.ID,
+		Owner:          model.Owner,
+		Name:           model.Name,
+		Status:         model.Status,
+		Error:          model.Error,
+		FileIDs:        fileIDs,
+		FolderID:       model.FolderID,
+		Path:           model.Path,
+		Encryption:     toEncryptionEntity(model.Encryption),
+		Size:           model.Size,
+		FileCount:      model.FileCount,
+		CreatedAt:      model.CreatedAt,
+		UpdatedAt:      model.UpdatedAt,
+		CompletedAt:    model.CompletedAt,
+		ExpiresAt:      model.ExpiresAt,
+		LeaseHolder:    model.LeaseHolder,
+		LeaseExpiresAt: model.LeaseExpiresAt,
+	}
+}
+
+func toArchiveJobEntities(models []ArchiveJobModel) []*entity.ArchiveJob {
+	jobs := make([]*entity.ArchiveJob, 0, len(models))
+	for i := range models {
+		jobs = append(jobs, toArchiveJobEntity(&models[i]))
+	}
+	return jobs
+}
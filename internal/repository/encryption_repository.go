@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"fileupload/internal/domain/entity"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Kinds of records that point at stored content
+const (
+	ContentKindFile        = "file"
+	ContentKindFileVersion = "file_version"
+	ContentKindUpload      = "upload"
+	ContentKindArchive     = "archive"
+)
+
+// ContentKinds lists every kind of record that points at stored content
+var ContentKinds = []string{ContentKindFile, ContentKindFileVersion, ContentKindUpload, ContentKindArchive}
+
+// EncryptedContent is the encryption metadata of one record
+type EncryptedContent struct {
+	Kind       string
+	ID         uuid.UUID
+	Encryption *entity.Encryption
+}
+
+// EncryptionRepository finds and updates the encryption metadata of the
+// records of every kind of stored content
+type EncryptionRepository interface {
+	// ListEncryptedContent returns, in ID order, up to limit records of kind
+	// with an ID greater than after whose data key is wrapped by a master
+	// key other than keyID
+	ListEncryptedContent(ctx context.Context, kind, keyID string, after uuid.UUID, limit int) ([]*EncryptedContent, error)
+	// UpdateEncryption stores the encryption metadata of a record, provided
+	// its data key is still wrapped by previousKeyID, and reports whether it
+	// was. Timestamps are left untouched.
+	UpdateEncryption(ctx context.Context, content *EncryptedContent, previousKeyID string) (bool, error)
+}
+
+type encryptionRepository struct {
+	db *gorm.DB
+}
+
+func NewEncryptionRepository(db *gorm.DB) EncryptionRepository {
+	return &encryptionRepository{
+		db: db,
+	}
+}
+
+func (r *encryptionRepository) ListEncryptedContent(ctx context.Context, kind, keyID string, after uuid.UUID, limit int) ([]*EncryptedContent, error) {
+	model, err := contentModel(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ID         uuid.UUID
+		Encryption *JSONEncryption
+	}
+	err = r.db.WithContext(ctx).Model(model).Select("id", "encryption").
+		Where("encryption IS NOT NULL AND encryption->>'key_id' <> ? AND id > ?", keyID, after).
+		Order("id").Limit(limit).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	contents := make([]*EncryptedContent, 0, len(rows))
+	for _, row := range rows {
+		contents = append(contents, &EncryptedContent{
+			Kind:       kind,
+			ID:         row.ID,
+			Encryption: toEncryptionEntity(row.Encryption),
+		})
+	}
+	return contents, nil
+}
+
+func (r *encryptionRepository) UpdateEncryption(ctx context.Context, content *EncryptedContent, previousKeyID string) (bool, error) {
+	model, err := contentModel(content.Kind)
+	if err != nil {
+		return false, err
+	}
+
+	result := r.db.WithContext(ctx).Model(model).
+		Where("id = ? AND encryption->>'key_id' = ?", content.ID, previousKeyID).
+		UpdateColumn("encryption", toJSONEncryption(content.Encryption))
+	return result.RowsAffected > 0, result.Error
+}
+
+func contentModel(kind string) (interface{}, error) {
+	switch kind {
+	case ContentKindFile:
+		return &FileModel{}, nil
+	case ContentKindFileVersion:
+		return &FileVersionModel{}, nil
+	case ContentKindUpload:
+		return &UploadModel{}, nil
+	case ContentKindArchive:
+		return &ArchiveJobModel{}, nil
+	default:
+		return nil, fmt.Errorf("unknown content kind %q", kind)
+	}
+}
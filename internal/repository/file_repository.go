@@ -1,45 +1,159 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"fileupload/internal/domain/entity"
+	"fileupload/pkg/logger"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UploadModel struct {
-	ID           uuid.UUID `gorm:"type:uuid;primary_key"`
-	FileName     string
-	OriginalName string
-	TotalSize    int64
-	UploadedSize int64
-	MimeType     string
-	Status       string
-	TempPath     string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	CompletedAt  *time.Time
+	ID             uuid.UUID `gorm:"type:uuid;primary_key"`
+	FileName       string
+	OriginalName   string
+	Owner          string     `gorm:"index"`
+	FolderID       *uuid.UUID `gorm:"type:uuid"`
+	TargetFileID   *uuid.UUID `gorm:"type:uuid"`
+	Extract        bool       `gorm:"not null;default:false"`
+	SourceURL      string
+	TotalSize      int64
+	UploadedSize   int64
+	MimeType       string
+	Metadata       JSONMap     `gorm:"type:jsonb"`
+	Tags           JSONStrings `gorm:"type:jsonb"`
+	Status         string      `gorm:"index"`
+	FinalizeStep   string
+	Error          string
+	TempPath       string
+	FinalPath      string
+	Encryption     *JSONEncryption `gorm:"type:jsonb"`
+	Compression    string
+	Checksum       string
+	Version        int64 `gorm:"not null;default:0"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	CompletedAt    *time.Time
+	LeaseHolder    string
+	LeaseExpiresAt *time.Time
 }
 
 type FileModel struct {
-	ID           uuid.UUID `gorm:"type:uuid;primary_key"`
-	FileName     string
-	OriginalName string
-	Size         int64
-	MimeType     string
-	Path         string
-	UploadID     uuid.UUID `gorm:"type:uuid"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID             uuid.UUID `gorm:"type:uuid;primary_key"`
+	FileName       string
+	OriginalName   string
+	Owner          string     `gorm:"index"`
+	FolderID       *uuid.UUID `gorm:"type:uuid;index"`
+	Size           int64
+	MimeType       string
+	Metadata       JSONMap     `gorm:"type:jsonb;index:idx_file_models_metadata,type:gin"`
+	Tags           JSONStrings `gorm:"type:jsonb;index:idx_file_models_tags,type:gin"`
+	Path           string
+	Backend        string          `gorm:"not null;default:local"`
+	Encryption     *JSONEncryption `gorm:"type:jsonb"`
+	Compression    string
+	CompressedSize int64
+	Checksum       string
+	UploadID       uuid.UUID `gorm:"type:uuid;index"`
+	CurrentVersion int       `gorm:"not null;default:0"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Upload columns that can be passed to UpdateUpload
+const (
+	UploadFieldUploadedSize = "uploaded_size"
+	UploadFieldStatus       = "status"
+	UploadFieldFinalizeStep = "finalize_step"
+	UploadFieldFinalPath    = "final_path"
+	UploadFieldEncryption   = "encryption"
+	UploadFieldCompression  = "compression"
+	UploadFieldChecksum     = "checksum"
+	UploadFieldCompletedAt  = "completed_at"
+	UploadFieldError        = "error"
+	UploadFieldTotalSize    = "total_size"
+	UploadFieldOriginalName = "original_name"
+	UploadFieldMimeType     = "mime_type"
+)
+
+// ErrVersionConflict is returned by UpdateUpload when the upload was modified
+// by someone else after it was read
+var ErrVersionConflict = errors.New("upload was modified concurrently")
+
+// FileFilter selects the files returned by ListFiles. Every tag and every
+// metadata pair must be present on a file for it to match. When InFolder is
+// set only files directly in FolderID (nil meaning the top level) match.
+type FileFilter struct {
+	Owner    string
+	Tags     []string
+	Metadata map[string]string
+	InFolder bool
+	FolderID *uuid.UUID
+	Limit    int
+	Offset   int
 }
 
 type FileRepository interface {
-	CreateUpload(upload *entity.Upload) error
-	GetUploadByID(id uuid.UUID) (*entity.Upload, error)
-	UpdateUpload(upload *entity.Upload) error
-	CreateFile(file *entity.File) error
-	GetFileByID(id uuid.UUID) (*entity.File, error)
+	CreateUpload(ctx context.Context, upload *entity.Upload) error
+	GetUploadByID(ctx context.Context, id uuid.UUID) (*entity.Upload, error)
+	// UpdateUpload writes only the given fields (plus updated_at) of upload,
+	// provided the stored row still has upload.Version. On success the
+	// version is incremented on both the row and upload.
+	UpdateUpload(ctx context.Context, upload *entity.Upload, fields ...string) error
+	ListUploadsByStatus(ctx context.Context, status string) ([]*entity.Upload, error)
+	// ClaimUploadLease gives the lease of an upload to holder if it is free
+	// or expired, and reports whether it did
+	ClaimUploadLease(ctx context.Context, id uuid.UUID, holder string, until time.Time) (bool, error)
+	// RenewUploadLease extends the lease of an upload while holder has it
+	RenewUploadLease(ctx context.Context, id uuid.UUID, holder string, until time.Time) (bool, error)
+	ReleaseUploadLease(ctx context.Context, id uuid.UUID, holder string) error
+	// LockOwnerUploads serializes the creation of uploads of owner across
+	// every instance until the end of the current transaction
+	LockOwnerUploads(ctx context.Context, owner string) error
+	// CountActiveUploads counts the uploads of owner still in progress that
+	// were updated after since
+	CountActiveUploads(ctx context.Context, owner string, since time.Time) (int64, error)
+	CreateFile(ctx context.Context, file *entity.File) error
+	GetFileByID(ctx context.Context, id uuid.UUID) (*entity.File, error)
+	// GetFileByIDForUpdate loads a file and locks its row until the end of
+	// the current transaction
+	GetFileByIDForUpdate(ctx context.Context, id uuid.UUID) (*entity.File, error)
+	GetFileByUploadID(ctx context.Context, uploadID uuid.UUID) (*entity.File, error)
+	// LockFolder keeps a folder from being deleted until the end of the
+	// current transaction. It returns gorm.ErrRecordNotFound if the folder no
+	// longer exists.
+	LockFolder(ctx context.Context, id uuid.UUID) error
+	ListFiles(ctx context.Context, filter FileFilter) ([]*entity.File, int64, error)
+	// UpdateFileAttributes stores the metadata and tags of file
+	UpdateFileAttributes(ctx context.Context, file *entity.File) error
+	// UpdateFileContent points file at different content: its name, size,
+	// type, path, upload and current version
+	UpdateFileContent(ctx context.Context, file *entity.File) error
+	// DeleteFile deletes a file along with its versions and share links
+	DeleteFile(ctx context.Context, id uuid.UUID) error
+
+	CreateFileVersion(ctx context.Context, version *entity.FileVersion) error
+	GetFileVersion(ctx context.Context, fileID uuid.UUID, version int) (*entity.FileVersion, error)
+	GetFileVersionByUploadID(ctx context.Context, uploadID uuid.UUID) (*entity.FileVersion, error)
+	// ListFileVersions returns the versions of a file, newest first
+	ListFileVersions(ctx context.Context, fileID uuid.UUID) ([]*entity.FileVersion, error)
+	// ListFileIDsWithVersionsBefore returns files having versions created before t
+	ListFileIDsWithVersionsBefore(ctx context.Context, t time.Time) ([]uuid.UUID, error)
+	DeleteFileVersions(ctx context.Context, ids []uuid.UUID) error
+	// BackfillFileVersions records the current content of files created
+	// before versioning existed as their version 1, and returns how many
+	// files it recorded
+	BackfillFileVersions(ctx context.Context) (int64, error)
+	Ping(ctx context.Context) error
+
+	// Transaction runs fn against a repository bound to a single database
+	// transaction, committing if fn returns nil and rolling back otherwise
+	Transaction(ctx context.Context, fn func(repo FileRepository) error) error
 }
 
 type fileRepository struct {
@@ -52,93 +166,363 @@ func NewFileRepository(db *gorm.DB) FileRepository {
 	}
 }
 
-func (r *fileRepository) CreateUpload(upload *entity.Upload) error {
-	model := &UploadModel{
-		ID:           upload.ID,
-		FileName:     upload.FileName,
-		OriginalName: upload.OriginalName,
-		TotalSize:    upload.TotalSize,
-		UploadedSize: upload.UploadedSize,
-		MimeType:     upload.MimeType,
-		Status:       upload.Status,
-		TempPath:     upload.TempPath,
-		CreatedAt:    upload.CreatedAt,
-		UpdatedAt:    upload.UpdatedAt,
-		CompletedAt:  upload.CompletedAt,
+func (r *fileRepository) CreateUpload(ctx context.Context, upload *entity.Upload) error {
+	model := toUploadModel(upload)
+	err := r.db.WithContext(ctx).Create(model).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("upload_id", upload.ID).Error("failed to insert upload")
 	}
-	return r.db.Create(model).Error
+	return err
 }
 
-func (r *fileRepository) GetUploadByID(id uuid.UUID) (*entity.Upload, error) {
+func (r *fileRepository) GetUploadByID(ctx context.Context, id uuid.UUID) (*entity.Upload, error) {
 	var model UploadModel
-	err := r.db.Where("id = ?", id).First(&model).Error
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&model).Error
 	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("upload_id", id).Debug("upload lookup failed")
 		return nil, err
 	}
 
-	return &entity.Upload{
-		ID:           model.ID,
-		FileName:     model.FileName,
-		OriginalName: model.OriginalName,
-		TotalSize:    model.TotalSize,
-		UploadedSize: model.UploadedSize,
-		MimeType:     model.MimeType,
-		Status:       model.Status,
-		TempPath:     model.TempPath,
-		CreatedAt:    model.CreatedAt,
-		UpdatedAt:    model.UpdatedAt,
-		CompletedAt:  model.CompletedAt,
-	}, nil
-}
-
-func (r *fileRepository) UpdateUpload(upload *entity.Upload) error {
-	model := &UploadModel{
-		ID:           upload.ID,
-		FileName:     upload.FileName,
-		OriginalName: upload.OriginalName,
-		TotalSize:    upload.TotalSize,
-		UploadedSize: upload.UploadedSize,
-		MimeType:     upload.MimeType,
-		Status:       upload.Status,
-		TempPath:     upload.TempPath,
-		CreatedAt:    upload.CreatedAt,
-		UpdatedAt:    upload.UpdatedAt,
-		CompletedAt:  upload.CompletedAt,
-	}
-	return r.db.Save(model).Error
-}
-
-func (r *fileRepository) CreateFile(file *entity.File) error {
-	model := &FileModel{
-		ID:           file.ID,
-		FileName:     file.FileName,
-		OriginalName: file.OriginalName,
-		Size:         file.Size,
-		MimeType:     file.MimeType,
-		Path:         file.Path,
-		UploadID:     file.UploadID,
-		CreatedAt:    file.CreatedAt,
-		UpdatedAt:    file.UpdatedAt,
-	}
-	return r.db.Create(model).Error
-}
-
-func (r *fileRepository) GetFileByID(id uuid.UUID) (*entity.File, error) {
+	return toUploadEntity(&model), nil
+}
+
+func (r *fileRepository) UpdateUpload(ctx context.Context, upload *entity.Upload, fields ...string) error {
+	columns := map[string]interface{}{
+		UploadFieldUploadedSize: upload.UploadedSize,
+		UploadFieldStatus:       upload.Status,
+		UploadFieldFinalizeStep: upload.FinalizeStep,
+		UploadFieldFinalPath:    upload.FinalPath,
+		UploadFieldEncryption:   toJSONEncryption(upload.Encryption),
+		UploadFieldCompression:  upload.Compression,
+		UploadFieldChecksum:     upload.Checksum,
+		UploadFieldCompletedAt:  upload.CompletedAt,
+		UploadFieldError:        upload.Error,
+		UploadFieldTotalSize:    upload.TotalSize,
+		UploadFieldOriginalName: upload.OriginalName,
+		UploadFieldMimeType:     upload.MimeType,
+	}
+
+	values := map[string]interface{}{
+		"updated_at": upload.UpdatedAt,
+		"version":    gorm.Expr("version + 1"),
+	}
+	for _, field := range fields {
+		value, ok := columns[field]
+		if !ok {
+			return fmt.Errorf("unknown upload field %q", field)
+		}
+		values[field] = value
+	}
+
+	result := r.db.WithContext(ctx).Model(&UploadModel{}).
+		Where("id = ? AND version = ?", upload.ID, upload.Version).
+		Updates(values)
+	if result.Error != nil {
+		logger.FromContext(ctx).WithError(result.Error).WithField("upload_id", upload.ID).Error("failed to update upload")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		logger.FromContext(ctx).WithField("upload_id", upload.ID).WithField("version", upload.Version).Warn("upload version conflict")
+		return ErrVersionConflict
+	}
+
+	upload.Version++
+	return nil
+}
+
+func (r *fileRepository) ListUploadsByStatus(ctx context.Context, status string) ([]*entity.Upload, error) {
+	var models []UploadModel
+	err := r.db.WithContext(ctx).Where("status = ?", status).Order("updated_at").Find(&models).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("status", status).Error("failed to list uploads")
+		return nil, err
+	}
+
+	uploads := make([]*entity.Upload, 0, len(models))
+	for i := range models {
+		uploads = append(uploads, toUploadEntity(&models[i]))
+	}
+	return uploads, nil
+}
+
+func (r *fileRepository) ClaimUploadLease(ctx context.Context, id uuid.UUID, holder string, until time.Time) (bool, error) {
+	return claimLease(r.db.WithContext(ctx), &UploadModel{}, id, holder, until)
+}
+
+func (r *fileRepository) RenewUploadLease(ctx context.Context, id uuid.UUID, holder string, until time.Time) (bool, error) {
+	return renewLease(r.db.WithContext(ctx), &UploadModel{}, id, holder, until)
+}
+
+func (r *fileRepository) ReleaseUploadLease(ctx context.Context, id uuid.UUID, holder string) error {
+	return releaseLease(r.db.WithContext(ctx), &UploadModel{}, id, holder)
+}
+
+func (r *fileRepository) LockOwnerUploads(ctx context.Context, owner string) error {
+	return r.db.WithContext(ctx).Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "uploads:"+owner).Error
+}
+
+func (r *fileRepository) CountActiveUploads(ctx context.Context, owner string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&UploadModel{}).
+		Where("owner = ? AND status IN ? AND updated_at > ?", owner, []string{"pending", "uploading", "finalizing"}, since).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *fileRepository) CreateFile(ctx context.Context, file *entity.File) error {
+	model := toFileModel(file)
+	err := r.db.WithContext(ctx).Create(model).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("file_id", file.ID).Error("failed to insert file")
+	}
+	return err
+}
+
+func (r *fileRepository) GetFileByID(ctx context.Context, id uuid.UUID) (*entity.File, error) {
+	var model FileModel
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&model).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("file_id", id).Debug("file lookup failed")
+		return nil, err
+	}
+
+	return toFileEntity(&model), nil
+}
+
+func (r *fileRepository) GetFileByIDForUpdate(ctx context.Context, id uuid.UUID) (*entity.File, error) {
 	var model FileModel
-	err := r.db.Where("id = ?", id).First(&model).Error
+	err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&model).Error
 	if err != nil {
 		return nil, err
 	}
+	return toFileEntity(&model), nil
+}
+
+func (r *fileRepository) LockFolder(ctx context.Context, id uuid.UUID) error {
+	var model FolderModel
+	return r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "SHARE"}).Select("id").Where("id = ?", id).First(&model).Error
+}
 
+func (r *fileRepository) GetFileByUploadID(ctx context.Context, uploadID uuid.UUID) (*entity.File, error) {
+	var model FileModel
+	err := r.db.WithContext(ctx).Where("upload_id = ?", uploadID).First(&model).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("upload_id", uploadID).Debug("file lookup by upload failed")
+		return nil, err
+	}
+
+	return toFileEntity(&model), nil
+}
+
+func (r *fileRepository) ListFiles(ctx context.Context, filter FileFilter) ([]*entity.File, int64, error) {
+	query := r.db.WithContext(ctx).Model(&FileModel{}).Where("owner = ?", filter.Owner)
+	if len(filter.Tags) > 0 {
+		query = query.Where("tags @> ?::jsonb", JSONStrings(filter.Tags))
+	}
+	if len(filter.Metadata) > 0 {
+		query = query.Where("metadata @> ?::jsonb", JSONMap(filter.Metadata))
+	}
+	if filter.InFolder {
+		if filter.FolderID == nil {
+			query = query.Where("folder_id IS NULL")
+		} else {
+			query = query.Where("folder_id = ?", *filter.FolderID)
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.FromContext(ctx).WithError(err).Error("failed to count files")
+		return nil, 0, err
+	}
+
+	var models []FileModel
+	err := query.Order("created_at DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&models).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("failed to list files")
+		return nil, 0, err
+	}
+
+	files := make([]*entity.File, 0, len(models))
+	for i := range models {
+		files = append(files, toFileEntity(&models[i]))
+	}
+	return files, total, nil
+}
+
+func (r *fileRepository) UpdateFileAttributes(ctx context.Context, file *entity.File) error {
+	err := r.db.WithContext(ctx).Model(&FileModel{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+		"metadata":   JSONMap(file.Metadata),
+		"tags":       JSONStrings(file.Tags),
+		"updated_at": file.UpdatedAt,
+	}).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("file_id", file.ID).Error("failed to update file attributes")
+	}
+	return err
+}
+
+func (r *fileRepository) UpdateFileContent(ctx context.Context, file *entity.File) error {
+	err := r.db.WithContext(ctx).Model(&FileModel{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+		"file_name":       file.FileName,
+		"original_name":   file.OriginalName,
+		"size":            file.Size,
+		"mime_type":       file.MimeType,
+		"path":            file.Path,
+		"backend":         file.Backend,
+		"encryption":      toJSONEncryption(file.Encryption),
+		"compression":     file.Compression,
+		"compressed_size": file.CompressedSize,
+		"checksum":        file.Checksum,
+		"upload_id":       file.UploadID,
+		"current_version": file.CurrentVersion,
+		"updated_at":      file.UpdatedAt,
+	}).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("file_id", file.ID).Error("failed to update file content")
+	}
+	return err
+}
+
+func (r *fileRepository) DeleteFile(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("file_id = ?", id).Delete(&FileVersionModel{}).Error; err != nil {
+			return err
+		}
+		linkIDs := tx.Model(&ShareLinkModel{}).Select("id").Where("file_id = ?", id)
+		if err := tx.Where("share_link_id IN (?)", linkIDs).Delete(&ShareAccessModel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("file_id = ?", id).Delete(&ShareLinkModel{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&FileModel{}).Error
+	})
+}
+
+// Ping verifies that the database connection is usable
+func (r *fileRepository) Ping(ctx context.Context) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (r *fileRepository) Transaction(ctx context.Context, fn func(repo FileRepository) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&fileRepository{db: tx})
+	})
+}
+
+func toUploadModel(upload *entity.Upload) *UploadModel {
+	return &UploadModel{
+		ID:             upload.ID,
+		FileName:       upload.FileName,
+		OriginalName:   upload.OriginalName,
+		Owner:          upload.Owner,
+		FolderID:       upload.FolderID,
+		TargetFileID:   upload.TargetFileID,
+		Extract:        upload.Extract,
+		SourceURL:      upload.SourceURL,
+		TotalSize:      upload.TotalSize,
+		UploadedSize:   upload.UploadedSize,
+		MimeType:       upload.MimeType,
+		Metadata:       JSONMap(upload.Metadata),
+		Tags:           JSONStrings(upload.Tags),
+		Status:         upload.Status,
+		FinalizeStep:   upload.FinalizeStep,
+		Error:          upload.Error,
+		TempPath:       upload.TempPath,
+		FinalPath:      upload.FinalPath,
+		Encryption:     toJSONEncryption(upload.Encryption),
+		Compression:    upload.Compression,
+		Checksum:       upload.Checksum,
+		Version:        upload.Version,
+		CreatedAt:      upload.CreatedAt,
+		UpdatedAt:      upload.UpdatedAt,
+		CompletedAt:    upload.CompletedAt,
+		LeaseHolder:    upload.LeaseHolder,
+		LeaseExpiresAt: upload.LeaseExpiresAt,
+	}
+}
+
+func toUploadEntity(model *UploadModel) *entity.Upload {
+	return &entity.Upload{
+		ID:             model.ID,
+		FileName:       model.FileName,
+		OriginalName:   model.OriginalName,
+		Owner:          model.Owner,
+		FolderID:       model.FolderID,
+		TargetFileID:   model.TargetFileID,
+		Extract:        model.Extract,
+		SourceURL:      model.SourceURL,
+		TotalSize:      model.TotalSize,
+		UploadedSize:   model.UploadedSize,
+		MimeType:       model.MimeType,
+		Metadata:       model.Metadata,
+		Tags:           model.Tags,
+		Status:         model.Status,
+		FinalizeStep:   model.FinalizeStep,
+		Error:          model.Error,
+		TempPath:       model.TempPath,
+		FinalPath:      model.FinalPath,
+		Encryption:     toEncryptionEntity(model.Encryption),
+		Compression:    model.Compression,
+		Checksum:       model.Checksum,
+		Version:        model.Version,
+		CreatedAt:      model.CreatedAt,
+		UpdatedAt:      model.UpdatedAt,
+		CompletedAt:    model.CompletedAt,
+		LeaseHolder:    model.LeaseHolder,
+		LeaseExpiresAt: model.LeaseExpiresAt,
+	}
+}
+
+func toFileModel(file *entity.File) *FileModel {
+	return &FileModel{
+		ID:             file.ID,
+		FileName:       file.FileName,
+		OriginalName:   file.OriginalName,
+		Owner:          file.Owner,
+		FolderID:       file.FolderID,
+		Size:           file.Size,
+		MimeType:       file.MimeType,
+		Metadata:       JSONMap(file.Metadata),
+		Tags:           JSONStrings(file.Tags),
+		Path:           file.Path,
+		Backend:        file.Backend,
+		Encryption:     toJSONEncryption(file.Encryption),
+		Compression:    file.Compression,
+		CompressedSize: file.CompressedSize,
+		Checksum:       file.Checksum,
+		UploadID:       file.UploadID,
+		CurrentVersion: file.CurrentVersion,
+		CreatedAt:      file.CreatedAt,
+		UpdatedAt:      file.UpdatedAt,
+	}
+}
+
+func toFileEntity(model *FileModel) *entity.File {
 	return &entity.File{
-		ID:           model.ID,
-		FileName:     model.FileName,
-		OriginalName: model.OriginalName,
-		Size:         model.Size,
-		MimeType:     model.MimeType,
-		Path:         model.Path,
-		UploadID:     model.UploadID,
-		CreatedAt:    model.CreatedAt,
-		UpdatedAt:    model.UpdatedAt,
-	}, nil
+		ID:             model.ID,
+		FileName:       model.FileName,
+		OriginalName:   model.OriginalName,
+		Owner:          model.Owner,
+		FolderID:       model.FolderID,
+		Size:           model.Size,
+		MimeType:       model.MimeType,
+		Metadata:       model.Metadata,
+		Tags:           model.Tags,
+		Path:           model.Path,
+		Backend:        model.Backend,
+		Encryption:     toEncryptionEntity(model.Encryption),
+		Compression:    model.Compression,
+		CompressedSize: model.CompressedSize,
+		Checksum:       model.Checksum,
+		UploadID:       model.UploadID,
+		CurrentVersion: model.CurrentVersion,
+		CreatedAt:      model.CreatedAt,
+		UpdatedAt:      model.UpdatedAt,
+	}
 }
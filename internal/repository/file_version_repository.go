@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fileupload/internal/domain/entity"
+	"fileupload/pkg/logger"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// ErrVersionTaken is returned by CreateFileVersion when the file already has
+// a version with the same number, created concurrently
+var ErrVersionTaken = errors.New("file version number already taken")
+
+type FileVersionModel struct {
+	ID             uuid.UUID `gorm:"type:uuid;primary_key"`
+	FileID         uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_file_version"`
+	Version        int       `gorm:"not null;uniqueIndex:idx_file_version"`
+	FileName       string
+	OriginalName   string
+	Size           int64
+	MimeType       string
+	Path           string
+	Backend        string          `gorm:"not null;default:local"`
+	Encryption     *JSONEncryption `gorm:"type:jsonb"`
+	Compression    string
+	CompressedSize int64
+	Checksum       string
+	UploadID       uuid.UUID `gorm:"type:uuid;index"`
+	CreatedAt      time.Time `gorm:"index"`
+}
+
+func (r *fileRepository) CreateFileVersion(ctx context.Context, version *entity.FileVersion) error {
+	err := r.db.WithContext(ctx).Create(toFileVersionModel(version)).Error
+	if isUniqueViolation(err, "idx_file_version") {
+		return fmt.Errorf("%w: version %d of file %s", ErrVersionTaken, version.Version, version.FileID)
+	}
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("file_id", version.FileID).Error("failed to insert file version")
+	}
+	return err
+}
+
+func (r *fileRepository) BackfillFileVersions(ctx context.Context) (int64, error) {
+	var backfilled int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(`
+			INSERT INTO file_version_models (id, file_id, version, file_name, original_name, size, mime_type,
+				path, backend, encryption, compression, compressed_size, checksum, upload_id, created_at)
+			SELECT gen_random_uuid(), f.id, 1, f.file_name, f.original_name, f.size, f.mime_type,
+				f.path, f.backend, f.encryption, f.compression, f.compressed_size, f.checksum, f.upload_id, f.created_at
+			FROM file_models f
+			WHERE NOT EXISTS (SELECT 1 FROM file_version_models v WHERE v.file_id = f.id)
+			ON CONFLICT DO NOTHING`)
+		if result.Error != nil {
+			return result.Error
+		}
+		backfilled = result.RowsAffected
+		return tx.Model(&FileModel{}).Where("current_version = ?", 0).Update("current_version", 1).Error
+	})
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("failed to backfill file versions")
+	}
+	return backfilled, err
+}
+
+func (r *fileRepository) GetFileVersion(ctx context.Context, fileID uuid.UUID, version int) (*entity.FileVersion, error) {
+	var model FileVersionModel
+	err := r.db.WithContext(ctx).Where("file_id = ? AND version = ?", fileID, version).First(&model).Error
+	if err != nil {
+		return nil, err
+	}
+	return toFileVersionEntity(&model), nil
+}
+
+func (r *fileRepository) GetFileVersionByUploadID(ctx context.Context, uploadID uuid.UUID) (*entity.FileVersion, error) {
+	var model FileVersionModel
+	err := r.db.WithContext(ctx).Where("upload_id = ?", uploadID).First(&model).Error
+	if err != nil {
+		return nil, err
+	}
+	return toFileVersionEntity(&model), nil
+}
+
+func (r *fileRepository) ListFileVersions(ctx context.Context, fileID uuid.UUID) ([]*entity.FileVersion, error) {
+	var models []FileVersionModel
+	err := r.db.WithContext(ctx).Where("file_id = ?", fileID).Order("version DESC").Find(&models).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("file_id", fileID).Error("failed to list file versions")
+		return nil, err
+	}
+
+	versions := make([]*entity.FileVersion, 0, len(models))
+	for i := range models {
+		versions = append(versions, toFileVersionEntity(&models[i]))
+	}
+	return versions, nil
+}
+
+func (r *fileRepository) ListFileIDsWithVersionsBefore(ctx context.Context, t time.Time) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&FileVersionModel{}).
+		Where("created_at < ?", t).Distinct().Pluck("file_id", &ids).Error
+	return ids, err
+}
+
+func (r *fileRepository) DeleteFileVersions(ctx context.Context, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Where("id IN ?", ids).Delete(&FileVersionModel{}).Error
+}
+
+func toFileVersionModel(version *entity.FileVersion) *FileVersionModel {
+	return &FileVersionModel{
+		ID:             version.ID,
+		FileID:         version.FileID,
+		Version:        version.Version,
+		FileName:       version.FileName,
+		OriginalName:   version.OriginalName,
+		Size:           version.Size,
+		MimeType:       version.MimeType,
+		Path:           version.Path,
+		Backend:        version.Backend,
+		Encryption:     toJSONEncryption(version.Encryption),
+		Compression:    version.Compression,
+		CompressedSize: version.CompressedSize,
+		Checksum:       version.Checksum,
+		UploadID:       version.UploadID,
+		CreatedAt:      version.CreatedAt,
+	}
+}
+
+func toFileVersionEntity(model *FileVersionModel) *entity.FileVersion {
+	return &entity.FileVersion{
+		ID:             model.ID,
+		FileID:         model.FileID,
+		Version:        model.Version,
+		FileName:       model.FileName,
+		OriginalName:   model.OriginalName,
+		Size:           model.Size,
+		MimeType:       model.MimeType,
+		Path:           model.Path,
+		Backend:        model.Backend,
+		Encryption:     toEncryptionEntity(model.Encryption),
+		Compression:    model.Compression,
+		CompressedSize: model.CompressedSize,
+		Checksum:       model.Checksum,
+		UploadID:       model.UploadID,
+		CreatedAt:      model.CreatedAt,
+	}
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique violation of
+// the given constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
@@ -0,0 +1,316 @@
+package repository
+
+import (
+	"context"
+	"fileupload/internal/domain/entity"
+	"fileupload/pkg/logger"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// FolderModel names are unique among the siblings of the same owner. Two
+// partial indexes are needed because NULL parents never compare equal.
+type FolderModel struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key"`
+	Owner     string     `gorm:"not null;uniqueIndex:idx_folder_sibling_name,where:parent_id IS NOT NULL;uniqueIndex:idx_folder_root_name,where:parent_id IS NULL"`
+	ParentID  *uuid.UUID `gorm:"type:uuid;index;uniqueIndex:idx_folder_sibling_name"`
+	Name      string     `gorm:"not null;uniqueIndex:idx_folder_sibling_name;uniqueIndex:idx_folder_root_name"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// FolderStats is the recursive size of a folder
+type FolderStats struct {
+	Size      int64
+	FileCount int64
+}
+
+type FolderRepository interface {
+	CreateFolder(ctx context.Context, folder *entity.Folder) error
+	GetFolderByID(ctx context.Context, id uuid.UUID) (*entity.Folder, error)
+	// GetFolderByName finds a folder by name among the owner's folders
+	// directly below parentID (nil meaning the top level)
+	GetFolderByName(ctx context.Context, owner string, parentID *uuid.UUID, name string) (*entity.Folder, error)
+	// UpdateFolder stores the name and parent of folder
+	UpdateFolder(ctx context.Context, folder *entity.Folder) error
+	ListChildFolders(ctx context.Context, owner string, parentID *uuid.UUID, limit, offset int) ([]*entity.Folder, int64, error)
+	// DescendantFolderIDs returns id and the IDs of every folder below it
+	DescendantFolderIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	// LockFolderTree does what DescendantFolderIDs does, and locks the rows
+	// of those folders and of the files in them until the end of the current
+	// transaction
+	LockFolderTree(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	// FolderTree returns the folder id and every folder below it
+	FolderTree(ctx context.Context, id uuid.UUID) ([]*entity.Folder, error)
+	// FolderStats returns the recursive size of each of the given folders
+	FolderStats(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]FolderStats, error)
+	ListFilesInFolders(ctx context.Context, folderIDs []uuid.UUID) ([]*entity.File, error)
+	// ListFileVersionsInFolders returns the versions of every file in the
+	// given folders
+	ListFileVersionsInFolders(ctx context.Context, folderIDs []uuid.UUID) ([]*entity.FileVersion, error)
+	// DeleteFolders deletes the given folders and every file (with its
+	// versions and share links) inside them
+	DeleteFolders(ctx context.Context, ids []uuid.UUID) error
+	// DeleteEmptyFolders deletes those of the given folders that contain
+	// nothing, or only other such folders, and leaves the rest alone
+	DeleteEmptyFolders(ctx context.Context, ids []uuid.UUID) error
+
+	// Transaction runs fn against a repository bound to a single database
+	// transaction, committing if fn returns nil and rolling back otherwise
+	Transaction(ctx context.Context, fn func(repo FolderRepository) error) error
+}
+
+type folderRepository struct {
+	db *gorm.DB
+}
+
+func NewFolderRepository(db *gorm.DB) FolderRepository {
+	return &folderRepository{
+		db: db,
+	}
+}
+
+func (r *folderRepository) CreateFolder(ctx context.Context, folder *entity.Folder) error {
+	err := r.db.WithContext(ctx).Create(toFolderModel(folder)).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("folder_id", folder.ID).Error("failed to insert folder")
+	}
+	return err
+}
+
+func (r *folderRepository) GetFolderByID(ctx context.Context, id uuid.UUID) (*entity.Folder, error) {
+	var model FolderModel
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&model).Error
+	if err != nil {
+		return nil, err
+	}
+	return toFolderEntity(&model), nil
+}
+
+func (r *folderRepository) GetFolderByName(ctx context.Context, owner string, parentID *uuid.UUID, name string) (*entity.Folder, error) {
+	var model FolderModel
+	query := r.db.WithContext(ctx).Where("owner = ? AND name = ?", owner, name)
+	query = whereParent(query, parentID)
+	if err := query.First(&model).Error; err != nil {
+		return nil, err
+	}
+	return toFolderEntity(&model), nil
+}
+
+func (r *folderRepository) UpdateFolder(ctx context.Context, folder *entity.Folder) error {
+	err := r.db.WithContext(ctx).Model(&FolderModel{}).Where("id = ?", folder.ID).Updates(map[string]interface{}{
+		"name":       folder.Name,
+		"parent_id":  folder.ParentID,
+		"updated_at": folder.UpdatedAt,
+	}).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("folder_id", folder.ID).Error("failed to update folder")
+	}
+	return err
+}
+
+func (r *folderRepository) ListChildFolders(ctx context.Context, owner string, parentID *uuid.UUID, limit, offset int) ([]*entity.Folder, int64, error) {
+	query := whereParent(r.db.WithContext(ctx).Model(&FolderModel{}).Where("owner = ?", owner), parentID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var models []FolderModel
+	if err := query.Order("name").Limit(limit).Offset(offset).Find(&models).Error; err != nil {
+		return nil, 0, err
+	}
+
+	folders := make([]*entity.Folder, 0, len(models))
+	for i := range models {
+		folders = append(folders, toFolderEntity(&models[i]))
+	}
+	return folders, total, nil
+}
+
+func (r *folderRepository) DescendantFolderIDs(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Raw(`
+		WITH RECURSIVE tree AS (
+			SELECT id FROM folder_models WHERE id = ?
+			UNION ALL
+			SELECT f.id FROM folder_models f JOIN tree t ON f.parent_id = t.id
+		)
+		SELECT id FROM tree`, id).Scan(&ids).Error
+	return ids, err
+}
+
+func (r *folderRepository) LockFolderTree(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Raw(`
+		WITH RECURSIVE tree AS (
+			SELECT id FROM folder_models WHERE id = ?
+			UNION ALL
+			SELECT f.id FROM folder_models f JOIN tree t ON f.parent_id = t.id
+		)
+		SELECT f.id FROM folder_models f WHERE f.id IN (SELECT id FROM tree) FOR UPDATE`, id).Scan(&ids).Error
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+
+	var fileIDs []uuid.UUID
+	err = r.db.WithContext(ctx).Raw(`SELECT id FROM file_models WHERE folder_id IN ? FOR UPDATE`, ids).Scan(&fileIDs).Error
+	return ids, err
+}
+
+func (r *folderRepository) FolderTree(ctx context.Context, id uuid.UUID) ([]*entity.Folder, error) {
+	var models []FolderModel
+	err := r.db.WithContext(ctx).Raw(`
+		WITH RECURSIVE tree AS (
+			SELECT * FROM folder_models WHERE id = ?
+			UNION ALL
+			SELECT f.* FROM folder_models f JOIN tree t ON f.parent_id = t.id
+		)
+		SELECT * FROM tree`, id).Scan(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	folders := make([]*entity.Folder, 0, len(models))
+	for i := range models {
+		folders = append(folders, toFolderEntity(&models[i]))
+	}
+	return folders, nil
+}
+
+func (r *folderRepository) FolderStats(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]FolderStats, error) {
+	stats := make(map[uuid.UUID]FolderStats, len(ids))
+	if len(ids) == 0 {
+		return stats, nil
+	}
+
+	var rows []struct {
+		RootID    uuid.UUID
+		Size      int64
+		FileCount int64
+	}
+	err := r.db.WithContext(ctx).Raw(`
+		WITH RECURSIVE tree AS (
+			SELECT id, id AS root_id FROM folder_models WHERE id IN ?
+			UNION ALL
+			SELECT f.id, t.root_id FROM folder_models f JOIN tree t ON f.parent_id = t.id
+		)
+		SELECT t.root_id, COALESCE(SUM(fm.size), 0) AS size, COUNT(fm.id) AS file_count
+		FROM tree t LEFT JOIN file_models fm ON fm.folder_id = t.id
+		GROUP BY t.root_id`, ids).Scan(&rows).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("failed to compute folder sizes")
+		return nil, err
+	}
+
+	for _, row := range rows {
+		stats[row.RootID] = FolderStats{Size: row.Size, FileCount: row.FileCount}
+	}
+	return stats, nil
+}
+
+func (r *folderRepository) ListFilesInFolders(ctx context.Context, folderIDs []uuid.UUID) ([]*entity.File, error) {
+	var models []FileModel
+	if err := r.db.WithContext(ctx).Where("folder_id IN ?", folderIDs).Find(&models).Error; err != nil {
+		return nil, err
+	}
+
+	files := make([]*entity.File, 0, len(models))
+	for i := range models {
+		files = append(files, toFileEntity(&models[i]))
+	}
+	return files, nil
+}
+
+func (r *folderRepository) ListFileVersionsInFolders(ctx context.Context, folderIDs []uuid.UUID) ([]*entity.FileVersion, error) {
+	var models []FileVersionModel
+	fileIDs := r.db.Model(&FileModel{}).Select("id").Where("folder_id IN ?", folderIDs)
+	if err := r.db.WithContext(ctx).Where("file_id IN (?)", fileIDs).Find(&models).Error; err != nil {
+		return nil, err
+	}
+
+	versions := make([]*entity.FileVersion, 0, len(models))
+	for i := range models {
+		versions = append(versions, toFileVersionEntity(&models[i]))
+	}
+	return versions, nil
+}
+
+func (r *folderRepository) DeleteFolders(ctx context.Context, ids []uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		fileIDs := tx.Model(&FileModel{}).Select("id").Where("folder_id IN ?", ids)
+		if err := tx.Where("file_id IN (?)", fileIDs).Delete(&FileVersionModel{}).Error; err != nil {
+			return err
+		}
+		linkIDs := tx.Model(&ShareLinkModel{}).Select("id").Where("file_id IN (?)", fileIDs)
+		if err := tx.Where("share_link_id IN (?)", linkIDs).Delete(&ShareAccessModel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("file_id IN (?)", fileIDs).Delete(&ShareLinkModel{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("folder_id IN ?", ids).Delete(&FileModel{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&FolderModel{}).Error
+	})
+}
+
+func (r *folderRepository) DeleteEmptyFolders(ctx context.Context, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	// Every pass deletes the innermost empty folders, which may leave their
+	// parents empty for the next one
+	for {
+		result := r.db.WithContext(ctx).Exec(`
+			DELETE FROM folder_models f
+			WHERE f.id IN ?
+				AND NOT EXISTS (SELECT 1 FROM file_models fm WHERE fm.folder_id = f.id)
+				AND NOT EXISTS (SELECT 1 FROM folder_models c WHERE c.parent_id = f.id)`, ids)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+	}
+}
+
+func (r *folderRepository) Transaction(ctx context.Context, fn func(repo FolderRepository) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&folderRepository{db: tx})
+	})
+}
+
+func whereParent(query *gorm.DB, parentID *uuid.UUID) *gorm.DB {
+	if parentID == nil {
+		return query.Where("parent_id IS NULL")
+	}
+	return query.Where("parent_id = ?", *parentID)
+}
+
+func toFolderModel(folder *entity.Folder) *FolderModel {
+	return &FolderModel{
+		ID:        folder.ID,
+		Owner:     folder.Owner,
+		ParentID:  folder.ParentID,
+		Name:      folder.Name,
+		CreatedAt: folder.CreatedAt,
+		UpdatedAt: folder.UpdatedAt,
+	}
+}
+
+func toFolderEntity(model *FolderModel) *entity.Folder {
+	return &entity.Folder{
+		ID:        model.ID,
+		Owner:     model.Owner,
+		ParentID:  model.ParentID,
+		Name:      model.Name,
+		CreatedAt: model.CreatedAt,
+		UpdatedAt: model.UpdatedAt,
+	}
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fileupload/internal/domain/entity"
+	"fileupload/pkg/logger"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IdempotencyModel struct {
+	Owner          string `gorm:"primaryKey"`
+	Key            string `gorm:"primaryKey"`
+	Method         string
+	Path           string
+	RequestHash    string
+	BodyHash       string
+	Completed      bool
+	StatusCode     int
+	ContentType    string
+	ResponseBody   []byte
+	CreatedAt      time.Time
+	ExpiresAt      time.Time `gorm:"index"`
+	LeaseExpiresAt time.Time
+	Token          string
+}
+
+type IdempotencyRepository interface {
+	// Reserve stores record unless a live record already exists for the same
+	// owner and key, in which case that record is returned instead
+	Reserve(ctx context.Context, record *entity.IdempotencyRecord) (existing *entity.IdempotencyRecord, err error)
+	// Renew extends the lease of a record still in progress under token. It
+	// returns false if the record is no longer held, e.g. because it was
+	// taken over.
+	Renew(ctx context.Context, owner, key, token string, leaseExpiresAt time.Time) (bool, error)
+	// Complete stores the response of a record still reserved under its
+	// token, and returns ErrIdempotencyKeyLost if it no longer is
+	Complete(ctx context.Context, record *entity.IdempotencyRecord) error
+	// Delete deletes a record still in progress under token
+	Delete(ctx context.Context, owner, key, token string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// ErrIdempotencyKeyLost is returned when a request no longer holds the key
+// it reserved
+var ErrIdempotencyKeyLost = errors.New("idempotency key is no longer held by this request")
+
+type idempotencyRepository struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) IdempotencyRepository {
+	return &idempotencyRepository{
+		db: db,
+	}
+}
+
+func (r *idempotencyRepository) Reserve(ctx context.Context, record *entity.IdempotencyRecord) (*entity.IdempotencyRecord, error) {
+	db := r.db.WithContext(ctx)
+
+	// An existing record can be released between the insert and the lookup,
+	// in which case the insert is simply tried again
+	for attempt := 0; attempt < 3; attempt++ {
+		// An expired record no longer protects its key, nor does one left in
+		// progress by a request whose lease ran out
+		now := time.Now()
+		err := db.Where("owner = ? AND key = ?", record.Owner, record.Key).
+			Where("expires_at <= ? OR (completed = ? AND lease_expires_at <= ?)", now, false, now).
+			Delete(&IdempotencyModel{}).Error
+		if err != nil {
+			return nil, err
+		}
+
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(toIdempotencyModel(record))
+		if result.Error != nil {
+			logger.FromContext(ctx).WithError(result.Error).WithField("idempotency_key", record.Key).Error("failed to reserve idempotency key")
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return nil, nil
+		}
+
+		var existing IdempotencyModel
+		err = db.Where("owner = ? AND key = ?", record.Owner, record.Key).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return toIdempotencyEntity(&existing), nil
+	}
+
+	return nil, errors.New("idempotency key kept changing while reserving it")
+}
+
+func (r *idempotencyRepository) Renew(ctx context.Context, owner, key, token string, leaseExpiresAt time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&IdempotencyModel{}).
+		Where("owner = ? AND key = ? AND token = ? AND completed = ?", owner, key, token, false).
+		Update("lease_expires_at", leaseExpiresAt)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *idempotencyRepository) Complete(ctx context.Context, record *entity.IdempotencyRecord) error {
+	result := r.db.WithContext(ctx).Model(&IdempotencyModel{}).
+		Where("owner = ? AND key = ? AND token = ? AND completed = ?", record.Owner, record.Key, record.Token, false).
+		Updates(map[string]interface{}{
+			"completed":     true,
+			"body_hash":     record.BodyHash,
+			"status_code":   record.StatusCode,
+			"content_type":  record.ContentType,
+			"response_body": record.ResponseBody,
+		})
+	if result.Error != nil {
+		logger.FromContext(ctx).WithError(result.Error).WithField("idempotency_key", record.Key).Error("failed to store idempotent response")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+func (r *idempotencyRepository) Delete(ctx context.Context, owner, key, token string) error {
+	return r.db.WithContext(ctx).
+		Where("owner = ? AND key = ? AND token = ? AND completed = ?", owner, key, token, false).
+		Delete(&IdempotencyModel{}).Error
+}
+
+func (r *idempotencyRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&IdempotencyModel{})
+	return result.RowsAffected, result.Error
+}
+
+func toIdempotencyModel(record *entity.IdempotencyRecord) *IdempotencyModel {
+	return &IdempotencyModel{
+		Owner:          record.Owner,
+		Key:            record.Key,
+		Method:         record.Method,
+		Path:           record.Path,
+		RequestHash:    record.RequestHash,
+		BodyHash:       record.BodyHash,
+		Completed:      record.Completed,
+		StatusCode:     record.StatusCode,
+		ContentType:    record.ContentType,
+		ResponseBody:   record.ResponseBody,
+		CreatedAt:      record.CreatedAt,
+		ExpiresAt:      record.ExpiresAt,
+		LeaseExpiresAt: record.LeaseExpiresAt,
+		Token:          record.Token,
+	}
+}
+
+func toIdempotencyEntity(model *IdempotencyModel) *entity.IdempotencyRecord {
+	return &entity.IdempotencyRecord{
+		Key:            model.Key,
+		Owner:          model.Owner,
+		Method:         model.Method,
+		Path:           model.Path,
+		RequestHash:    model.RequestHash,
+		BodyHash:       model.BodyHash,
+		Completed:      model.Completed,
+		StatusCode:     model.StatusCode,
+		ContentType:    model.ContentType,
+		ResponseBody:   model.ResponseBody,
+		CreatedAt:      model.CreatedAt,
+		ExpiresAt:      model.ExpiresAt,
+		LeaseExpiresAt: model.LeaseExpiresAt,
+		Token:          model.Token,
+	}
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fileupload/internal/domain/entity"
+	"fmt"
+)
+
+// JSONMap is a string map stored as a JSONB object
+type JSONMap map[string]string
+
+func (m JSONMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (m *JSONMap) Scan(value interface{}) error {
+	data, err := jsonBytes(value)
+	if err != nil || data == nil {
+		*m = nil
+		return err
+	}
+	return json.Unmarshal(data, m)
+}
+
+// JSONStrings is a string slice stored as a JSONB array
+type JSONStrings []string
+
+func (s JSONStrings) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (s *JSONStrings) Scan(value interface{}) error {
+	data, err := jsonBytes(value)
+	if err != nil || data == nil {
+		*s = nil
+		return err
+	}
+	return json.Unmarshal(data, s)
+}
+
+// JSONEncryption is the encryption metadata of stored content, stored as a
+// JSONB object. Plaintext content has none (NULL).
+type JSONEncryption struct {
+	KeyID       string `json:"key_id"`
+	WrappedKey  []byte `json:"wrapped_key"`
+	SegmentSize int    `json:"segment_size"`
+}
+
+func (e JSONEncryption) Value() (driver.Value, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (e *JSONEncryption) Scan(value interface{}) error {
+	data, err := jsonBytes(value)
+	if err != nil || data == nil {
+		return err
+	}
+	return json.Unmarshal(data, e)
+}
+
+func toJSONEncryption(e *entity.Encryption) *JSONEncryption {
+	if e == nil {
+		return nil
+	}
+	return &JSONEncryption{KeyID: e.KeyID, WrappedKey: e.WrappedKey, SegmentSize: e.SegmentSize}
+}
+
+func toEncryptionEntity(e *JSONEncryption) *entity.Encryption {
+	if e == nil {
+		return nil
+	}
+	return &entity.Encryption{KeyID: e.KeyID, WrappedKey: e.WrappedKey, SegmentSize: e.SegmentSize}
+}
+
+func jsonBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("unsupported JSON column type %T", value)
+	}
+}
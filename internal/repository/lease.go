@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Background work on a row (an import, a finalization, an archive job) is
+// claimed through a lease: the instance doing the work records itself in
+// lease_holder and renews lease_expires_at while it runs. Work whose lease
+// has expired was abandoned by a crashed instance and may be taken over.
+// Lease columns are written without touching the row version.
+
+// claimLease gives the lease of the row with id to holder if it is free or
+// has expired
+func claimLease(db *gorm.DB, model interface{}, id uuid.UUID, holder string, until time.Time) (bool, error) {
+	result := db.Model(model).
+		Where("id = ? AND (lease_expires_at IS NULL OR lease_expires_at <= ?)", id, time.Now()).
+		Updates(map[string]interface{}{"lease_holder": holder, "lease_expires_at": until})
+	return result.RowsAffected == 1, result.Error
+}
+
+// renewLease extends a lease still held by holder
+func renewLease(db *gorm.DB, model interface{}, id uuid.UUID, holder string, until time.Time) (bool, error) {
+	result := db.Model(model).
+		Where("id = ? AND lease_holder = ?", id, holder).
+		Update("lease_expires_at", until)
+	return result.RowsAffected == 1, result.Error
+}
+
+// releaseLease frees a lease held by holder, so that the work can be picked
+// up again without waiting for the lease to expire
+func releaseLease(db *gorm.DB, model interface{}, id uuid.UUID, holder string) error {
+	return db.Model(model).
+		Where("id = ? AND lease_holder = ?", id, holder).
+		Updates(map[string]interface{}{"lease_holder": "", "lease_expires_at": nil}).Error
+}
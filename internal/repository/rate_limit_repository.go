@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"fileupload/pkg/ratelimit"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RateLimitBucketModel struct {
+	Key       string `gorm:"primaryKey"`
+	Tokens    float64
+	UpdatedAt time.Time `gorm:"index;autoUpdateTime:false"`
+}
+
+// RateLimitRepository stores the token buckets of rate-limited clients
+type RateLimitRepository interface {
+	// Take takes a token from the bucket of key, creating a full bucket for
+	// a new key
+	Take(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error)
+	// DeleteIdle forgets buckets untouched since before, which are full
+	DeleteIdle(ctx context.Context, before time.Time) (int64, error)
+}
+
+type rateLimitRepository struct {
+	db *gorm.DB
+}
+
+// NewRateLimitRepository stores buckets in Postgres, so that the limits are
+// shared by every instance
+func NewRateLimitRepository(db *gorm.DB) RateLimitRepository {
+	return &rateLimitRepository{
+		db: db,
+	}
+}
+
+func (r *rateLimitRepository) Take(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	var result ratelimit.Result
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		full := limit.Full(now)
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&RateLimitBucketModel{Key: key, Tokens: full.Tokens, UpdatedAt: full.UpdatedAt}).Error
+		if err != nil {
+			return err
+		}
+
+		var model RateLimitBucketModel
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&model).Error
+		if err != nil {
+			return err
+		}
+
+		var bucket ratelimit.Bucket
+		bucket, result = limit.Take(ratelimit.Bucket{Tokens: model.Tokens, UpdatedAt: model.UpdatedAt}, now)
+		return tx.Model(&RateLimitBucketModel{}).Where("key = ?", key).
+			Updates(map[string]interface{}{"tokens": bucket.Tokens, "updated_at": bucket.UpdatedAt}).Error
+	})
+	return result, err
+}
+
+func (r *rateLimitRepository) DeleteIdle(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("updated_at < ?", before).Delete(&RateLimitBucketModel{})
+	return result.RowsAffected, result.Error
+}
+
+type memoryRateLimitRepository struct {
+	mu         sync.Mutex
+	maxBuckets int
+	buckets    map[string]*list.Element
+	// recent orders the buckets from the most to the least recently used
+	recent *list.List
+}
+
+type memoryBucket struct {
+	key    string
+	bucket ratelimit.Bucket
+}
+
+// NewMemoryRateLimitRepository keeps buckets in memory; each instance then
+// enforces the limits on its own. At most maxBuckets are held: a new client
+// beyond that evicts the least recently seen one, whose next request then
+// starts from a full bucket.
+func NewMemoryRateLimitRepository(maxBuckets int) RateLimitRepository {
+	return &memoryRateLimitRepository{
+		maxBuckets: maxBuckets,
+		buckets:    make(map[string]*list.Element),
+		recent:     list.New(),
+	}
+}
+
+func (r *memoryRateLimitRepository) Take(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	element, ok := r.buckets[key]
+	if ok {
+		r.recent.MoveToFront(element)
+	} else {
+		for r.recent.Len() >= r.maxBuckets {
+			oldest := r.recent.Back()
+			r.recent.Remove(oldest)
+			delete(r.buckets, oldest.Value.(*memoryBucket).key)
+		}
+		element = r.recent.PushFront(&memoryBucket{key: key, bucket: limit.Full(now)})
+		r.buckets[key] = element
+	}
+
+	entry := element.Value.(*memoryBucket)
+	var result ratelimit.Result
+	entry.bucket, result = limit.Take(entry.bucket, now)
+	return result, nil
+}
+
+func (r *memoryRateLimitRepository) DeleteIdle(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Buckets are ordered by last use, so the idle ones are at the back
+	var deleted int64
+	for element := r.recent.Back(); element != nil; element = r.recent.Back() {
+		entry := element.Value.(*memoryBucket)
+		if !entry.bucket.UpdatedAt.Before(before) {
+			break
+		}
+		r.recent.Remove(element)
+		delete(r.buckets, entry.key)
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"fileupload/pkg/ratelimit"
+	"testing"
+	"time"
+)
+
+// takeAll takes a token for every key and reports which were allowed
+func takeAll(t *testing.T, repo RateLimitRepository, limit ratelimit.Limit, now time.Time, keys ...string) []bool {
+	t.Helper()
+	allowed := make([]bool, len(keys))
+	for i, key := range keys {
+		result, err := repo.Take(context.Background(), key, limit, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed[i] = result.Allowed
+	}
+	return allowed
+}
+
+func TestMemoryRateLimitEvictsLeastRecentlyUsed(t *testing.T) {
+	// Buckets hold a single token and do not refill during the test, so a
+	// bucket that was kept refuses the next request and an evicted one
+	// allows it
+	limit := ratelimit.Limit{Rate: 0.001, Burst: 1}
+	now := time.Unix(1000, 0)
+	repo := NewMemoryRateLimitRepository(2)
+
+	takeAll(t, repo, limit, now, "a", "b")
+	// a is used again, which leaves b the least recently used
+	if allowed := takeAll(t, repo, limit, now, "a"); allowed[0] {
+		t.Fatal("a was allowed twice")
+	}
+	// c evicts b
+	takeAll(t, repo, limit, now, "c")
+
+	allowed := takeAll(t, repo, limit, now, "a", "c", "b")
+	if allowed[0] || allowed[1] {
+		t.Fatalf("a and c allowed = %v, want both kept and refused", allowed[:2])
+	}
+	if !allowed[2] {
+		t.Fatal("b was refused, want it evicted and given a full bucket")
+	}
+}
+
+func TestMemoryRateLimitDeleteIdle(t *testing.T) {
+	limit := ratelimit.Limit{Rate: 0.001, Burst: 1}
+	start := time.Unix(1000, 0)
+	repo := NewMemoryRateLimitRepository(10)
+
+	takeAll(t, repo, limit, start, "a")
+	takeAll(t, repo, limit, start.Add(time.Second), "b")
+	takeAll(t, repo, limit, start.Add(2*time.Second), "c")
+
+	deleted, err := repo.DeleteIdle(context.Background(), start.Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("deleted %d buckets, want 2", deleted)
+	}
+
+	allowed := takeAll(t, repo, limit, start.Add(2*time.Second), "a", "b", "c")
+	if !allowed[0] || !allowed[1] || allowed[2] {
+		t.Fatalf("allowed = %v, want a and b forgotten and c kept", allowed)
+	}
+}
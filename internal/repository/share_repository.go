@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"context"
+	"fileupload/internal/domain/entity"
+	"fileupload/pkg/logger"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ShareLinkModel struct {
+	ID            uuid.UUID `gorm:"type:uuid;primary_key"`
+	FileID        uuid.UUID `gorm:"type:uuid;not null;index"`
+	Owner         string    `gorm:"not null;index"`
+	TokenHash     string    `gorm:"not null;uniqueIndex"`
+	PasswordHash  string
+	ExpiresAt     *time.Time
+	MaxDownloads  int `gorm:"not null;default:0"`
+	DownloadCount int `gorm:"not null;default:0"`
+	RevokedAt     *time.Time
+	CreatedAt     time.Time
+}
+
+type ShareAccessModel struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	ShareLinkID uuid.UUID `gorm:"type:uuid;not null;index:idx_share_access_link_time"`
+	Outcome     string
+	RemoteAddr  string
+	UserAgent   string
+	RequestID   string
+	CreatedAt   time.Time `gorm:"index:idx_share_access_link_time"`
+}
+
+type ShareRepository interface {
+	CreateShareLink(ctx context.Context, link *entity.ShareLink) error
+	GetShareLinkByID(ctx context.Context, id uuid.UUID) (*entity.ShareLink, error)
+	GetShareLinkByTokenHash(ctx context.Context, tokenHash string) (*entity.ShareLink, error)
+	ListShareLinksByFile(ctx context.Context, fileID uuid.UUID) ([]*entity.ShareLink, error)
+	RevokeShareLink(ctx context.Context, id uuid.UUID, at time.Time) error
+	// ConsumeDownload counts one download against the link. It reports false,
+	// without counting, when the link is revoked, expired or used up.
+	ConsumeDownload(ctx context.Context, id uuid.UUID, now time.Time) (bool, error)
+	CreateShareAccess(ctx context.Context, access *entity.ShareAccess) error
+	// ListShareAccesses returns the access log of a link, newest first
+	ListShareAccesses(ctx context.Context, linkID uuid.UUID, limit, offset int) ([]*entity.ShareAccess, int64, error)
+}
+
+type shareRepository struct {
+	db *gorm.DB
+}
+
+func NewShareRepository(db *gorm.DB) ShareRepository {
+	return &shareRepository{
+		db: db,
+	}
+}
+
+func (r *shareRepository) CreateShareLink(ctx context.Context, link *entity.ShareLink) error {
+	err := r.db.WithContext(ctx).Create(toShareLinkModel(link)).Error
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("file_id", link.FileID).Error("failed to insert share link")
+	}
+	return err
+}
+
+func (r *shareRepository) GetShareLinkByID(ctx context.Context, id uuid.UUID) (*entity.ShareLink, error) {
+	var model ShareLinkModel
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&model).Error
+	if err != nil {
+		return nil, err
+	}
+	return toShareLinkEntity(&model), nil
+}
+
+func (r *shareRepository) GetShareLinkByTokenHash(ctx context.Context, tokenHash string) (*entity.ShareLink, error) {
+	var model ShareLinkModel
+	err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&model).Error
+	if err != nil {
+		return nil, err
+	}
+	return toShareLinkEntity(&model), nil
+}
+
+func (r *shareRepository) ListShareLinksByFile(ctx context.Context, fileID uuid.UUID) ([]*entity.ShareLink, error) {
+	var models []ShareLinkModel
+	err := r.db.WithContext(ctx).Where("file_id = ?", fileID).Order("created_at DESC").Find(&models).Error
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]*entity.ShareLink, 0, len(models))
+	for i := range models {
+		links = append(links, toShareLinkEntity(&models[i]))
+	}
+	return links, nil
+}
+
+func (r *shareRepository) RevokeShareLink(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&ShareLinkModel{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at).Error
+}
+
+func (r *shareRepository) ConsumeDownload(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	// The limits are checked in the update itself so that concurrent
+	// downloads cannot exceed them
+	result := r.db.WithContext(ctx).Model(&ShareLinkModel{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Where("max_downloads = 0 OR download_count < max_downloads").
+		Update("download_count", gorm.Expr("download_count + 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *shareRepository) CreateShareAccess(ctx context.Context, access *entity.ShareAccess) error {
+	return r.db.WithContext(ctx).Create(&ShareAccessModel{
+		ID:          access.ID,
+		ShareLinkID: access.ShareLinkID,
+		Outcome:     access.Outcome,
+		RemoteAddr:  access.RemoteAddr,
+		UserAgent:   access.UserAgent,
+		RequestID:   access.RequestID,
+		CreatedAt:   access.CreatedAt,
+	}).Error
+}
+
+func (r *shareRepository) ListShareAccesses(ctx context.Context, linkID uuid.UUID, limit, offset int) ([]*entity.ShareAccess, int64, error) {
+	query := r.db.WithContext(ctx).Model(&ShareAccessModel{}).Where("share_link_id = ?", linkID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var models []ShareAccessModel
+	err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&models).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	accesses := make([]*entity.ShareAccess, 0, len(models))
+	for _, model := range models {
+		accesses = append(accesses, &entity.ShareAccess{
+			ID:          model.ID,
+			ShareLinkID: model.ShareLinkID,
+			Outcome:     model.Outcome,
+			RemoteAddr:  model.RemoteAddr,
+			UserAgent:   model.UserAgent,
+			RequestID:   model.RequestID,
+			CreatedAt:   model.CreatedAt,
+		})
+	}
+	return accesses, total, nil
+}
+
+func toShareLinkModel(link *entity.ShareLink) *ShareLinkModel {
+	return &ShareLinkModel{
+		ID:            link.ID,
+		FileID:        link.FileID,
+		Owner:         link.Owner,
+		TokenHash:     link.TokenHash,
+		PasswordHash:  link.PasswordHash,
+		ExpiresAt:     link.ExpiresAt,
+		MaxDownloads:  link.MaxDownloads,
+		DownloadCount: link.DownloadCount,
+		RevokedAt:     link.RevokedAt,
+		CreatedAt:     link.CreatedAt,
+	}
+}
+
+func toShareLinkEntity(model *ShareLinkModel) *entity.ShareLink {
+	return &entity.ShareLink{
+		ID:            model.ID,
+		FileID:        model.FileID,
+		Owner:         model.Owner,
+		TokenHash:     model.TokenHash,
+		PasswordHash:  model.PasswordHash,
+		ExpiresAt:     model.ExpiresAt,
+		MaxDownloads:  model.MaxDownloads,
+		DownloadCount: model.DownloadCount,
+		RevokedAt:     model.RevokedAt,
+		CreatedAt:     model.CreatedAt,
+	}
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"fileupload/internal/domain/entity"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// StorageKinds lists the kinds of records whose content can be moved between
+// storage backends. Uploads and archives only ever live on local disk.
+var StorageKinds = []string{ContentKindFile, ContentKindFileVersion}
+
+// StoredContent is the content of one file or file version record and where
+// it is stored. Metadata and Tags are only set for files.
+type StoredContent struct {
+	Kind           string
+	ID             uuid.UUID
+	Backend        string
+	Path           string
+	ObjectKey      string
+	OriginalName   string
+	MimeType       string
+	Size           int64
+	Encryption     *entity.Encryption
+	Compression    string
+	CompressedSize int64
+	Checksum       string
+	UploadID       uuid.UUID
+	Metadata       map[string]string
+	Tags           []string
+}
+
+// StorageRepository finds and updates the storage backend of the records of
+// every kind of movable content
+type StorageRepository interface {
+	// ListStoredContent returns, in ID order, up to limit records of kind
+	// with an ID greater than after whose content is not on backend, or
+	// regardless of their backend when it is empty
+	ListStoredContent(ctx context.Context, kind, backend string, after uuid.UUID, limit int) ([]*StoredContent, error)
+	// StoredContentExists reports whether a record still exists
+	StoredContentExists(ctx context.Context, kind string, id uuid.UUID) (bool, error)
+	// ListFinalizingFileNames returns the stored names of the uploads being
+	// finalized, whose content is at its final path without a file record
+	ListFinalizingFileNames(ctx context.Context) ([]string, error)
+	// UpdateBackend records that the content of a record is on backend,
+	// provided it is still recorded on the backend it was listed with, and
+	// reports whether it was. Timestamps are left untouched.
+	UpdateBackend(ctx context.Context, content *StoredContent, backend string) (bool, error)
+}
+
+type storageRepository struct {
+	db *gorm.DB
+}
+
+func NewStorageRepository(db *gorm.DB) StorageRepository {
+	return &storageRepository{
+		db: db,
+	}
+}
+
+func (r *storageRepository) ListStoredContent(ctx context.Context, kind, backend string, after uuid.UUID, limit int) ([]*StoredContent, error) {
+	query := r.db.WithContext(ctx).Where("id > ?", after).Order("id").Limit(limit)
+	if backend != "" {
+		query = query.Where("backend <> ?", backend)
+	}
+
+	var contents []*StoredContent
+	switch kind {
+	case ContentKindFile:
+		var models []FileModel
+		if err := query.Find(&models).Error; err != nil {
+			return nil, err
+		}
+		for i := range models {
+			file := toFileEntity(&models[i])
+			contents = append(contents, &StoredContent{
+				Kind:           kind,
+				ID:             file.ID,
+				Backend:        file.Backend,
+				Path:           file.Path,
+				ObjectKey:      file.FileName,
+				OriginalName:   file.OriginalName,
+				MimeType:       file.MimeType,
+				Size:           file.Size,
+				Encryption:     file.Encryption,
+				Compression:    file.Compression,
+				CompressedSize: file.CompressedSize,
+				Checksum:       file.Checksum,
+				UploadID:       file.UploadID,
+				Metadata:       file.Metadata,
+				Tags:           file.Tags,
+			})
+		}
+	case ContentKindFileVersion:
+		var models []FileVersionModel
+		if err := query.Find(&models).Error; err != nil {
+			return nil, err
+		}
+		for i := range models {
+			version := toFileVersionEntity(&models[i])
+			contents = append(contents, &StoredContent{
+				Kind:           kind,
+				ID:             version.ID,
+				Backend:        version.Backend,
+				Path:           version.Path,
+				ObjectKey:      version.FileName,
+				OriginalName:   version.OriginalName,
+				MimeType:       version.MimeType,
+				Size:           version.Size,
+				Encryption:     version.Encryption,
+				Compression:    version.Compression,
+				CompressedSize: version.CompressedSize,
+				Checksum:       version.Checksum,
+				UploadID:       version.UploadID,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("content kind %q has no storage backend", kind)
+	}
+	return contents, nil
+}
+
+func (r *storageRepository) UpdateBackend(ctx context.Context, content *StoredContent, backend string) (bool, error) {
+	model, err := contentModel(content.Kind)
+	if err != nil {
+		return false, err
+	}
+
+	result := r.db.WithContext(ctx).Model(model).
+		Where("id = ? AND backend = ?", content.ID, content.Backend).
+		UpdateColumn("backend", backend)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *storageRepository) StoredContentExists(ctx context.Context, kind string, id uuid.UUID) (bool, error) {
+	model, err := contentModel(kind)
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	err = r.db.WithContext(ctx).Model(model).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *storageRepository) ListFinalizingFileNames(ctx context.Context) ([]string, error) {
+	var names []string
+	err := r.db.WithContext(ctx).Model(&UploadModel{}).Where("status = ?", "finalizing").Pluck("file_name", &names).Error
+	return names, err
+}
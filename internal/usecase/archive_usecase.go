@@ -0,0 +1,596 @@
+package usecase
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	minioClient "fileupload/pkg/minio"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type ArchiveUseCase interface {
+	// PrepareArchive resolves the files of an archive request and the names
+	// they get inside the archive
+	PrepareArchive(ctx context.Context, owner string, req ArchiveRequest) (*Archive, error)
+	// WriteArchive streams a prepared archive to w as a ZIP file. Contents are
+	// read one at a time; nothing is buffered on disk.
+	WriteArchive(ctx context.Context, w io.Writer, archive *Archive) error
+
+	// StartArchiveJob builds the archive in the background
+	StartArchiveJob(ctx context.Context, owner string, req ArchiveRequest) (*entity.ArchiveJob, error)
+	GetArchiveJob(ctx context.Context, owner string, id uuid.UUID) (*entity.ArchiveJob, error)
+	// OpenArchive opens the result of a completed archive job
+	OpenArchive(ctx context.Context, owner string, id uuid.UUID) (*FileContent, error)
+	// RecoverArchiveJobs fails jobs abandoned by a crashed instance, once
+	// their lease has expired; it is meant to run periodically
+	RecoverArchiveJobs(ctx context.Context) error
+	// PurgeExpired deletes archive jobs and their archives once they expire
+	PurgeExpired(ctx context.Context)
+
+	// BeginShutdown stops accepting new archive jobs
+	BeginShutdown()
+	// Drain waits for running archive jobs. Once ctx expires the remaining
+	// jobs are aborted and marked as failed.
+	Drain(ctx context.Context) error
+}
+
+var (
+	// ErrInvalidArchiveRequest wraps every archive request validation error
+	ErrInvalidArchiveRequest = errors.New("invalid archive request")
+	// ErrArchiveNotFound is returned for archive jobs that do not exist,
+	// expired or belong to another owner
+	ErrArchiveNotFound = errors.New("archive not found")
+	// ErrArchiveNotReady is returned when downloading an archive whose job
+	// has not completed
+	ErrArchiveNotReady = errors.New("archive is not ready")
+)
+
+// ArchiveRequest selects the files of an archive: either a list of files or
+// every file below a folder
+type ArchiveRequest struct {
+	FileIDs  []uuid.UUID
+	FolderID *uuid.UUID
+}
+
+// Archive is a resolved archive request
+type Archive struct {
+	Name    string
+	Folders []string // empty folders are kept as directory entries
+	Entries []ArchiveEntry
+}
+
+// ArchiveEntry is one file of an archive and its unique name inside it
+type ArchiveEntry struct {
+	Name string
+	File *entity.File
+}
+
+type archiveUseCase struct {
+	archiveRepo repository.ArchiveRepository
+	fileRepo    repository.FileRepository
+	folderRepo  repository.FolderRepository
+	config      *config.Config
+	jobs        *inflightTracker
+}
+
+func NewArchiveUseCase(archiveRepo repository.ArchiveRepository, fileRepo repository.FileRepository, folderRepo repository.FolderRepository, config *config.Config) ArchiveUseCase {
+	return &archiveUseCase{
+		archiveRepo: archiveRepo,
+		fileRepo:    fileRepo,
+		folderRepo:  folderRepo,
+		config:      config,
+		jobs:        newInflightTracker(),
+	}
+}
+
+func (u *archiveUseCase) PrepareArchive(ctx context.Context, owner string, req ArchiveRequest) (*Archive, error) {
+	switch {
+	case len(req.FileIDs) > 0 && req.FolderID != nil:
+		return nil, fmt.Errorf("%w: file_ids and folder_id are mutually exclusive", ErrInvalidArchiveRequest)
+	case req.FolderID != nil:
+		return u.prepareFolderArchive(ctx, owner, *req.FolderID)
+	case len(req.FileIDs) > 0:
+		return u.prepareFileArchive(ctx, owner, req.FileIDs)
+	default:
+		return nil, fmt.Errorf("%w: file_ids or folder_id is required", ErrInvalidArchiveRequest)
+	}
+}
+
+func (u *archiveUseCase) prepareFileArchive(ctx context.Context, owner string, fileIDs []uuid.UUID) (*Archive, error) {
+	if maxFiles := u.config.Settings().ArchiveMaxFiles; len(fileIDs) > maxFiles {
+		return nil, fmt.Errorf("%w: at most %d files can be archived", ErrInvalidArchiveRequest, maxFiles)
+	}
+
+	archive := &Archive{Name: "files-" + time.Now().Format("20060102-150405") + ".zip"}
+	seen := make(map[uuid.UUID]bool, len(fileIDs))
+	used := make(map[string]bool, len(fileIDs))
+	for _, id := range fileIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		file, err := u.fileRepo.GetFileByID(ctx, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && file.Owner != owner) {
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		archive.Entries = append(archive.Entries, ArchiveEntry{
+			Name: uniqueArchiveName(used, "", file.OriginalName),
+			File: file,
+		})
+	}
+	return archive, nil
+}
+
+func (u *archiveUseCase) prepareFolderArchive(ctx context.Context, owner string, folderID uuid.UUID) (*Archive, error) {
+	root, err := getOwnedFolder(ctx, u.folderRepo, owner, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	folders, err := u.folderRepo.FolderTree(ctx, root.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load folder tree: %w", err)
+	}
+
+	byID := make(map[uuid.UUID]*entity.Folder, len(folders))
+	ids := make([]uuid.UUID, 0, len(folders))
+	for _, folder := range folders {
+		byID[folder.ID] = folder
+		ids = append(ids, folder.ID)
+	}
+
+	files, err := u.folderRepo.ListFilesInFolders(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folder files: %w", err)
+	}
+	if maxFiles := u.config.Settings().ArchiveMaxFiles; len(files) > maxFiles {
+		return nil, fmt.Errorf("%w: folder holds more than %d files", ErrInvalidArchiveRequest, maxFiles)
+	}
+
+	// Folder paths are relative to the archived folder. Paths are resolved
+	// parents first so that each folder's name is deduplicated within the
+	// (already unique) path of its parent.
+	used := make(map[string]bool)
+	paths := map[uuid.UUID]string{root.ID: ""}
+	var resolve func(folder *entity.Folder) string
+	resolve = func(folder *entity.Folder) string {
+		if p, ok := paths[folder.ID]; ok {
+			return p
+		}
+		parent := ""
+		if folder.ParentID != nil {
+			if parentFolder, ok := byID[*folder.ParentID]; ok {
+				parent = resolve(parentFolder)
+			}
+		}
+		p := uniqueArchiveName(used, parent, folder.Name)
+		paths[folder.ID] = p
+		return p
+	}
+
+	archive := &Archive{Name: sanitizeArchiveName(root.Name) + ".zip"}
+	hasFiles := make(map[string]bool)
+	for _, file := range files {
+		dir := ""
+		if file.FolderID != nil {
+			if folder, ok := byID[*file.FolderID]; ok {
+				dir = resolve(folder)
+			}
+		}
+		hasFiles[dir] = true
+		archive.Entries = append(archive.Entries, ArchiveEntry{
+			Name: uniqueArchiveName(used, dir, file.OriginalName),
+			File: file,
+		})
+	}
+	for _, folder := range folders {
+		if p := resolve(folder); p != "" && !hasFiles[p] {
+			archive.Folders = append(archive.Folders, p+"/")
+		}
+	}
+	return archive, nil
+}
+
+func (u *archiveUseCase) WriteArchive(ctx context.Context, w io.Writer, archive *Archive) error {
+	zw := zip.NewWriter(w)
+
+	for _, dir := range archive.Folders {
+		if _, err := zw.CreateHeader(&zip.FileHeader{Name: dir, Method: zip.Store, Modified: time.Now()}); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range archive.Entries {
+		if err := u.writeArchiveEntry(ctx, zw, entry); err != nil {
+			// The central directory is deliberately not written, so a client
+			// that already received part of the archive sees it as corrupt
+			// rather than silently missing files
+			return fmt.Errorf("failed to archive %q: %w", entry.Name, err)
+		}
+	}
+
+	return zw.Close()
+}
+
+func (u *archiveUseCase) writeArchiveEntry(ctx context.Context, zw *zip.Writer, entry ArchiveEntry) error {
+	content, err := openStoredContent(ctx, u.config, entry.File.Path, entry.File.FileName, fileFormat(entry.File))
+	if err != nil {
+		return err
+	}
+	defer content.Reader.Close()
+
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Method:   archiveMethod(entry.File.MimeType),
+		Modified: entry.File.UpdatedAt,
+	}
+	header.SetMode(0644)
+
+	dst, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, &contextReader{ctx: ctx, r: content.Reader})
+	return err
+}
+
+func (u *archiveUseCase) StartArchiveJob(ctx context.Context, owner string, req ArchiveRequest) (*entity.ArchiveJob, error) {
+	archive, err := u.PrepareArchive(ctx, owner, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The job outlives the request; it is tracked separately so shutdown can
+	// wait for it
+	jobCtx, done, err := u.jobs.begin(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	// The job is leased from the start, so that recovery on another instance
+	// never mistakes it for an abandoned one
+	now := time.Now()
+	leaseExpiresAt := now.Add(jobLease)
+	job := &entity.ArchiveJob{
+		ID:             uuid.New(),
+		Owner:          owner,
+		Name:           archive.Name,
+		Status:         "pending",
+		FileIDs:        req.FileIDs,
+		FolderID:       req.FolderID,
+		FileCount:      len(archive.Entries),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ExpiresAt:      now.Add(u.config.ArchiveTTL),
+		LeaseHolder:    instanceID,
+		LeaseExpiresAt: &leaseExpiresAt,
+	}
+	if err := u.archiveRepo.CreateArchiveJob(ctx, job); err != nil {
+		done()
+		return nil, fmt.Errorf("failed to create archive job: %w", err)
+	}
+
+	go func() {
+		defer done()
+		u.runArchiveJob(jobCtx, job, archive)
+	}()
+
+	return job, nil
+}
+
+func (u *archiveUseCase) runArchiveJob(ctx context.Context, job *entity.ArchiveJob, archive *Archive) {
+	entry := logger.FromContext(ctx).WithFields(logrus.Fields{
+		"archive_id": job.ID,
+		"files":      len(archive.Entries),
+	})
+	ctx, stopRenewing := holdLease(ctx, func(ctx context.Context, until time.Time) (bool, error) {
+		return u.archiveRepo.RenewArchiveJobLease(ctx, job.ID, instanceID, until)
+	})
+	defer stopRenewing()
+
+	job.Status = "running"
+	job.UpdatedAt = time.Now()
+	if err := u.archiveRepo.UpdateArchiveJob(ctx, job); err != nil {
+		entry.WithError(err).Warn("failed to mark archive job running")
+	}
+
+	size, err := u.buildArchiveFile(ctx, job, archive)
+	// The outcome is recorded even if the job was aborted by shutdown
+	recordCtx := context.WithoutCancel(ctx)
+	now := time.Now()
+	job.UpdatedAt = now
+	// A failed job stays visible for as long as an archive would, and is
+	// then purged like one
+	job.ExpiresAt = now.Add(u.config.ArchiveTTL)
+	if err != nil {
+		job.Status = "failed"
+		job.Error = err.Error()
+		entry.WithError(err).Error("archive job failed")
+	} else {
+		job.Status = "completed"
+		job.Size = size
+		job.CompletedAt = &now
+		entry.WithField("size", size).Info("archive job completed")
+	}
+	if err := u.archiveRepo.UpdateArchiveJob(recordCtx, job); err != nil {
+		entry.WithError(err).Error("failed to record archive job result")
+	}
+}
+
+// buildArchiveFile writes the archive next to its final path, encrypted like
+// stored files, and renames it into place once complete. With MinIO enabled
+// the archive is replicated there too, so that any instance can serve it.
+func (u *archiveUseCase) buildArchiveFile(ctx context.Context, job *entity.ArchiveJob, archive *Archive) (int64, error) {
+	dir := u.archiveDir()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	enc, err := newContentEncryption(u.config)
+	if err != nil {
+		return 0, err
+	}
+
+	finalPath := filepath.Join(dir, job.ID.String()+".zip")
+	partPath := finalPath + ".part"
+	out, err := os.Create(partPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create archive file: %w", err)
+	}
+
+	counter := &countingWriter{}
+	w, err := sealContent(u.config, out, enc)
+	if err == nil {
+		err = u.WriteArchive(ctx, io.MultiWriter(w, counter), archive)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return 0, err
+	}
+
+	if err := os.Rename(partPath, finalPath); err != nil {
+		os.Remove(partPath)
+		return 0, fmt.Errorf("failed to move archive into place: %w", err)
+	}
+	if u.config.EnabledMinio {
+		if err := u.replicateArchive(ctx, job.ID, finalPath, enc); err != nil {
+			os.Remove(finalPath)
+			return 0, fmt.Errorf("failed to upload archive to MinIO: %w", err)
+		}
+	}
+
+	job.Path = finalPath
+	job.Encryption = enc
+	return counter.n, nil
+}
+
+// replicateArchive uploads a built archive to MinIO
+func (u *archiveUseCase) replicateArchive(ctx context.Context, id uuid.UUID, path string, enc *entity.Encryption) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = minioClient.Client.PutObject(ctx, u.config.MinioBucket, archiveObjectKey(id), f, info.Size(),
+		storedObjectOptions("application/zip", contentFormat{Encryption: enc}, nil))
+	return err
+}
+
+func (u *archiveUseCase) GetArchiveJob(ctx context.Context, owner string, id uuid.UUID) (*entity.ArchiveJob, error) {
+	job, err := u.archiveRepo.GetArchiveJob(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrArchiveNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.Owner != owner || !job.ExpiresAt.After(time.Now()) {
+		return nil, ErrArchiveNotFound
+	}
+	return job, nil
+}
+
+func (u *archiveUseCase) OpenArchive(ctx context.Context, owner string, id uuid.UUID) (*FileContent, error) {
+	job, err := u.GetArchiveJob(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != "completed" {
+		return nil, ErrArchiveNotReady
+	}
+
+	// The archive may have been built by another instance, whose disk only
+	// it can read; the MinIO copy is used then
+	content, err := openStoredContent(ctx, u.config, job.Path, archiveObjectKey(job.ID), contentFormat{Encryption: job.Encryption})
+	if errors.Is(err, ErrContentNotFound) {
+		return nil, ErrArchiveNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content.Name = job.Name
+	content.MimeType = "application/zip"
+	content.ModTime = job.UpdatedAt
+	content.ETag = job.ID.String()
+	return content, nil
+}
+
+// RecoverArchiveJobs fails archive jobs abandoned by a crashed instance.
+// Jobs still leased by a live instance, this one included, are left alone.
+func (u *archiveUseCase) RecoverArchiveJobs(ctx context.Context) error {
+	jobs, err := u.archiveRepo.ListArchiveJobsByStatus(ctx, "pending", "running")
+	if err != nil {
+		return fmt.Errorf("failed to list interrupted archive jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		entry := logger.FromContext(ctx).WithField("archive_id", job.ID)
+		claimed, err := u.archiveRepo.ClaimArchiveJobLease(ctx, job.ID, instanceID, time.Now().Add(jobLease))
+		if err != nil {
+			entry.WithError(err).Error("failed to claim interrupted archive job")
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		os.Remove(filepath.Join(u.archiveDir(), job.ID.String()+".zip.part"))
+		job.Status = "failed"
+		job.Error = "interrupted by a restart"
+		job.UpdatedAt = time.Now()
+		job.ExpiresAt = job.UpdatedAt.Add(u.config.ArchiveTTL)
+		if err := u.archiveRepo.UpdateArchiveJob(ctx, job); err != nil {
+			entry.WithError(err).Error("failed to fail interrupted archive job")
+		}
+	}
+	return nil
+}
+
+func (u *archiveUseCase) PurgeExpired(ctx context.Context) {
+	jobs, err := u.archiveRepo.ListExpiredArchiveJobs(ctx, time.Now())
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("failed to list expired archives")
+		return
+	}
+	if len(jobs) == 0 {
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(jobs))
+	for _, job := range jobs {
+		if job.Path != "" {
+			if err := os.Remove(job.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.FromContext(ctx).WithError(err).WithField("archive_id", job.ID).Warn("failed to remove expired archive")
+				continue
+			}
+			if u.config.EnabledMinio {
+				err := minioClient.Client.RemoveObject(ctx, u.config.MinioBucket, archiveObjectKey(job.ID), minio.RemoveObjectOptions{})
+				if err != nil {
+					logger.FromContext(ctx).WithError(err).WithField("archive_id", job.ID).Warn("failed to remove expired archive from MinIO")
+					continue
+				}
+			}
+		}
+		ids = append(ids, job.ID)
+	}
+
+	if err := u.archiveRepo.DeleteArchiveJobs(ctx, ids); err != nil {
+		logger.FromContext(ctx).WithError(err).Error("failed to delete expired archive jobs")
+		return
+	}
+	logger.FromContext(ctx).WithField("deleted", len(ids)).Info("purged expired archives")
+}
+
+func (u *archiveUseCase) BeginShutdown() {
+	u.jobs.stopAccepting()
+}
+
+func (u *archiveUseCase) Drain(ctx context.Context) error {
+	err := u.jobs.wait(ctx)
+	if err == nil {
+		return nil
+	}
+
+	logger.FromContext(ctx).Warn("shutdown deadline reached, aborting archive jobs")
+	u.jobs.abort()
+
+	graceCtx, cancel := context.WithTimeout(context.Background(), rollbackGracePeriod)
+	defer cancel()
+	if waitErr := u.jobs.wait(graceCtx); waitErr != nil {
+		return fmt.Errorf("archive jobs did not stop in time: %w", waitErr)
+	}
+	return err
+}
+
+func (u *archiveUseCase) archiveDir() string {
+	return filepath.Join(u.config.UploadTempDir, "archives")
+}
+
+// archiveObjectPrefix starts the MinIO keys of archives, so that they never
+// collide with stored files
+const archiveObjectPrefix = "archives/"
+
+func archiveObjectKey(id uuid.UUID) string {
+	return archiveObjectPrefix + id.String() + ".zip"
+}
+
+// uniqueArchiveName returns dir/name, suffixed with " (n)" before the
+// extension if that path is taken. Paths are compared case-insensitively so
+// the archive extracts cleanly on case-insensitive filesystems.
+func uniqueArchiveName(used map[string]bool, dir, name string) string {
+	name = sanitizeArchiveName(name)
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := path.Join(dir, name)
+	for n := 1; used[strings.ToLower(candidate)]; n++ {
+		candidate = path.Join(dir, base+" ("+strconv.Itoa(n)+")"+ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// sanitizeArchiveName turns a stored name into a single safe path element
+func sanitizeArchiveName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '_'
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// archiveMethod stores already compressed content as is and deflates the rest
+func archiveMethod(mimeType string) uint16 {
+	switch {
+	case strings.HasPrefix(mimeType, "image/") && mimeType != "image/svg+xml" && mimeType != "image/bmp",
+		strings.HasPrefix(mimeType, "video/"),
+		strings.HasPrefix(mimeType, "audio/"),
+		mimeType == "application/zip", mimeType == "application/gzip",
+		mimeType == "application/x-7z-compressed", mimeType == "application/zstd":
+		return zip.Store
+	}
+	return zip.Deflate
+}
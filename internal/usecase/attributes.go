@@ -0,0 +1,157 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxMetadataEntries     = 64
+	maxMetadataKeyLength   = 128
+	maxMetadataValueLength = 1024
+	maxTags                = 50
+	maxTagLength           = 64
+)
+
+// ErrInvalidAttributes is wrapped by every metadata or tag validation error
+var ErrInvalidAttributes = errors.New("invalid metadata or tags")
+
+// ErrInvalidUploadOptions is returned for upload options that cannot be
+// combined
+var ErrInvalidUploadOptions = errors.New("invalid upload options")
+
+// UploadOptions carries the optional attributes a client can attach when it
+// starts an upload. They are carried over to the file on finalize.
+type UploadOptions struct {
+	Metadata map[string]string
+	Tags     []string
+
+	// The file is placed in FolderID, or in FolderPath (created as needed,
+	// relative to FolderID when both are set), or at the top level
+	FolderID   *uuid.UUID
+	FolderPath string
+
+	// TargetFileID, when set, stores the upload as a new version of that file
+	// instead of creating a new one; the folder options are then ignored
+	TargetFileID *uuid.UUID
+
+	// Extract expands an uploaded ZIP or tar(.gz) archive into individual
+	// files, recreating its directories as folders
+	Extract bool
+}
+
+// FileAttributesPatch edits the metadata and tags of a file. Metadata entries
+// set to nil are removed, others are added or replaced. Tags, when non-nil,
+// replace the current tags.
+type FileAttributesPatch struct {
+	Metadata map[string]*string
+	Tags     []string
+}
+
+// normalizeMetadata validates metadata keys and values. Keys are limited to
+// characters that are safe as MinIO user metadata header names.
+func normalizeMetadata(metadata map[string]string) (map[string]string, error) {
+	if len(metadata) > maxMetadataEntries {
+		return nil, fmt.Errorf("%w: at most %d metadata entries are allowed", ErrInvalidAttributes, maxMetadataEntries)
+	}
+
+	normalized := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		key = strings.TrimSpace(key)
+		if key == "" || len(key) > maxMetadataKeyLength {
+			return nil, fmt.Errorf("%w: metadata keys must be 1 to %d characters", ErrInvalidAttributes, maxMetadataKeyLength)
+		}
+		for _, r := range key {
+			if !isMetadataKeyRune(r) {
+				return nil, fmt.Errorf("%w: metadata key %q may only contain letters, digits, '-', '_' and '.'", ErrInvalidAttributes, key)
+			}
+		}
+		if len(value) > maxMetadataValueLength {
+			return nil, fmt.Errorf("%w: metadata value of %q exceeds %d characters", ErrInvalidAttributes, key, maxMetadataValueLength)
+		}
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("%w: metadata value of %q contains control characters", ErrInvalidAttributes, key)
+		}
+		normalized[key] = value
+	}
+	return normalized, nil
+}
+
+// normalizeTags trims, de-duplicates and sorts tags
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("%w: tag %q exceeds %d characters", ErrInvalidAttributes, tag, maxTagLength)
+		}
+		if strings.IndexFunc(tag, unicode.IsControl) >= 0 || strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("%w: tag %q contains invalid characters", ErrInvalidAttributes, tag)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("%w: at most %d tags are allowed", ErrInvalidAttributes, maxTags)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func (o UploadOptions) normalize() (UploadOptions, error) {
+	metadata, err := normalizeMetadata(o.Metadata)
+	if err != nil {
+		return o, err
+	}
+	tags, err := normalizeTags(o.Tags)
+	if err != nil {
+		return o, err
+	}
+	o.Metadata = metadata
+	o.Tags = tags
+
+	if o.Extract && o.TargetFileID != nil {
+		return o, fmt.Errorf("%w: an extracted archive cannot be stored as a file version", ErrInvalidUploadOptions)
+	}
+	return o, nil
+}
+
+// minioUserMetadata builds the MinIO user metadata for an object. Values are
+// sent as HTTP headers, so anything outside printable ASCII is URL-escaped.
+func minioUserMetadata(metadata map[string]string, tags []string, extra map[string]string) map[string]string {
+	userMetadata := make(map[string]string, len(metadata)+len(extra)+1)
+	for key, value := range metadata {
+		userMetadata[key] = headerSafe(value)
+	}
+	if len(tags) > 0 {
+		userMetadata["tags"] = headerSafe(strings.Join(tags, ","))
+	}
+	for key, value := range extra {
+		userMetadata[key] = headerSafe(value)
+	}
+	return userMetadata
+}
+
+func headerSafe(value string) string {
+	for _, r := range value {
+		if r < 0x20 || r > 0x7e {
+			return url.QueryEscape(value)
+		}
+	}
+	return value
+}
+
+func isMetadataKeyRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') ||
+		r == '-' || r == '_' || r == '.'
+}
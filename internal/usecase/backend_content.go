@@ -0,0 +1,174 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/encryption"
+	"fileupload/pkg/utils"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	minioClient "fileupload/pkg/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// errChecksumMismatch is returned when stored content does not read back as
+// the content it was recorded with
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// The functions below work on the copy of a record's content held by one
+// storage backend, regardless of which backend the record points at. A
+// missing copy is reported as ErrContentNotFound.
+
+// openBackendContent opens the copy of content on a backend as stored
+func openBackendContent(ctx context.Context, cfg *config.Config, backend string, content *repository.StoredContent) (ContentReader, int64, time.Time, error) {
+	if backend == entity.BackendMinio {
+		object, info, err := openMinioObject(ctx, cfg, content.ObjectKey)
+		if err != nil {
+			return nil, 0, time.Time{}, err
+		}
+		return object, info.Size, info.LastModified, nil
+	}
+
+	f, err := os.Open(content.Path)
+	if os.IsNotExist(err) {
+		return nil, 0, time.Time{}, ErrContentNotFound
+	}
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, time.Time{}, err
+	}
+	return f, info.Size(), info.ModTime(), nil
+}
+
+// statBackendContent returns the stored size of the copy of content on a
+// backend
+func statBackendContent(ctx context.Context, cfg *config.Config, backend string, content *repository.StoredContent) (int64, error) {
+	if backend == entity.BackendMinio {
+		info, err := minioClient.Client.StatObject(ctx, cfg.MinioBucket, content.ObjectKey, minio.StatObjectOptions{})
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return 0, ErrContentNotFound
+		}
+		return info.Size, err
+	}
+
+	size, err := utils.GetFileSize(content.Path)
+	if os.IsNotExist(err) {
+		return 0, ErrContentNotFound
+	}
+	return size, err
+}
+
+// storedContentSize returns the number of bytes content takes on storage
+func storedContentSize(content *repository.StoredContent) int64 {
+	size := content.Size
+	if content.Compression != "" {
+		size = content.CompressedSize
+	}
+	if content.Encryption != nil {
+		size = encryption.SealedSize(size, content.Encryption.SegmentSize)
+	}
+	return size
+}
+
+// backendChecksum reads the copy of content on a backend, decoding it, and
+// returns its checksum
+func backendChecksum(ctx context.Context, cfg *config.Config, backend string, content *repository.StoredContent) (string, error) {
+	src, size, modTime, err := openBackendContent(ctx, cfg, backend, content)
+	if err != nil {
+		return "", err
+	}
+	format := contentFormat{Encryption: content.Encryption, Compression: content.Compression, Size: content.Size}
+	decoded, err := decodeContent(cfg, src, size, modTime, format)
+	if err != nil {
+		return "", err
+	}
+	defer decoded.Reader.Close()
+
+	if decoded.Size != content.Size {
+		return "", fmt.Errorf("content has %d bytes, expected %d", decoded.Size, content.Size)
+	}
+	return contentChecksum(&contextReader{ctx: ctx, r: decoded.Reader})
+}
+
+// verifyBackendContent checks that the copy of content on a backend reads
+// back with the expected checksum, or at all when it is unknown
+func verifyBackendContent(ctx context.Context, cfg *config.Config, backend string, content *repository.StoredContent, expected string) error {
+	sum, err := backendChecksum(ctx, cfg, backend, content)
+	if err != nil {
+		return err
+	}
+	if expected != "" && sum != expected {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+// copyBackendContent copies content as stored from one backend to another,
+// replacing any copy already there, and returns the number of bytes copied
+func copyBackendContent(ctx context.Context, cfg *config.Config, content *repository.StoredContent, from, to string) (int64, error) {
+	src, size, _, err := openBackendContent(ctx, cfg, from, content)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	if to == entity.BackendMinio {
+		format := contentFormat{Encryption: content.Encryption, Compression: content.Compression}
+		metadata := minioUserMetadata(content.Metadata, content.Tags, map[string]string{
+			"originalName": content.OriginalName,
+			"uploadID":     content.UploadID.String(),
+		})
+		_, err := minioClient.Client.PutObject(ctx, cfg.MinioBucket, content.ObjectKey, src, size,
+			storedObjectOptions(content.MimeType, format, metadata))
+		return size, err
+	}
+
+	// The file is written next to its path and renamed into place once
+	// complete, so an interrupted copy never looks like stored content
+	if err := os.MkdirAll(filepath.Dir(content.Path), os.ModePerm); err != nil {
+		return 0, err
+	}
+	partPath := content.Path + ".part"
+	out, err := os.Create(partPath)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(out, &contextReader{ctx: ctx, r: src})
+	if err == nil && written != size {
+		err = fmt.Errorf("copied %d bytes, expected %d", written, size)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partPath, content.Path)
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return 0, err
+	}
+	return written, nil
+}
+
+// removeBackendContent deletes the copy of content on a backend
+func removeBackendContent(ctx context.Context, cfg *config.Config, backend string, content *repository.StoredContent) error {
+	if backend == entity.BackendMinio {
+		return minioClient.Client.RemoveObject(ctx, cfg.MinioBucket, content.ObjectKey, minio.RemoveObjectOptions{})
+	}
+	return utils.RemoveFile(content.Path)
+}
@@ -0,0 +1,385 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fileupload/pkg/utils"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// StagedUpload is the stored content of a direct upload that has not been
+// recorded as a file yet
+type StagedUpload struct {
+	UploadID       uuid.UUID
+	FileName       string
+	OriginalName   string
+	MimeType       string
+	Size           int64
+	Path           string
+	Encryption     *entity.Encryption
+	Compression    string
+	CompressedSize int64 // size of the compressed content, when Compression is set
+	Checksum       string
+}
+
+// UploadResult is the outcome of committing one staged upload. With an
+// atomic commit every result carries the error that aborted the batch.
+type UploadResult struct {
+	File *entity.File
+	Err  error
+}
+
+// ErrBatchAborted is reported for uploads of an atomic batch that were
+// rolled back because another upload of the batch failed
+var ErrBatchAborted = errors.New("not stored because another file of the batch failed")
+
+func (u *fileUseCase) DirectUpload(ctx context.Context, owner string, src io.Reader, fileName string, mimeType string, opts UploadOptions) (*entity.File, error) {
+	staged, err := u.StageUpload(ctx, src, fileName, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := u.CommitUploads(ctx, owner, []*StagedUpload{staged}, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	return results[0].File, results[0].Err
+}
+
+func (u *fileUseCase) StageUpload(ctx context.Context, src io.Reader, fileName string, mimeType string) (*StagedUpload, error) {
+	ctx, done, err := u.inflight.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// Generate a unique ID for the upload
+	uploadID := uuid.New()
+
+	originalName := utils.SanitizeFilename(fileName)
+	storedName := uuid.New().String() + storedExtension(originalName)
+	finalDir := u.config.UploadFinalDir
+
+	if err := os.MkdirAll(finalDir, os.ModePerm); err != nil {
+		return nil, errors.New("failed to create directory")
+	}
+	enc, err := newContentEncryption(u.config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Content is written to a temporary file next to its final path and only
+	// renamed into place once complete, so a partial file is never visible
+	// under a stored name
+	out, err := os.CreateTemp(finalDir, ".direct-*.part")
+	if err != nil {
+		return nil, errors.New("failed to create file")
+	}
+	tempPath := out.Name()
+
+	// The size of a streamed part is unknown up front; one byte past the
+	// limit is read so that oversized content is detected. The limit of the
+	// MIME type can only be checked once the content has been sniffed.
+	limit := u.maxUploadSize(uploadModeDirect, "")
+	r := &contextReader{ctx: ctx, r: io.LimitReader(src, limit+1)}
+
+	// The head of the content is read first since the MIME type decides
+	// whether the content is stored compressed
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	head = head[:n]
+	mimeType = detectMimeType(mimeType, originalName, head)
+	format := contentFormat{Encryption: enc, Compression: contentCompression(u.config, mimeType)}
+
+	var w *contentWriter
+	var written int64
+	if err == nil {
+		w, err = newContentWriter(u.config, out, format)
+	}
+	if err == nil {
+		written, err = io.Copy(w, io.MultiReader(bytes.NewReader(head), r))
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		if limit = u.maxUploadSize(uploadModeDirect, mimeType); written > limit {
+			err = fileTooLarge(limit)
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	finalPath := filepath.Join(finalDir, storedName)
+	if err == nil {
+		err = os.Rename(tempPath, finalPath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		if errors.Is(err, context.Canceled) && u.inflight.abortCtx.Err() != nil {
+			return nil, ErrShuttingDown
+		}
+		if errors.Is(err, ErrFileTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return &StagedUpload{
+		UploadID:       uploadID,
+		FileName:       storedName,
+		OriginalName:   originalName,
+		MimeType:       mimeType,
+		Size:           written,
+		Path:           finalPath,
+		Encryption:     enc,
+		Compression:    format.Compression,
+		CompressedSize: w.CompressedSize(),
+		Checksum:       w.Checksum(),
+	}, nil
+}
+
+// storedExtension returns the extension of a sanitized name if it is short
+// and plain enough to be part of a stored name
+func storedExtension(name string) string {
+	ext := filepath.Ext(name)
+	if len(ext) > 16 {
+		return ""
+	}
+	for _, r := range ext[min(1, len(ext)):] {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// sniffLen is the number of bytes http.DetectContentType considers
+const sniffLen = 512
+
+// detectMimeType picks the MIME type of direct upload content. A well-formed
+// specific type declared by the client is kept (without parameters);
+// otherwise the type is guessed from the extension and then the content.
+func detectMimeType(declared, name string, head []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(name))); err == nil {
+		return mediaType
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return mediaType
+}
+
+func (u *fileUseCase) CommitUploads(ctx context.Context, owner string, staged []*StagedUpload, opts UploadOptions, atomic bool) ([]UploadResult, error) {
+	ctx, done, err := u.inflight.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	opts, err = opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	if opts.TargetFileID != nil && len(staged) > 1 {
+		return nil, fmt.Errorf("%w: only a single file can be stored as a new version", ErrInvalidUploadOptions)
+	}
+
+	folderID, err := u.resolveUploadTarget(ctx, owner, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]UploadResult, len(staged))
+	batches := make([][]*entity.File, len(staged))
+	folders := make([][]uuid.UUID, len(staged))
+	for i, s := range staged {
+		batches[i], folders[i], results[i].Err = u.prepareStagedFiles(ctx, owner, folderID, s, opts)
+		if results[i].Err != nil && atomic {
+			u.abortBatch(ctx, staged, batches, folders, results, i)
+			return results, nil
+		}
+	}
+
+	commit := func(repo repository.FileRepository, i int) error {
+		files := batches[i]
+		file, err := commitFileContent(ctx, repo, files[0], opts.TargetFileID)
+		if err != nil {
+			return err
+		}
+		for _, extracted := range files[1:] {
+			if _, err := commitFileContent(ctx, repo, extracted, nil); err != nil {
+				return err
+			}
+		}
+		results[i].File = file
+		return nil
+	}
+
+	if atomic {
+		failed := -1
+		err := commitTransaction(ctx, u.fileRepo, func(repo repository.FileRepository) error {
+			for i := range staged {
+				if err := commit(repo, i); err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logger.FromContext(ctx).WithError(err).Error("failed to record direct uploads")
+			if failed < 0 {
+				failed = 0
+			}
+			results[failed].Err = commitError(err)
+			u.abortBatch(ctx, staged, batches, folders, results, failed)
+			return results, nil
+		}
+	} else {
+		for i, s := range staged {
+			if results[i].Err != nil {
+				continue
+			}
+			err := commitTransaction(ctx, u.fileRepo, func(repo repository.FileRepository) error {
+				return commit(repo, i)
+			})
+			if err != nil {
+				logger.FromContext(ctx).WithError(err).WithField("upload_id", s.UploadID).Error("failed to record direct upload")
+				results[i].Err = commitError(err)
+				results[i].File = nil
+				u.discardBatch(ctx, s, batches[i], folders[i])
+			}
+		}
+	}
+
+	for i, s := range staged {
+		file := results[i].File
+		if file == nil {
+			continue
+		}
+		if opts.TargetFileID != nil {
+			u.pruneFileVersions(ctx, file.ID)
+		}
+		logger.UploadFromContext(ctx).WithFields(logrus.Fields{
+			"upload_id": s.UploadID,
+			"file_id":   file.ID,
+			"owner":     owner,
+			"size":      file.Size,
+		}).Info("direct upload completed")
+	}
+
+	return results, nil
+}
+
+// commitError is the error reported for a direct upload whose file record
+// could not be created. Database details are not passed on to the client.
+func commitError(err error) error {
+	switch {
+	case errors.Is(err, ErrTargetFileDeleted):
+		return ErrTargetFileDeleted
+	case errors.Is(err, ErrTargetFolderDeleted):
+		return ErrTargetFolderDeleted
+	}
+	return errors.New("failed to create file record")
+}
+
+// prepareStagedFiles builds the file record of a staged upload, followed by
+// those of the files extracted from it when extraction is requested. The
+// folders created for extracted files are returned as well.
+func (u *fileUseCase) prepareStagedFiles(ctx context.Context, owner string, folderID *uuid.UUID, s *StagedUpload, opts UploadOptions) ([]*entity.File, []uuid.UUID, error) {
+	now := time.Now()
+	file := &entity.File{
+		ID:             uuid.New(),
+		FileName:       s.FileName,
+		OriginalName:   s.OriginalName,
+		Owner:          owner,
+		FolderID:       folderID,
+		Size:           s.Size,
+		MimeType:       s.MimeType,
+		Metadata:       opts.Metadata,
+		Tags:           opts.Tags,
+		Path:           s.Path,
+		Backend:        entity.BackendLocal,
+		Encryption:     s.Encryption,
+		Compression:    s.Compression,
+		CompressedSize: s.CompressedSize,
+		Checksum:       s.Checksum,
+		UploadID:       s.UploadID, // We still create a reference to a "virtual" upload
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if !opts.Extract {
+		return []*entity.File{file}, nil, nil
+	}
+
+	extracted, err := u.extractArchive(ctx, extractSource{
+		UploadID: s.UploadID,
+		Owner:    owner,
+		FolderID: folderID,
+		Path:     s.Path,
+		Format: contentFormat{
+			Encryption:  s.Encryption,
+			Compression: s.Compression,
+			Size:        s.Size,
+		},
+		Metadata: opts.Metadata,
+		Tags:     opts.Tags,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to extract archive: %w", err)
+	}
+	withExtractedFiles(file, extracted.files)
+	return append([]*entity.File{file}, extracted.files...), extracted.folders, nil
+}
+
+// abortBatch discards every upload of an atomic batch after the upload at
+// index failed
+func (u *fileUseCase) abortBatch(ctx context.Context, staged []*StagedUpload, batches [][]*entity.File, folders [][]uuid.UUID, results []UploadResult, failed int) {
+	for i, s := range staged {
+		results[i].File = nil
+		if i != failed && results[i].Err == nil {
+			results[i].Err = ErrBatchAborted
+		}
+		u.discardBatch(ctx, s, batches[i], folders[i])
+	}
+}
+
+// discardBatch removes the content of a staged upload and of any files
+// extracted from it, and the folders created for them
+func (u *fileUseCase) discardBatch(ctx context.Context, s *StagedUpload, files []*entity.File, folders []uuid.UUID) {
+	extracted := &extraction{folders: folders}
+	for _, file := range files {
+		if file.Path != s.Path {
+			extracted.files = append(extracted.files, file)
+		}
+	}
+	u.discardExtraction(ctx, extracted)
+	os.Remove(s.Path)
+}
+
+func (u *fileUseCase) DiscardUploads(ctx context.Context, staged []*StagedUpload) {
+	for _, s := range staged {
+		os.Remove(s.Path)
+	}
+}
@@ -0,0 +1,107 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/repository"
+	"fileupload/pkg/encryption"
+	"fileupload/pkg/logger"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// rewrapBatchSize is the number of records loaded at once by Rewrap
+const rewrapBatchSize = 500
+
+// RewrapResult counts the records processed by Rewrap. Skipped records were
+// changed concurrently; Failed ones could not be unwrapped, usually because
+// their master key is not configured.
+type RewrapResult struct {
+	Rewrapped int
+	Skipped   int
+	Failed    int
+}
+
+type EncryptionUseCase interface {
+	// Rewrap wraps the data key of all encrypted content that is wrapped by
+	// another master key with the active one, so that the other keys can be
+	// retired. The content itself is not re-encrypted.
+	Rewrap(ctx context.Context) (*RewrapResult, error)
+}
+
+type encryptionUseCase struct {
+	repo   repository.EncryptionRepository
+	config *config.Config
+}
+
+func NewEncryptionUseCase(repo repository.EncryptionRepository, config *config.Config) EncryptionUseCase {
+	return &encryptionUseCase{
+		repo:   repo,
+		config: config,
+	}
+}
+
+func (u *encryptionUseCase) Rewrap(ctx context.Context) (*RewrapResult, error) {
+	keyring := u.config.Keyring()
+	if keyring == nil || keyring.ActiveKeyID() == "" {
+		return nil, errors.New("no active master key is configured")
+	}
+
+	result := &RewrapResult{}
+	for _, kind := range repository.ContentKinds {
+		after := uuid.Nil
+		for {
+			batch, err := u.repo.ListEncryptedContent(ctx, kind, keyring.ActiveKeyID(), after, rewrapBatchSize)
+			if err != nil {
+				return result, fmt.Errorf("failed to list %s records: %w", kind, err)
+			}
+			for _, content := range batch {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				after = content.ID
+				entry := logger.FromContext(ctx).WithFields(logrus.Fields{
+					"kind":   kind,
+					"id":     content.ID,
+					"key_id": content.Encryption.KeyID,
+				})
+
+				updated, err := u.rewrap(ctx, keyring, content)
+				switch {
+				case err != nil:
+					result.Failed++
+					entry.WithError(err).Error("failed to re-wrap data key")
+				case !updated:
+					result.Skipped++
+					entry.Warn("record changed while re-wrapping its data key, skipped")
+				default:
+					result.Rewrapped++
+				}
+			}
+			if len(batch) < rewrapBatchSize {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// rewrap wraps the data key of one record with the active master key
+func (u *encryptionUseCase) rewrap(ctx context.Context, keyring *encryption.Keyring, content *repository.EncryptedContent) (bool, error) {
+	previousKeyID := content.Encryption.KeyID
+	dataKey, err := keyring.Unwrap(previousKeyID, content.Encryption.WrappedKey)
+	if err != nil {
+		return false, err
+	}
+	keyID, wrapped, err := keyring.Wrap(dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	content.Encryption.KeyID = keyID
+	content.Encryption.WrappedKey = wrapped
+	return u.repo.UpdateEncryption(ctx, content, previousKeyID)
+}
@@ -0,0 +1,475 @@
+package usecase
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/domain/entity"
+	"fileupload/pkg/logger"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	minioClient "fileupload/pkg/minio"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNotAnArchive is returned when extraction is requested for content
+	// that is not a ZIP or tar(.gz) archive
+	ErrNotAnArchive = errors.New("upload is not a supported archive")
+	// ErrUnsafeArchive is returned for archives that escape their root,
+	// exceed the extraction limits or are otherwise malformed
+	ErrUnsafeArchive = errors.New("archive is unsafe to extract")
+)
+
+// Metadata recorded on extracted files and on the archive they came from
+const (
+	metadataArchiveSource    = "archive.source"
+	metadataArchivePath      = "archive.path"
+	metadataArchiveExtracted = "archive.extracted"
+)
+
+// extractSource is an archive stored at its final path, about to be
+// committed
+type extractSource struct {
+	UploadID uuid.UUID
+	Owner    string
+	FolderID *uuid.UUID
+	Path     string
+	Format   contentFormat
+	Metadata map[string]string
+	Tags     []string
+}
+
+// extraction is the outcome of extracting an archive: the records of the
+// extracted files, not yet committed, and the folders created for them
+type extraction struct {
+	files   []*entity.File
+	folders []uuid.UUID
+}
+
+// discardExtraction removes the content of extracted files that will not be
+// committed, and the folders created for them unless something else has
+// been put in them since
+func (u *fileUseCase) discardExtraction(ctx context.Context, x *extraction) {
+	if x == nil {
+		return
+	}
+	for _, file := range x.files {
+		removeStoredContent(ctx, u.config, file.Path, file.FileName)
+	}
+	if err := u.folderRepo.DeleteEmptyFolders(ctx, x.folders); err != nil {
+		logger.FromContext(ctx).WithError(err).Warn("failed to delete folders created for an extraction")
+	}
+}
+
+// extractedEntry is a regular file read from an archive
+type extractedEntry struct {
+	Dir  string // slash-separated directory inside the archive, "" at the root
+	Name string
+}
+
+// extractArchive expands an archive into individual stored files and returns
+// their (not yet committed) records. Stored names are derived from the upload
+// ID and the entry's position, so a retried extraction overwrites the files
+// of an interrupted one instead of leaking them. Folders mirroring the
+// archive's directories are created right away, since files can only be
+// committed into existing folders; they are recorded so that the caller can
+// discard them along with the files. On error every file written and folder
+// created so far is removed.
+func (u *fileUseCase) extractArchive(ctx context.Context, src extractSource) (*extraction, error) {
+	content, err := openLocalContent(u.config, src.Path, src.Format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	f := content.Reader
+	defer f.Close()
+
+	settings := u.config.Settings()
+	extractor := &archiveExtractor{
+		ctx:      ctx,
+		u:        u,
+		src:      src,
+		budget:   extractBudget(settings, content.Size),
+		maxFiles: settings.ExtractMaxFiles,
+		dirs:     make(map[string]*uuid.UUID),
+	}
+
+	magic := make([]byte, 262)
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		err = extractor.extractZip(f, content.Size)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(f); err == nil {
+			err = extractor.extractTar(gz)
+			gz.Close()
+		}
+	case len(magic) >= 262 && string(magic[257:262]) == "ustar":
+		err = extractor.extractTar(f)
+	default:
+		err = ErrNotAnArchive
+	}
+
+	result := &extraction{files: extractor.files, folders: extractor.folders}
+	if err != nil {
+		u.discardExtraction(ctx, result)
+		return nil, err
+	}
+
+	logger.UploadFromContext(ctx).WithFields(logrus.Fields{
+		"upload_id": src.UploadID,
+		"files":     len(extractor.files),
+		"skipped":   extractor.skipped,
+		"bytes":     extractor.written,
+	}).Info("archive extracted")
+
+	return result, nil
+}
+
+// extractBudget is the number of bytes an archive of archiveSize bytes may
+// expand to
+func extractBudget(settings *config.Settings, archiveSize int64) int64 {
+	budget := settings.ExtractMaxTotalSize
+	if settings.ExtractMaxRatio > 0 {
+		if byRatio := archiveSize * int64(settings.ExtractMaxRatio); byRatio < budget {
+			budget = byRatio
+		}
+	}
+	return budget
+}
+
+type archiveExtractor struct {
+	ctx      context.Context
+	u        *fileUseCase
+	src      extractSource
+	budget   int64
+	maxFiles int
+	written  int64
+	skipped  int
+	files    []*entity.File
+	folders  []uuid.UUID // created by the extraction
+	dirs     map[string]*uuid.UUID
+}
+
+func (e *archiveExtractor) extractZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsafeArchive, err)
+	}
+
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		if mode.IsDir() {
+			if _, err := e.entryPath(zf.Name, true); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			// Symlinks and other special entries are never materialized
+			e.skip(zf.Name, "not a regular file")
+			continue
+		}
+
+		entry, err := e.entryPath(zf.Name, false)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+		// The declared size is only a hint; the budget is enforced on the
+		// bytes actually inflated
+		if zf.UncompressedSize64 > uint64(e.budget-e.written) {
+			return fmt.Errorf("%w: extracted size exceeds the limit of %d bytes", ErrUnsafeArchive, e.budget)
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsafeArchive, err)
+		}
+		err = e.store(entry, rc, zf.Modified)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsafeArchive, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if _, err := e.entryPath(header.Name, true); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			entry, err := e.entryPath(header.Name, false)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				continue
+			}
+			if err := e.store(entry, tr, header.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader, tar.TypeXHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+			// Consumed by the tar reader
+		default:
+			// Symlinks, hard links and devices are never materialized
+			e.skip(header.Name, "not a regular file")
+		}
+	}
+}
+
+// entryPath validates the name of an archive entry. Names that are absolute
+// or climb out of the archive root reject the whole archive; macOS resource
+// forks are skipped (nil without error).
+func (e *archiveExtractor) entryPath(name string, isDir bool) (*extractedEntry, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return nil, fmt.Errorf("%w: absolute path %q", ErrUnsafeArchive, name)
+	}
+	for _, segment := range strings.Split(strings.Trim(name, "/"), "/") {
+		if segment == ".." {
+			return nil, fmt.Errorf("%w: path %q escapes the archive", ErrUnsafeArchive, name)
+		}
+	}
+
+	clean := path.Clean(strings.Trim(name, "/"))
+	if clean == "." {
+		return nil, nil
+	}
+	if clean == "__MACOSX" || strings.HasPrefix(clean, "__MACOSX/") {
+		return nil, nil
+	}
+
+	dir, base := path.Split(clean)
+	dir = strings.Trim(dir, "/")
+	if isDir {
+		dir, base = clean, ""
+	}
+	for _, segment := range strings.Split(dir, "/") {
+		if segment == "" {
+			continue
+		}
+		if _, err := normalizeFolderName(segment); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsafeArchive, err)
+		}
+	}
+	if dir != "" {
+		if _, err := e.folderFor(dir); err != nil {
+			return nil, err
+		}
+	}
+	if isDir {
+		return nil, nil
+	}
+
+	base, err := normalizeFolderName(base)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid file name %q", ErrUnsafeArchive, name)
+	}
+	return &extractedEntry{Dir: dir, Name: base}, nil
+}
+
+// folderFor returns the folder that mirrors dir below the upload's folder,
+// creating it as needed
+func (e *archiveExtractor) folderFor(dir string) (*uuid.UUID, error) {
+	if dir == "" {
+		return e.src.FolderID, nil
+	}
+	if id, ok := e.dirs[dir]; ok {
+		return id, nil
+	}
+
+	folder, created, err := ensureFolderPath(e.ctx, e.u.folderRepo, e.src.Owner, e.src.FolderID, dir)
+	e.folders = append(e.folders, created...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create folder for %q: %w", dir, err)
+	}
+	e.dirs[dir] = &folder.ID
+	return &folder.ID, nil
+}
+
+// store writes one entry next to its final path, renames it into place and
+// replicates it to MinIO when enabled
+func (e *archiveExtractor) store(entry *extractedEntry, r io.Reader, modTime time.Time) error {
+	if len(e.files) >= e.maxFiles {
+		return fmt.Errorf("%w: more than %d files", ErrUnsafeArchive, e.maxFiles)
+	}
+
+	folderID, err := e.folderFor(entry.Dir)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.NewSHA1(e.src.UploadID, []byte("extract/"+strconv.Itoa(len(e.files))))
+	ext := filepath.Ext(entry.Name)
+	fileName := id.String() + ext
+	finalPath := filepath.Join(e.u.config.UploadFinalDir, fileName)
+	partPath := finalPath + ".part"
+
+	mimeType := mime.TypeByExtension(ext)
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	enc, err := newContentEncryption(e.u.config)
+	if err != nil {
+		return err
+	}
+	format := contentFormat{Encryption: enc, Compression: contentCompression(e.u.config, mimeType)}
+	out, err := os.Create(partPath)
+	if err != nil {
+		return fmt.Errorf("failed to create extracted file: %w", err)
+	}
+
+	// One byte past the remaining budget is read so that an entry which
+	// exceeds it is detected rather than silently truncated
+	remaining := e.budget - e.written
+	var written int64
+	w, err := newContentWriter(e.u.config, out, format)
+	if err == nil {
+		written, err = io.Copy(w, io.LimitReader(&contextReader{ctx: e.ctx, r: r}, remaining+1))
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > remaining {
+		err = fmt.Errorf("%w: extracted size exceeds the limit of %d bytes", ErrUnsafeArchive, e.budget)
+	}
+	if err == nil {
+		err = os.Rename(partPath, finalPath)
+	}
+	if err != nil {
+		os.Remove(partPath)
+		if errors.Is(err, ErrUnsafeArchive) {
+			return err
+		}
+		return fmt.Errorf("failed to write extracted file: %w", err)
+	}
+	e.written += written
+
+	metadata := make(map[string]string, len(e.src.Metadata)+2)
+	for key, value := range e.src.Metadata {
+		metadata[key] = value
+	}
+	archivePath := path.Join(entry.Dir, entry.Name)
+	if len(archivePath) <= maxMetadataValueLength {
+		metadata[metadataArchivePath] = archivePath
+	}
+
+	now := time.Now()
+	if modTime.IsZero() {
+		modTime = now
+	}
+	file := &entity.File{
+		ID:             uuid.New(),
+		FileName:       fileName,
+		OriginalName:   entry.Name,
+		Owner:          e.src.Owner,
+		FolderID:       folderID,
+		Size:           written,
+		MimeType:       mimeType,
+		Metadata:       metadata,
+		Tags:           e.src.Tags,
+		Path:           finalPath,
+		Backend:        entity.BackendLocal,
+		Encryption:     enc,
+		Compression:    format.Compression,
+		CompressedSize: w.CompressedSize(),
+		Checksum:       w.Checksum(),
+		UploadID:       id,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	e.files = append(e.files, file)
+
+	if e.u.config.EnabledMinio {
+		if err := e.u.replicateFile(e.ctx, file); err != nil {
+			return fmt.Errorf("failed to upload extracted file to MinIO: %w", err)
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) skip(name, reason string) {
+	e.skipped++
+	logger.UploadFromContext(e.ctx).WithFields(logrus.Fields{
+		"upload_id": e.src.UploadID,
+		"entry":     name,
+		"reason":    reason,
+	}).Warn("archive entry skipped")
+}
+
+// replicateFile uploads a stored file to MinIO under its file name
+func (u *fileUseCase) replicateFile(ctx context.Context, file *entity.File) error {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	metadata := minioUserMetadata(file.Metadata, file.Tags, map[string]string{
+		"originalName": file.OriginalName,
+		"uploadID":     file.UploadID.String(),
+	})
+	_, err = minioClient.Client.PutObject(ctx, u.config.MinioBucket, file.FileName, f, info.Size(),
+		storedObjectOptions(file.MimeType, fileFormat(file), metadata))
+	return err
+}
+
+// withExtractedFiles links extracted files to the archive they came from and
+// records their number on the archive
+func withExtractedFiles(archive *entity.File, extracted []*entity.File) {
+	metadata := make(map[string]string, len(archive.Metadata)+1)
+	for key, value := range archive.Metadata {
+		metadata[key] = value
+	}
+	metadata[metadataArchiveExtracted] = strconv.Itoa(len(extracted))
+	archive.Metadata = metadata
+
+	for _, file := range extracted {
+		file.Metadata[metadataArchiveSource] = archive.ID.String()
+	}
+}
@@ -0,0 +1,447 @@
+package usecase
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fakeFolderRepository keeps folders in memory. Only the methods used by
+// extraction are implemented.
+type fakeFolderRepository struct {
+	repository.FolderRepository
+	folders map[string]*entity.Folder
+	deleted []uuid.UUID
+}
+
+func newFakeFolderRepository() *fakeFolderRepository {
+	return &fakeFolderRepository{folders: make(map[string]*entity.Folder)}
+}
+
+func folderKey(parentID *uuid.UUID, name string) string {
+	if parentID == nil {
+		return "/" + name
+	}
+	return parentID.String() + "/" + name
+}
+
+func (r *fakeFolderRepository) CreateFolder(ctx context.Context, folder *entity.Folder) error {
+	r.folders[folderKey(folder.ParentID, folder.Name)] = folder
+	return nil
+}
+
+func (r *fakeFolderRepository) GetFolderByName(ctx context.Context, owner string, parentID *uuid.UUID, name string) (*entity.Folder, error) {
+	if folder, ok := r.folders[folderKey(parentID, name)]; ok {
+		return folder, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeFolderRepository) DeleteEmptyFolders(ctx context.Context, ids []uuid.UUID) error {
+	r.deleted = append(r.deleted, ids...)
+	return nil
+}
+
+// testConfig loads a configuration storing files in a temporary directory,
+// with MinIO, encryption and compression disabled
+func testConfig(t *testing.T, env map[string]string) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	defaults := map[string]string{
+		"CONFIG_FILE":           "",
+		"UPLOAD_TEMP_DIR":       filepath.Join(dir, "temp"),
+		"UPLOAD_FINAL_DIR":      filepath.Join(dir, "files"),
+		"ENABLE_MINIO":          "false",
+		"ENCRYPTION_ENABLED":    "false",
+		"COMPRESSION_ALGORITHM": "none",
+	}
+	for key, value := range defaults {
+		t.Setenv(key, value)
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(cfg.UploadFinalDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestExtractBudget(t *testing.T) {
+	tests := []struct {
+		name        string
+		total       int64
+		ratio       int
+		archiveSize int64
+		want        int64
+	}{
+		{"capped by ratio", 1000, 10, 50, 500},
+		{"capped by total", 1000, 10, 500, 1000},
+		{"no ratio", 1000, 0, 50, 1000},
+		{"ratio equal to total", 1000, 10, 100, 1000},
+		{"empty archive", 1000, 10, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := &config.Settings{ExtractMaxTotalSize: tt.total, ExtractMaxRatio: tt.ratio}
+			if got := extractBudget(settings, tt.archiveSize); got != tt.want {
+				t.Fatalf("extractBudget = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntryPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		entry  string
+		isDir  bool
+		want   *extractedEntry
+		unsafe bool
+	}{
+		{name: "file at the root", entry: "a.txt", want: &extractedEntry{Name: "a.txt"}},
+		{name: "nested file", entry: "dir/sub/a.txt", want: &extractedEntry{Dir: "dir/sub", Name: "a.txt"}},
+		{name: "dot segments", entry: "./dir/./a.txt", want: &extractedEntry{Dir: "dir", Name: "a.txt"}},
+		{name: "backslashes", entry: `dir\a.txt`, want: &extractedEntry{Dir: "dir", Name: "a.txt"}},
+		{name: "directory", entry: "dir/sub/", isDir: true},
+		{name: "root", entry: "./", isDir: true},
+		{name: "resource fork", entry: "__MACOSX/dir/._a.txt"},
+		{name: "parent", entry: "../a.txt", unsafe: true},
+		{name: "parent after a directory", entry: "dir/../../a.txt", unsafe: true},
+		{name: "parent within the archive", entry: "dir/../a.txt", unsafe: true},
+		{name: "parent with backslashes", entry: `dir\..\..\a.txt`, unsafe: true},
+		{name: "parent directory", entry: "dir/../../", isDir: true, unsafe: true},
+		{name: "absolute", entry: "/etc/passwd", unsafe: true},
+		{name: "absolute with backslashes", entry: `\etc\passwd`, unsafe: true},
+		{name: "drive letter", entry: "C:/Windows/a.txt", unsafe: true},
+		{name: "drive relative", entry: "C:a.txt", unsafe: true},
+		{name: "control character", entry: "dir/a\x00.txt", unsafe: true},
+		{name: "control character in directory", entry: "d\nir/a.txt", unsafe: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &archiveExtractor{
+				ctx:  context.Background(),
+				u:    &fileUseCase{folderRepo: newFakeFolderRepository()},
+				dirs: make(map[string]*uuid.UUID),
+			}
+			got, err := e.entryPath(tt.entry, tt.isDir)
+			if tt.unsafe {
+				if !errors.Is(err, ErrUnsafeArchive) {
+					t.Fatalf("entryPath(%q) = %v, %v, want ErrUnsafeArchive", tt.entry, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("entryPath(%q) = %+v, want %+v", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
+
+// archiveEntry is an entry of a crafted test archive
+type archiveEntry struct {
+	name     string
+	body     []byte
+	dir      bool
+	symlink  string
+	hardlink string
+	// declaredSize, when set, is the uncompressed size a ZIP entry claims
+	declaredSize uint64
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		body := entry.body
+		switch {
+		case entry.dir:
+			header.SetMode(fs.ModeDir | 0o755)
+		case entry.symlink != "":
+			header.SetMode(fs.ModeSymlink | 0o777)
+			body = []byte(entry.symlink)
+		default:
+			header.SetMode(0o644)
+		}
+
+		if entry.declaredSize != 0 {
+			// The entry is written raw so that its header can lie
+			var raw bytes.Buffer
+			fw, err := flate.NewWriter(&raw, flate.BestCompression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write(body)
+			fw.Close()
+			header.CompressedSize64 = uint64(raw.Len())
+			header.UncompressedSize64 = entry.declaredSize
+			w, err := zw.CreateRaw(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(raw.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTar(t *testing.T, entries []archiveEntry, gzipped bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if gzipped {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(entry.body))}
+		switch {
+		case entry.dir:
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0o755, 0
+		case entry.symlink != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.symlink, 0
+		case entry.hardlink != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, entry.hardlink, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err := tw.Write(entry.body); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestExtractArchive(t *testing.T) {
+	zeros := make([]byte, 4<<20)
+	safe := []archiveEntry{
+		{name: "docs/", dir: true},
+		{name: "docs/readme.txt", body: []byte("hello")},
+		{name: "top.txt", body: []byte("top")},
+	}
+	links := []archiveEntry{
+		{name: "a.txt", body: []byte("a")},
+		{name: "link", symlink: "/etc/passwd"},
+		{name: "dir/escape", symlink: "../../../etc"},
+	}
+
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+		env     map[string]string
+		files   []string // archive paths of the extracted files
+		err     error
+	}{
+		{
+			name:    "zip",
+			archive: func(t *testing.T) []byte { return buildZip(t, safe) },
+			files:   []string{"docs/readme.txt", "top.txt"},
+		},
+		{
+			name:    "tar",
+			archive: func(t *testing.T) []byte { return buildTar(t, safe, false) },
+			files:   []string{"docs/readme.txt", "top.txt"},
+		},
+		{
+			name:    "tar.gz",
+			archive: func(t *testing.T) []byte { return buildTar(t, safe, true) },
+			files:   []string{"docs/readme.txt", "top.txt"},
+		},
+		{
+			name: "zip slip",
+			archive: func(t *testing.T) []byte {
+				return buildZip(t, append(safe, archiveEntry{name: "docs/../../evil.sh", body: []byte("evil")}))
+			},
+			err: ErrUnsafeArchive,
+		},
+		{
+			name: "tar slip",
+			archive: func(t *testing.T) []byte {
+				return buildTar(t, append(safe, archiveEntry{name: "../../evil.sh", body: []byte("evil")}), true)
+			},
+			err: ErrUnsafeArchive,
+		},
+		{
+			name: "zip absolute path",
+			archive: func(t *testing.T) []byte {
+				return buildZip(t, append(safe, archiveEntry{name: "/etc/cron.d/evil", body: []byte("evil")}))
+			},
+			err: ErrUnsafeArchive,
+		},
+		{
+			name: "tar absolute path",
+			archive: func(t *testing.T) []byte {
+				return buildTar(t, append(safe, archiveEntry{name: "/etc/cron.d/evil", body: []byte("evil")}), false)
+			},
+			err: ErrUnsafeArchive,
+		},
+		{
+			name:    "zip symlinks",
+			archive: func(t *testing.T) []byte { return buildZip(t, links) },
+			files:   []string{"a.txt"},
+		},
+		{
+			name: "tar links",
+			archive: func(t *testing.T) []byte {
+				return buildTar(t, append(links, archiveEntry{name: "hard", hardlink: "/etc/shadow"}), true)
+			},
+			files: []string{"a.txt"},
+		},
+		{
+			name: "zip bomb",
+			archive: func(t *testing.T) []byte {
+				return buildZip(t, []archiveEntry{{name: "zeros", body: zeros}})
+			},
+			err: ErrUnsafeArchive,
+		},
+		{
+			name: "zip bomb with an understated size",
+			archive: func(t *testing.T) []byte {
+				return buildZip(t, []archiveEntry{{name: "zeros", body: zeros, declaredSize: 10}})
+			},
+		},
+		{
+			name: "tar.gz bomb",
+			archive: func(t *testing.T) []byte {
+				return buildTar(t, []archiveEntry{{name: "zeros", body: zeros}}, true)
+			},
+			err: ErrUnsafeArchive,
+		},
+		{
+			name: "total size",
+			archive: func(t *testing.T) []byte {
+				return buildTar(t, []archiveEntry{{name: "a", body: zeros[:600<<10]}, {name: "b", body: zeros[:600<<10]}}, false)
+			},
+			env: map[string]string{"EXTRACT_MAX_TOTAL_SIZE_MB": "1"},
+			err: ErrUnsafeArchive,
+		},
+		{
+			name:    "too many files",
+			archive: func(t *testing.T) []byte { return buildZip(t, safe) },
+			env:     map[string]string{"EXTRACT_MAX_FILES": "1"},
+			err:     ErrUnsafeArchive,
+		},
+		{
+			name:    "not an archive",
+			archive: func(t *testing.T) []byte { return []byte("just some text") },
+			err:     ErrNotAnArchive,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, tt.env)
+			folders := newFakeFolderRepository()
+			u := &fileUseCase{folderRepo: folders, config: cfg}
+
+			archivePath := filepath.Join(t.TempDir(), "archive")
+			if err := os.WriteFile(archivePath, tt.archive(t), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			x, err := u.extractArchive(context.Background(), extractSource{
+				UploadID: uuid.New(),
+				Owner:    "owner",
+				Path:     archivePath,
+			})
+
+			stored, readErr := os.ReadDir(cfg.UploadFinalDir)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if tt.files == nil {
+				if err == nil {
+					t.Fatalf("extracted %d files, want an error", len(x.files))
+				}
+				if tt.err != nil && !errors.Is(err, tt.err) {
+					t.Fatalf("extraction failed with %v, want %v", err, tt.err)
+				}
+				// Everything written before the failure is removed
+				if len(stored) != 0 {
+					t.Fatalf("%d files left behind by a failed extraction", len(stored))
+				}
+				if len(folders.deleted) != len(folders.folders) {
+					t.Fatalf("%d folders created, %d discarded", len(folders.folders), len(folders.deleted))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			for _, file := range x.files {
+				paths = append(paths, file.Metadata[metadataArchivePath])
+			}
+			if len(paths) != len(tt.files) {
+				t.Fatalf("extracted %v, want %v", paths, tt.files)
+			}
+			for i := range paths {
+				if paths[i] != tt.files[i] {
+					t.Fatalf("extracted %v, want %v", paths, tt.files)
+				}
+			}
+			if len(stored) != len(tt.files) {
+				t.Fatalf("%d files stored, want %d", len(stored), len(tt.files))
+			}
+			for _, entry := range stored {
+				if !entry.Type().IsRegular() {
+					t.Fatalf("stored %s is not a regular file", entry.Name())
+				}
+			}
+			if len(x.folders) != len(folders.folders) {
+				t.Fatalf("%d folders recorded, %d created", len(x.folders), len(folders.folders))
+			}
+		})
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"fileupload/internal/repository"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"os"
 	"path/filepath"
 	"time"
@@ -18,32 +17,144 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 type FileUseCase interface {
-	InitiateUpload(originalName string, totalSize int64, mimeType string) (*entity.Upload, error)
-	ProcessChunk(uploadID uuid.UUID, chunkReader io.Reader, contentRange string) (*entity.Upload, error)
-	FinalizeUpload(uploadID uuid.UUID) (*entity.File, error)
-	GetUploadStatus(uploadID uuid.UUID) (*entity.Upload, error)
-	DirectUpload(file multipart.File, fileHeader *multipart.FileHeader) (*entity.File, error)
+	InitiateUpload(ctx context.Context, owner string, originalName string, totalSize int64, mimeType string, opts UploadOptions) (*entity.Upload, error)
+	ProcessChunk(ctx context.Context, owner string, uploadID uuid.UUID, chunkReader io.Reader, contentRange string) (*entity.Upload, error)
+	FinalizeUpload(ctx context.Context, owner string, uploadID uuid.UUID) (*entity.File, error)
+	GetUploadStatus(ctx context.Context, owner string, uploadID uuid.UUID) (*entity.Upload, error)
+	// RecoverFinalizations completes or rolls back finalizations abandoned
+	// by a crashed instance, once their lease has expired; it is meant to
+	// run periodically
+	RecoverFinalizations(ctx context.Context) error
+	// DirectUpload stores a single file sent in one request
+	DirectUpload(ctx context.Context, owner string, src io.Reader, fileName string, mimeType string, opts UploadOptions) (*entity.File, error)
+	// StageUpload writes the content of a direct upload to storage without
+	// recording it; CommitUploads then records staged uploads as files and
+	// DiscardUploads removes those that will not be committed
+	StageUpload(ctx context.Context, src io.Reader, fileName string, mimeType string) (*StagedUpload, error)
+	CommitUploads(ctx context.Context, owner string, staged []*StagedUpload, opts UploadOptions, atomic bool) ([]UploadResult, error)
+	DiscardUploads(ctx context.Context, staged []*StagedUpload)
+	// ImportFromURL creates an upload whose content is fetched from a remote
+	// URL in the background. Its progress is followed like any other upload.
+	ImportFromURL(ctx context.Context, owner string, rawURL string, fileName string, opts UploadOptions) (*entity.Upload, error)
+	// RecoverImports fails URL imports abandoned by a crashed instance, once
+	// their lease has expired; it is meant to run periodically
+	RecoverImports(ctx context.Context) error
+
+	GetFile(ctx context.Context, owner string, fileID uuid.UUID) (*entity.File, error)
+	ListFiles(ctx context.Context, owner string, query FileQuery) (*FileList, error)
+	UpdateFileAttributes(ctx context.Context, owner string, fileID uuid.UUID, patch FileAttributesPatch) (*entity.File, error)
+	// DeleteFile deletes a file, its versions and share links, and their
+	// stored content
+	DeleteFile(ctx context.Context, owner string, fileID uuid.UUID) error
+
+	// ListVersions returns a file and its versions, newest first
+	ListVersions(ctx context.Context, owner string, fileID uuid.UUID) (*entity.File, []*entity.FileVersion, error)
+	// PromoteVersion makes an earlier version the current content of a file
+	PromoteVersion(ctx context.Context, owner string, fileID uuid.UUID, version int) (*entity.File, error)
+	// OpenFile opens the content of a file version; version 0 is the current one
+	OpenFile(ctx context.Context, owner string, fileID uuid.UUID, version int) (*FileContent, error)
+	// PruneVersions deletes file versions past the retention period
+	PruneVersions(ctx context.Context)
+
+	// BeginShutdown stops accepting new upload operations
+	BeginShutdown()
+	// Drain waits for in-flight upload operations to finish. Once ctx expires
+	// the remaining operations are aborted and rolled back.
+	Drain(ctx context.Context) error
 }
 
+// ErrFileNotFound is returned for files that do not exist or belong to
+// another owner
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrUploadNotFound is returned for uploads that do not exist or belong to
+// another owner
+var ErrUploadNotFound = errors.New("upload not found")
+
+// ErrTooManyUploads is returned when an owner already has the maximum number
+// of uploads in progress
+var ErrTooManyUploads = errors.New("too many uploads in progress")
+
+// ErrUploadBusy is returned when another instance is finalizing the upload
+var ErrUploadBusy = errors.New("upload is being finalized by another instance")
+
+// ErrChunkTooLarge is returned for chunks above the configured maximum size
+var ErrChunkTooLarge = errors.New("chunk exceeds maximum allowed size")
+
+// FileQuery filters and paginates a file listing
+type FileQuery struct {
+	Tags     []string
+	Metadata map[string]string
+	Page     int
+	PageSize int
+}
+
+// FileList is one page of a file listing
+type FileList struct {
+	Files    []*entity.File
+	Total    int64
+	Page     int
+	PageSize int
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+const (
+	// rollbackGracePeriod is how long Drain waits for aborted operations to
+	// roll back after the shutdown deadline has passed
+	rollbackGracePeriod = 5 * time.Second
+
+	// maxVersionConflictRetries bounds how often a progress update is retried
+	// after losing an optimistic locking race to another instance
+	maxVersionConflictRetries = 5
+)
+
 type fileUseCase struct {
-	fileRepo repository.FileRepository
-	config   *config.Config
+	fileRepo    repository.FileRepository
+	folderRepo  repository.FolderRepository
+	config      *config.Config
+	inflight    *inflightTracker
+	uploadLocks *keyedMutex
 }
 
-func NewFileUseCase(fileRepo repository.FileRepository, config *config.Config) FileUseCase {
+func NewFileUseCase(fileRepo repository.FileRepository, folderRepo repository.FolderRepository, config *config.Config) FileUseCase {
 	return &fileUseCase{
-		fileRepo: fileRepo,
-		config:   config,
+		fileRepo:    fileRepo,
+		folderRepo:  folderRepo,
+		config:      config,
+		inflight:    newInflightTracker(),
+		uploadLocks: newKeyedMutex(),
 	}
 }
 
-func (u *fileUseCase) InitiateUpload(originalName string, totalSize int64, mimeType string) (*entity.Upload, error) {
+func (u *fileUseCase) InitiateUpload(ctx context.Context, owner string, originalName string, totalSize int64, mimeType string, opts UploadOptions) (*entity.Upload, error) {
+	ctx, done, err := u.inflight.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	opts, err = opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	folderID, err := u.resolveUploadTarget(ctx, owner, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check file size limit
-	if totalSize > u.config.MaxFileSize {
-		return nil, errors.New("file size exceeds maximum allowed size")
+	if limit := u.maxUploadSize(uploadModeChunked, mimeType); totalSize > limit {
+		return nil, fileTooLarge(limit)
 	}
 
 	// Generate a unique ID for the upload
@@ -66,29 +177,51 @@ func (u *fileUseCase) InitiateUpload(originalName string, totalSize int64, mimeT
 		ID:           uploadID,
 		FileName:     fileName,
 		OriginalName: originalName,
+		Owner:        owner,
+		FolderID:     folderID,
+		TargetFileID: opts.TargetFileID,
+		Extract:      opts.Extract,
 		TotalSize:    totalSize,
 		UploadedSize: 0,
 		MimeType:     mimeType,
+		Metadata:     opts.Metadata,
+		Tags:         opts.Tags,
 		Status:       "pending",
 		TempPath:     tempPath,
 		CreatedAt:    now,
 		UpdatedAt:    now,
 	}
 
-	err = u.fileRepo.CreateUpload(upload)
+	err = u.createUpload(ctx, upload)
 	if err != nil {
 		// Clean up the temporary file
 		os.Remove(tempPath)
+		if errors.Is(err, ErrTooManyUploads) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to create upload record: %w", err)
 	}
 
+	uploadLog(ctx, upload).Info("upload initiated")
+
 	return upload, nil
 }
 
-func (u *fileUseCase) ProcessChunk(uploadID uuid.UUID, chunkReader io.Reader, contentRange string) (*entity.Upload, error) {
-	upload, err := u.fileRepo.GetUploadByID(uploadID)
+func (u *fileUseCase) ProcessChunk(ctx context.Context, owner string, uploadID uuid.UUID, chunkReader io.Reader, contentRange string) (*entity.Upload, error) {
+	ctx, done, err := u.inflight.begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("upload not found: %w", err)
+		return nil, err
+	}
+	defer done()
+
+	// Chunks of the same upload are written one at a time; other instances
+	// are kept in check by the optimistic version on the upload row
+	unlock := u.uploadLocks.Lock(uploadID)
+	defer unlock()
+
+	upload, err := u.getOwnedUpload(ctx, owner, uploadID)
+	if err != nil {
+		return nil, err
 	}
 
 	if upload.Status == "completed" {
@@ -99,6 +232,14 @@ func (u *fileUseCase) ProcessChunk(uploadID uuid.UUID, chunkReader io.Reader, co
 		return nil, errors.New("upload has failed")
 	}
 
+	if upload.Status == "finalizing" {
+		return nil, errors.New("upload is being finalized")
+	}
+
+	if upload.SourceURL != "" {
+		return nil, errors.New("upload is fetched from a URL")
+	}
+
 	// Parse content range header (format: bytes start-end/total)
 	var start, end, total int64
 	_, err = fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total)
@@ -111,6 +252,15 @@ func (u *fileUseCase) ProcessChunk(uploadID uuid.UUID, chunkReader io.Reader, co
 		return nil, errors.New("total size mismatch")
 	}
 
+	if start < 0 || end < start || end >= total {
+		return nil, errors.New("invalid content range")
+	}
+
+	chunkSize := end - start + 1
+	if maxChunkSize := u.config.Settings().MaxChunkSize; maxChunkSize > 0 && chunkSize > maxChunkSize {
+		return nil, &SizeLimitError{Err: ErrChunkTooLarge, Limit: maxChunkSize}
+	}
+
 	if start > upload.UploadedSize {
 		return nil, errors.New("chunk out of order")
 	}
@@ -128,180 +278,508 @@ func (u *fileUseCase) ProcessChunk(uploadID uuid.UUID, chunkReader io.Reader, co
 		return nil, fmt.Errorf("failed to seek in file: %w", err)
 	}
 
-	// Write the chunk
-	written, err := io.Copy(file, chunkReader)
+	// Write the chunk. The reader is wrapped so that an aborted shutdown
+	// interrupts the copy between reads instead of after the whole chunk, and
+	// limited to one byte past the declared range so that an oversized body
+	// is detected without writing all of it.
+	written, err := io.Copy(file, &contextReader{ctx: ctx, r: io.LimitReader(chunkReader, chunkSize+1)})
 	if err != nil {
-		upload.Status = "failed"
-		u.fileRepo.UpdateUpload(upload)
+		u.rollbackChunk(ctx, upload, file, err)
 		return nil, fmt.Errorf("failed to write chunk: %w", err)
 	}
 
 	// Update upload status
-	chunkSize := end - start + 1
 	if written != chunkSize {
-		upload.Status = "failed"
-		u.fileRepo.UpdateUpload(upload)
-		return nil, fmt.Errorf("chunk size mismatch: expected %d, got %d", chunkSize, written)
+		err = fmt.Errorf("chunk size mismatch: expected %d, got %d", chunkSize, written)
+		u.rollbackChunk(ctx, upload, file, err)
+		return nil, err
 	}
 
-	// Update upload progress
-	newUploadedSize := start + written
-	if newUploadedSize > upload.UploadedSize {
-		upload.UploadedSize = newUploadedSize
-	}
-	upload.Status = "uploading"
-	upload.UpdatedAt = time.Now()
-
-	err = u.fileRepo.UpdateUpload(upload)
+	// Update upload progress. The chunk is on disk at this point; record it
+	// even if the request has been cancelled so the stored progress matches
+	// the temporary file.
+	previousStatus := upload.Status
+	err = u.recordChunkProgress(context.WithoutCancel(ctx), upload, start+written)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update upload record: %w", err)
 	}
 
+	entry := uploadLog(ctx, upload).WithFields(logrus.Fields{
+		"chunk_start": start,
+		"chunk_end":   end,
+	})
+	if previousStatus != upload.Status {
+		entry.WithField("previous_status", previousStatus).Info("upload status changed")
+	} else {
+		entry.Debug("chunk written")
+	}
+
 	return upload, nil
 }
 
-func (u *fileUseCase) FinalizeUpload(uploadID uuid.UUID) (*entity.File, error) {
-	upload, err := u.fileRepo.GetUploadByID(uploadID)
+// recordChunkProgress stores the progress reached by a chunk ending at
+// chunkEnd. If another writer updated the upload since it was read, the row is
+// re-read and the update retried, so UploadedSize never moves backwards and a
+// concurrent status change is never overwritten.
+func (u *fileUseCase) recordChunkProgress(ctx context.Context, upload *entity.Upload, chunkEnd int64) error {
+	for attempt := 0; ; attempt++ {
+		if chunkEnd > upload.UploadedSize {
+			upload.UploadedSize = chunkEnd
+		}
+		upload.Status = "uploading"
+		upload.UpdatedAt = time.Now()
+
+		err := u.fileRepo.UpdateUpload(ctx, upload, repository.UploadFieldUploadedSize, repository.UploadFieldStatus)
+		if !errors.Is(err, repository.ErrVersionConflict) || attempt >= maxVersionConflictRetries {
+			return err
+		}
+
+		latest, err := u.fileRepo.GetUploadByID(ctx, upload.ID)
+		if err != nil {
+			return err
+		}
+		if latest.Status != "pending" && latest.Status != "uploading" {
+			return fmt.Errorf("upload changed to %s while writing chunk", latest.Status)
+		}
+		*upload = *latest
+	}
+}
+
+// rollbackChunk discards a partially written chunk by truncating the
+// temporary file back to the last recorded progress. The upload itself is
+// left resumable so the client can send the chunk again.
+func (u *fileUseCase) rollbackChunk(ctx context.Context, upload *entity.Upload, file *os.File, cause error) {
+	entry := uploadLog(ctx, upload).WithError(cause)
+	if err := file.Truncate(upload.UploadedSize); err != nil {
+		entry.WithField("truncate_error", err.Error()).Error("failed to roll back partial chunk")
+		return
+	}
+	entry.Warn("partial chunk rolled back")
+}
+
+func (u *fileUseCase) FinalizeUpload(ctx context.Context, owner string, uploadID uuid.UUID) (*entity.File, error) {
+	ctx, done, err := u.inflight.begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("upload not found: %w", err)
+		return nil, err
 	}
+	defer done()
 
+	unlock := u.uploadLocks.Lock(uploadID)
+	defer unlock()
+
+	upload, err := u.getOwnedUpload(ctx, owner, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if file, err := u.checkFinalizable(ctx, upload); file != nil || err != nil {
+		return file, err
+	}
+
+	// Only one instance finalizes an upload at a time. Once claimed, the
+	// upload is read again since another instance may have finalized it in
+	// the meantime.
+	ctx, release, claimed, err := u.claimUpload(ctx, upload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim upload: %w", err)
+	}
+	if !claimed {
+		return nil, ErrUploadBusy
+	}
+	defer release()
+
+	upload, err = u.getOwnedUpload(ctx, owner, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if file, err := u.checkFinalizable(ctx, upload); file != nil || err != nil {
+		return file, err
+	}
+
+	return u.runFinalize(ctx, upload)
+}
+
+// checkFinalizable returns an error if upload cannot be finalized, and the
+// file it produced if it already was. Both are nil if it can be finalized.
+func (u *fileUseCase) checkFinalizable(ctx context.Context, upload *entity.Upload) (*entity.File, error) {
+	// Finalizing a completed upload again (e.g. a retry after the response
+	// was lost) returns the file it produced
 	if upload.Status == "completed" {
-		return nil, errors.New("upload already completed")
+		file, err := findCommittedFile(ctx, u.fileRepo, upload.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load file of completed upload: %w", err)
+		}
+		return file, nil
 	}
 
 	if upload.Status == "failed" {
 		return nil, errors.New("upload has failed")
 	}
 
-	// Check if all chunks have been uploaded
-	if upload.UploadedSize != upload.TotalSize {
+	if upload.SourceURL != "" {
+		return nil, errors.New("upload is fetched from a URL and finalized once downloaded")
+	}
+
+	// Check if all chunks have been uploaded. An upload that is already
+	// finalizing was interrupted part way and is simply resumed.
+	if upload.Status != "finalizing" && upload.UploadedSize != upload.TotalSize {
 		return nil, fmt.Errorf("upload incomplete: expected %d bytes, got %d bytes", upload.TotalSize, upload.UploadedSize)
 	}
+	return nil, nil
+}
 
-	// Move the file from temp directory to final directory
-	finalPath := filepath.Join(u.config.UploadFinalDir, upload.FileName)
-	err = os.Rename(upload.TempPath, finalPath)
+// RecoverFinalizations resumes every upload left in the "finalizing" state by
+// a crash or an aborted shutdown. Uploads still leased by a live instance,
+// this one included, are left alone. Uploads whose data can no longer be
+// found are marked as failed; transient errors leave the upload to be
+// retried.
+func (u *fileUseCase) RecoverFinalizations(ctx context.Context) error {
+	uploads, err := u.fileRepo.ListUploadsByStatus(ctx, "finalizing")
 	if err != nil {
-		upload.Status = "failed"
-		u.fileRepo.UpdateUpload(upload)
-		return nil, fmt.Errorf("failed to move file to final location: %w", err)
+		return fmt.Errorf("failed to list interrupted finalizations: %w", err)
 	}
 
-	// Update upload status
-	now := time.Now()
-	upload.Status = "completed"
-	upload.UpdatedAt = now
-	upload.CompletedAt = &now
+	for _, upload := range uploads {
+		u.recoverFinalization(ctx, upload)
+	}
 
-	err = u.fileRepo.UpdateUpload(upload)
+	return nil
+}
+
+func (u *fileUseCase) recoverFinalization(ctx context.Context, upload *entity.Upload) {
+	// Tracked like any finalize so that shutdown waits for it
+	ctx, done, err := u.inflight.begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update upload record: %w", err)
+		return
 	}
+	defer done()
 
-	// Create file record
-	file := &entity.File{
-		ID:           uuid.New(),
-		FileName:     upload.FileName,
-		OriginalName: upload.OriginalName,
-		Size:         upload.TotalSize,
-		MimeType:     upload.MimeType,
-		Path:         finalPath,
-		UploadID:     upload.ID,
-		CreatedAt:    now,
-		UpdatedAt:    now,
+	entry := uploadLog(ctx, upload).WithField("finalize_step", upload.FinalizeStep)
+	ctx, release, claimed, err := u.claimUpload(ctx, upload)
+	if err != nil {
+		entry.WithError(err).Error("failed to claim interrupted finalize")
+		return
+	}
+	if !claimed {
+		return
 	}
+	defer release()
+
+	unlock := u.uploadLocks.Lock(upload.ID)
+	defer unlock()
 
-	err = u.fileRepo.CreateFile(file)
+	// The listed state may be stale by the time the upload is claimed
+	upload, err = u.fileRepo.GetUploadByID(ctx, upload.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create file record: %w", err)
+		entry.WithError(err).Error("failed to reload interrupted finalize")
+		return
 	}
-	if u.config.EnabledMinio {
-		f, err := os.Open(finalPath)
-		if err != nil {
-			upload.Status = "failed"
-			u.fileRepo.UpdateUpload(upload)
-			return nil, fmt.Errorf("failed to open file for minio upload: %w", err)
+	if upload.Status != "finalizing" {
+		return
+	}
+
+	entry.Info("resuming interrupted finalize")
+	if _, err := u.runFinalize(ctx, upload); err != nil {
+		entry.WithError(err).Error("failed to recover interrupted finalize")
+	}
+}
+
+// resolveUploadTarget checks the target file of an upload, if any, and
+// otherwise resolves the folder the new file is placed in
+func (u *fileUseCase) resolveUploadTarget(ctx context.Context, owner string, opts UploadOptions) (*uuid.UUID, error) {
+	if opts.TargetFileID != nil {
+		if _, err := u.GetFile(ctx, owner, *opts.TargetFileID); err != nil {
+			return nil, err
 		}
-		defer f.Close()
+		return nil, nil
+	}
+	return resolveUploadFolder(ctx, u.folderRepo, owner, opts.FolderID, opts.FolderPath)
+}
+
+// createUpload records a new upload unless its owner already has the
+// maximum number of uploads in progress. Uploads left idle for longer than
+// ActiveUploadIdleTimeout no longer count.
+func (u *fileUseCase) createUpload(ctx context.Context, upload *entity.Upload) error {
+	settings := u.config.Settings()
+	if settings.MaxActiveUploads <= 0 {
+		return u.fileRepo.CreateUpload(ctx, upload)
+	}
 
-		fileStat, err := f.Stat()
+	var since time.Time
+	if settings.ActiveUploadIdleTimeout > 0 {
+		since = time.Now().Add(-settings.ActiveUploadIdleTimeout)
+	}
+	return u.fileRepo.Transaction(ctx, func(repo repository.FileRepository) error {
+		if err := repo.LockOwnerUploads(ctx, upload.Owner); err != nil {
+			return err
+		}
+		active, err := repo.CountActiveUploads(ctx, upload.Owner, since)
 		if err != nil {
-			upload.Status = "failed"
-			u.fileRepo.UpdateUpload(upload)
-			return nil, fmt.Errorf("failed to stat file: %w", err)
+			return err
+		}
+		if active >= int64(settings.MaxActiveUploads) {
+			return fmt.Errorf("%w: at most %d uploads can be in progress at once", ErrTooManyUploads, settings.MaxActiveUploads)
 		}
+		return repo.CreateUpload(ctx, upload)
+	})
+}
 
-		_, err = minioClient.Client.PutObject(context.Background(), "uploads",
-			upload.FileName, f, fileStat.Size(), minio.PutObjectOptions{
-				ContentType: upload.MimeType,
-				UserMetadata: map[string]string{
-					"originalName": upload.OriginalName,
-					"uploadID":     upload.ID.String(),
-				},
-			})
-		if err != nil {
-			upload.Status = "failed"
-			u.fileRepo.UpdateUpload(upload)
-			logger.UploadLog.Errorf("failed to upload file to MinIO: %v", err)
-			return nil, fmt.Errorf("failed to upload file to MinIO: %w", err)
+func (u *fileUseCase) GetUploadStatus(ctx context.Context, owner string, uploadID uuid.UUID) (*entity.Upload, error) {
+	return u.getOwnedUpload(ctx, owner, uploadID)
+}
+
+// claimUpload takes the lease of an upload for this instance, for work that
+// must not run on two instances at once. It reports false while another
+// instance holds the lease. Once claimed, the lease is renewed until the
+// returned function is called, which also releases it.
+func (u *fileUseCase) claimUpload(ctx context.Context, upload *entity.Upload) (context.Context, func(), bool, error) {
+	claimed, err := u.fileRepo.ClaimUploadLease(ctx, upload.ID, instanceID, time.Now().Add(jobLease))
+	if err != nil || !claimed {
+		return ctx, nil, false, err
+	}
+	leaseCtx, release := u.holdUploadLease(ctx, upload)
+	return leaseCtx, release, true, nil
+}
+
+// holdUploadLease renews the lease this instance holds on an upload until
+// the returned function is called, which also releases it
+func (u *fileUseCase) holdUploadLease(ctx context.Context, upload *entity.Upload) (context.Context, func()) {
+	leaseCtx, stop := holdLease(ctx, func(ctx context.Context, until time.Time) (bool, error) {
+		return u.fileRepo.RenewUploadLease(ctx, upload.ID, instanceID, until)
+	})
+	return leaseCtx, func() {
+		stop()
+		if err := u.fileRepo.ReleaseUploadLease(context.WithoutCancel(ctx), upload.ID, instanceID); err != nil {
+			uploadLog(ctx, upload).WithError(err).Warn("failed to release upload lease")
 		}
+	}
+}
 
+// getOwnedUpload loads an upload, reporting those of other owners as not
+// found like missing ones
+func (u *fileUseCase) getOwnedUpload(ctx context.Context, owner string, uploadID uuid.UUID) (*entity.Upload, error) {
+	upload, err := u.fileRepo.GetUploadByID(ctx, uploadID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upload: %w", err)
+	}
+	if upload.Owner != owner {
+		return nil, ErrUploadNotFound
 	}
+	return upload, nil
+}
 
+func (u *fileUseCase) GetFile(ctx context.Context, owner string, fileID uuid.UUID) (*entity.File, error) {
+	file, err := u.fileRepo.GetFileByID(ctx, fileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file.Owner != owner {
+		return nil, ErrFileNotFound
+	}
 	return file, nil
 }
 
-func (u *fileUseCase) GetUploadStatus(uploadID uuid.UUID) (*entity.Upload, error) {
-	return u.fileRepo.GetUploadByID(uploadID)
+func (u *fileUseCase) ListFiles(ctx context.Context, owner string, query FileQuery) (*FileList, error) {
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = defaultPageSize
+	}
+	if query.PageSize > maxPageSize {
+		query.PageSize = maxPageSize
+	}
+
+	files, total, err := u.fileRepo.ListFiles(ctx, repository.FileFilter{
+		Owner:    owner,
+		Tags:     query.Tags,
+		Metadata: query.Metadata,
+		Limit:    query.PageSize,
+		Offset:   (query.Page - 1) * query.PageSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	return &FileList{
+		Files:    files,
+		Total:    total,
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	}, nil
 }
 
-func (u *fileUseCase) DirectUpload(file multipart.File, fileHeader *multipart.FileHeader) (*entity.File, error) {
-	if fileHeader.Size > u.config.MaxFileSize {
-		return nil, errors.New("file size exceeds maximum allowed size")
+func (u *fileUseCase) UpdateFileAttributes(ctx context.Context, owner string, fileID uuid.UUID, patch FileAttributesPatch) (*entity.File, error) {
+	file, err := u.GetFile(ctx, owner, fileID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Generate a unique ID for the upload
-	uploadID := uuid.New()
+	metadata := make(map[string]string, len(file.Metadata)+len(patch.Metadata))
+	for key, value := range file.Metadata {
+		metadata[key] = value
+	}
+	for key, value := range patch.Metadata {
+		if value == nil {
+			delete(metadata, key)
+			continue
+		}
+		metadata[key] = *value
+	}
+	if file.Metadata, err = normalizeMetadata(metadata); err != nil {
+		return nil, err
+	}
 
-	ext := filepath.Ext(fileHeader.Filename)
-	fileName := uuid.New().String() + ext
-	finalDir := u.config.UploadFinalDir
+	if patch.Tags != nil {
+		if file.Tags, err = normalizeTags(patch.Tags); err != nil {
+			return nil, err
+		}
+	}
 
-	if err := os.MkdirAll(finalDir, os.ModePerm); err != nil {
-		return nil, errors.New("failed to create directory")
+	file.UpdatedAt = time.Now()
+	if err := u.fileRepo.UpdateFileAttributes(ctx, file); err != nil {
+		return nil, fmt.Errorf("failed to update file: %w", err)
 	}
 
-	finalPath := filepath.Join(finalDir, fileName)
-	out, err := os.Create(finalPath)
-	if err != nil {
-		return nil, errors.New("failed to create file")
+	if u.config.EnabledMinio {
+		u.syncMinioMetadata(ctx, file)
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, file)
+	return file, nil
+}
+
+func (u *fileUseCase) DeleteFile(ctx context.Context, owner string, fileID uuid.UUID) error {
+	file, err := u.GetFile(ctx, owner, fileID)
 	if err != nil {
-		return nil, errors.New("failed to write file")
+		return err
 	}
 
-	now := time.Now()
+	// Collect the content of every version before the rows disappear. The
+	// file row is locked meanwhile, so that no version can be committed
+	// between the listing and the deletion.
+	var versions []*entity.FileVersion
+	err = u.fileRepo.Transaction(ctx, func(repo repository.FileRepository) error {
+		locked, err := repo.GetFileByIDForUpdate(ctx, file.ID)
+		if err != nil {
+			return err
+		}
+		file = locked
+		if versions, err = repo.ListFileVersions(ctx, file.ID); err != nil {
+			return fmt.Errorf("failed to load file versions: %w", err)
+		}
+		if err := repo.DeleteFile(ctx, file.ID); err != nil {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+		return nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrFileNotFound
+	}
+	if err != nil {
+		return err
+	}
 
-	fileEntity := &entity.File{
-		ID:           uuid.New(),
-		FileName:     fileName,
-		OriginalName: fileHeader.Filename,
-		Size:         fileHeader.Size,
-		MimeType:     fileHeader.Header.Get("Content-Type"),
-		Path:         finalPath,
-		UploadID:     uploadID, // We still create a reference to a "virtual" upload
-		CreatedAt:    now,
-		UpdatedAt:    now,
+	// Stored content is removed only after the rows are gone, so a failure
+	// here leaves orphaned bytes rather than records pointing at nothing
+	removeStoredContent(ctx, u.config, file.Path, file.FileName)
+	for _, version := range versions {
+		if version.Path != file.Path {
+			removeStoredContent(ctx, u.config, version.Path, version.FileName)
+		}
 	}
 
-	err = u.fileRepo.CreateFile(fileEntity)
+	logger.FromContext(ctx).WithFields(logrus.Fields{
+		"file_id":  file.ID,
+		"versions": len(versions),
+	}).Info("file deleted")
+	return nil
+}
+
+// syncMinioMetadata replaces the user metadata of the file's MinIO object, if
+// it has one, by copying the object onto itself. Replacing the metadata also
+// replaces the content type and coding, so they are set again as the object
+// was uploaded with.
+func (u *fileUseCase) syncMinioMetadata(ctx context.Context, file *entity.File) {
+	entry := logger.FromContext(ctx).WithField("file_id", file.ID)
+
+	_, err := minioClient.Client.StatObject(ctx, u.config.MinioBucket, file.FileName, minio.StatObjectOptions{})
+	if err != nil {
+		entry.WithError(err).Debug("file has no minio object, skipping metadata sync")
+		return
+	}
+
+	opts := storedObjectOptions(file.MimeType, fileFormat(file), minioUserMetadata(file.Metadata, file.Tags, map[string]string{
+		"originalName": file.OriginalName,
+		"uploadID":     file.UploadID.String(),
+	}))
+	// CopyDestOptions has no content type or coding of its own; standard
+	// headers passed as metadata are sent as such
+	opts.UserMetadata["Content-Type"] = opts.ContentType
+	if opts.ContentEncoding != "" {
+		opts.UserMetadata["Content-Encoding"] = opts.ContentEncoding
+	}
+
+	_, err = minioClient.Client.CopyObject(ctx,
+		minio.CopyDestOptions{
+			Bucket:          u.config.MinioBucket,
+			Object:          file.FileName,
+			ReplaceMetadata: true,
+			UserMetadata:    opts.UserMetadata,
+		},
+		minio.CopySrcOptions{
+			Bucket: u.config.MinioBucket,
+			Object: file.FileName,
+		})
 	if err != nil {
-		return nil, errors.New("failed to create file record")
+		entry.WithError(err).Warn("failed to update minio object metadata")
 	}
+}
+
+func (u *fileUseCase) BeginShutdown() {
+	u.inflight.stopAccepting()
+}
+
+func (u *fileUseCase) Drain(ctx context.Context) error {
+	err := u.inflight.wait(ctx)
+	if err == nil {
+		return nil
+	}
+
+	logger.FromContext(ctx).Warn("shutdown deadline reached, aborting in-flight upload operations")
+	u.inflight.abort()
+
+	graceCtx, cancel := context.WithTimeout(context.Background(), rollbackGracePeriod)
+	defer cancel()
+	if waitErr := u.inflight.wait(graceCtx); waitErr != nil {
+		return fmt.Errorf("in-flight upload operations did not roll back in time: %w", waitErr)
+	}
+	return err
+}
+
+// markFailed flags the upload as failed and records the transition
+func (u *fileUseCase) markFailed(ctx context.Context, upload *entity.Upload, cause error) {
+	previousStatus := upload.Status
+	upload.Status = "failed"
+	upload.Error = cause.Error()
+	upload.UpdatedAt = time.Now()
+	if err := u.fileRepo.UpdateUpload(ctx, upload, repository.UploadFieldStatus, repository.UploadFieldError); err != nil {
+		logger.FromContext(ctx).WithError(err).WithField("upload_id", upload.ID).Error("failed to mark upload as failed")
+	}
+	uploadLog(ctx, upload).WithError(cause).WithField("previous_status", previousStatus).Error("upload failed")
+}
 
-	return fileEntity, nil
+// uploadLog returns an upload log entry describing the current state of upload
+func uploadLog(ctx context.Context, upload *entity.Upload) *logrus.Entry {
+	return logger.UploadFromContext(ctx).WithFields(logrus.Fields{
+		"upload_id":     upload.ID,
+		"owner":         upload.Owner,
+		"status":        upload.Status,
+		"total_size":    upload.TotalSize,
+		"uploaded_size": upload.UploadedSize,
+	})
 }
@@ -0,0 +1,331 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrVersionNotFound is returned for file versions that do not exist
+var ErrVersionNotFound = errors.New("file version not found")
+
+// ErrTargetFileDeleted is returned when the file an upload adds a version to
+// was deleted before the upload completed
+var ErrTargetFileDeleted = fmt.Errorf("%w: the file this upload adds a version to was deleted", ErrFileNotFound)
+
+// ErrTargetFolderDeleted is returned when the folder an upload is stored in
+// was deleted before the upload completed
+var ErrTargetFolderDeleted = fmt.Errorf("%w: the folder this upload is stored in was deleted", ErrFolderNotFound)
+
+// targetDeleted reports whether err means that the file or folder content
+// was to be committed to is gone, so that no retry of the commit can succeed
+func targetDeleted(err error) bool {
+	return errors.Is(err, ErrTargetFileDeleted) || errors.Is(err, ErrTargetFolderDeleted)
+}
+
+// maxCommitAttempts bounds how often a commit whose version number was taken
+// by a concurrent one is run again
+const maxCommitAttempts = 3
+
+// commitTransaction runs fn, which commits file content, in a transaction.
+// commitFileContent locks the target file so that versions are numbered one
+// after the other; a commit that still loses its number is run again.
+func commitTransaction(ctx context.Context, repo repository.FileRepository, fn func(repo repository.FileRepository) error) error {
+	for attempt := 1; ; attempt++ {
+		err := repo.Transaction(ctx, fn)
+		if !errors.Is(err, repository.ErrVersionTaken) || attempt >= maxCommitAttempts {
+			return err
+		}
+		logger.FromContext(ctx).WithError(err).Warn("file version taken concurrently, retrying commit")
+	}
+}
+
+// commitFileContent records newly stored content. Without a target it becomes
+// version 1 of a new file; with one it becomes the next version of that file,
+// which then points at it. It must run inside a transaction, preferably
+// through commitTransaction.
+func commitFileContent(ctx context.Context, repo repository.FileRepository, content *entity.File, targetFileID *uuid.UUID) (*entity.File, error) {
+	if targetFileID == nil {
+		// The folder stays locked until the transaction ends, so that it
+		// cannot be deleted without the new file
+		if content.FolderID != nil {
+			err := repo.LockFolder(ctx, *content.FolderID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrTargetFolderDeleted
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to lock folder: %w", err)
+			}
+		}
+
+		content.CurrentVersion = 1
+		if err := repo.CreateFile(ctx, content); err != nil {
+			return nil, fmt.Errorf("failed to create file record: %w", err)
+		}
+		if err := repo.CreateFileVersion(ctx, versionOf(content, 1)); err != nil {
+			return nil, fmt.Errorf("failed to create file version: %w", err)
+		}
+		return content, nil
+	}
+
+	// The row stays locked until the transaction ends, so that concurrent
+	// versions of the file are numbered one after the other and the file
+	// cannot be deleted underneath the new version
+	file, err := repo.GetFileByIDForUpdate(ctx, *targetFileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTargetFileDeleted
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load target file: %w", err)
+	}
+	versions, err := repo.ListFileVersions(ctx, file.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load file versions: %w", err)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("file %s has no versions", file.ID)
+	}
+
+	next := versions[0].Version + 1
+	file.FileName = content.FileName
+	file.OriginalName = content.OriginalName
+	file.Size = content.Size
+	file.MimeType = content.MimeType
+	file.Path = content.Path
+	file.Backend = content.Backend
+	file.Encryption = content.Encryption
+	file.Compression = content.Compression
+	file.CompressedSize = content.CompressedSize
+	file.Checksum = content.Checksum
+	file.UploadID = content.UploadID
+	file.CurrentVersion = next
+	file.UpdatedAt = content.UpdatedAt
+
+	if err := repo.CreateFileVersion(ctx, versionOf(file, next)); err != nil {
+		return nil, fmt.Errorf("failed to create file version: %w", err)
+	}
+	if err := repo.UpdateFileContent(ctx, file); err != nil {
+		return nil, fmt.Errorf("failed to update file: %w", err)
+	}
+	return file, nil
+}
+
+// findCommittedFile returns the file an upload's content was committed to,
+// or gorm.ErrRecordNotFound if it has not been committed yet
+func findCommittedFile(ctx context.Context, repo repository.FileRepository, uploadID uuid.UUID) (*entity.File, error) {
+	version, err := repo.GetFileVersionByUploadID(ctx, uploadID)
+	if err == nil {
+		return repo.GetFileByID(ctx, version.FileID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	// Files committed before versioning existed have no version row
+	return repo.GetFileByUploadID(ctx, uploadID)
+}
+
+func versionOf(file *entity.File, number int) *entity.FileVersion {
+	return &entity.FileVersion{
+		ID:             uuid.New(),
+		FileID:         file.ID,
+		Version:        number,
+		FileName:       file.FileName,
+		OriginalName:   file.OriginalName,
+		Size:           file.Size,
+		MimeType:       file.MimeType,
+		Path:           file.Path,
+		Backend:        file.Backend,
+		Encryption:     file.Encryption,
+		Compression:    file.Compression,
+		CompressedSize: file.CompressedSize,
+		Checksum:       file.Checksum,
+		UploadID:       file.UploadID,
+		CreatedAt:      file.UpdatedAt,
+	}
+}
+
+func (u *fileUseCase) ListVersions(ctx context.Context, owner string, fileID uuid.UUID) (*entity.File, []*entity.FileVersion, error) {
+	file, err := u.GetFile(ctx, owner, fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	versions, err := u.fileRepo.ListFileVersions(ctx, file.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load file versions: %w", err)
+	}
+	return file, versions, nil
+}
+
+func (u *fileUseCase) PromoteVersion(ctx context.Context, owner string, fileID uuid.UUID, number int) (*entity.File, error) {
+	file, err := u.GetFile(ctx, owner, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file row is locked while the version is read and promoted, so that
+	// a concurrent commit or promotion cannot be overwritten
+	promoted := false
+	err = commitTransaction(ctx, u.fileRepo, func(repo repository.FileRepository) error {
+		locked, err := repo.GetFileByIDForUpdate(ctx, file.ID)
+		if err != nil {
+			return err
+		}
+		file = locked
+
+		version, err := repo.GetFileVersion(ctx, file.ID, number)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrVersionNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load file version: %w", err)
+		}
+		if file.CurrentVersion == number {
+			return nil
+		}
+
+		file.FileName = version.FileName
+		file.OriginalName = version.OriginalName
+		file.Size = version.Size
+		file.MimeType = version.MimeType
+		file.Path = version.Path
+		file.Backend = version.Backend
+		file.Encryption = version.Encryption
+		file.Compression = version.Compression
+		file.CompressedSize = version.CompressedSize
+		file.Checksum = version.Checksum
+		file.UploadID = version.UploadID
+		file.CurrentVersion = version.Version
+		file.UpdatedAt = time.Now()
+		if err := repo.UpdateFileContent(ctx, file); err != nil {
+			return fmt.Errorf("failed to promote version: %w", err)
+		}
+		promoted = true
+		return nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if promoted {
+		logger.FromContext(ctx).WithFields(logrus.Fields{
+			"file_id": file.ID,
+			"version": number,
+		}).Info("file version promoted")
+	}
+	return file, nil
+}
+
+func (u *fileUseCase) OpenFile(ctx context.Context, owner string, fileID uuid.UUID, number int) (*FileContent, error) {
+	file, err := u.GetFile(ctx, owner, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	path, objectKey, format := file.Path, file.FileName, fileFormat(file)
+	name, mimeType, etag, checksum := file.OriginalName, file.MimeType, file.UploadID.String(), file.Checksum
+	if number != 0 && number != file.CurrentVersion {
+		version, err := u.fileRepo.GetFileVersion(ctx, file.ID, number)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrVersionNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		path, objectKey, format = version.Path, version.FileName, versionFormat(version)
+		name, mimeType, etag, checksum = version.OriginalName, version.MimeType, version.UploadID.String(), version.Checksum
+	}
+
+	content, err := openStoredContent(ctx, u.config, path, objectKey, format)
+	if err != nil {
+		return nil, err
+	}
+	content.Name = name
+	content.MimeType = mimeType
+	content.ETag = etag
+	content.Checksum = checksum
+	return content, nil
+}
+
+// PruneVersions applies the version retention policy to every file that has
+// versions older than the retention period
+func (u *fileUseCase) PruneVersions(ctx context.Context) {
+	if u.config.FileVersionRetention <= 0 {
+		return
+	}
+
+	fileIDs, err := u.fileRepo.ListFileIDsWithVersionsBefore(ctx, time.Now().Add(-u.config.FileVersionRetention))
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("failed to find files with expired versions")
+		return
+	}
+	for _, fileID := range fileIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		u.pruneFileVersions(ctx, fileID)
+	}
+}
+
+// pruneFileVersions deletes the versions of a file that exceed the maximum
+// count or are older than the retention period. The current version is
+// always kept.
+func (u *fileUseCase) pruneFileVersions(ctx context.Context, fileID uuid.UUID) {
+	entry := logger.FromContext(ctx).WithField("file_id", fileID)
+
+	file, err := u.fileRepo.GetFileByID(ctx, fileID)
+	if err != nil {
+		entry.WithError(err).Warn("failed to load file for version pruning")
+		return
+	}
+	versions, err := u.fileRepo.ListFileVersions(ctx, fileID)
+	if err != nil {
+		entry.WithError(err).Warn("failed to load versions for pruning")
+		return
+	}
+
+	cutoff := time.Time{}
+	if u.config.FileVersionRetention > 0 {
+		cutoff = time.Now().Add(-u.config.FileVersionRetention)
+	}
+
+	var expired []*entity.FileVersion
+	for i, version := range versions {
+		if version.Version == file.CurrentVersion {
+			continue
+		}
+		tooMany := u.config.MaxFileVersions > 0 && i >= u.config.MaxFileVersions
+		tooOld := !cutoff.IsZero() && version.CreatedAt.Before(cutoff)
+		if tooMany || tooOld {
+			expired = append(expired, version)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(expired))
+	for _, version := range expired {
+		ids = append(ids, version.ID)
+	}
+	if err := u.fileRepo.DeleteFileVersions(ctx, ids); err != nil {
+		entry.WithError(err).Error("failed to delete expired versions")
+		return
+	}
+	for _, version := range expired {
+		removeStoredContent(ctx, u.config, version.Path, version.FileName)
+	}
+
+	entry.WithField("deleted", len(expired)).Info("pruned old file versions")
+}
@@ -0,0 +1,340 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/utils"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	minioClient "fileupload/pkg/minio"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Finalize steps, persisted on the upload while its status is "finalizing".
+// Each step is recorded only after its side effect has happened, and every
+// side effect is safe to repeat, so a finalize can be resumed from whatever
+// step was last persisted.
+const (
+	finalizeStepMoving     = "moving"     // intent recorded, file may still be in the temp dir
+	finalizeStepMoved      = "moved"      // file is in the final dir
+	finalizeStepReplicated = "replicated" // file is in MinIO (or MinIO is disabled)
+)
+
+// errFinalizeDataLost is returned when neither the temporary nor the final
+// copy of an upload can be found, so the finalize can never complete
+var errFinalizeDataLost = errors.New("upload data not found in temporary or final location")
+
+// runFinalize drives an upload through the finalize steps, starting from the
+// last persisted one. The upload only becomes "completed" in the same
+// transaction that creates its file record.
+func (u *fileUseCase) runFinalize(ctx context.Context, upload *entity.Upload) (*entity.File, error) {
+	// Bookkeeping must be persisted even if the request is cancelled, so that
+	// the recorded step always matches what has been done on disk; only the
+	// MinIO transfer is abortable
+	dbCtx := context.WithoutCancel(ctx)
+
+	if upload.Status != "finalizing" {
+		previousStatus := upload.Status
+		upload.Status = "finalizing"
+		upload.FinalPath = filepath.Join(u.config.UploadFinalDir, upload.FileName)
+		// The data key and compression are chosen once, so that a resumed
+		// move stores the content exactly as the interrupted one did
+		enc, err := newContentEncryption(u.config)
+		if err != nil {
+			return nil, err
+		}
+		upload.Encryption = enc
+		upload.Compression = contentCompression(u.config, upload.MimeType)
+		if err := u.setFinalizeStep(dbCtx, upload, finalizeStepMoving); err != nil {
+			return nil, err
+		}
+		uploadLog(ctx, upload).WithField("previous_status", previousStatus).Info("upload status changed")
+	}
+
+	for {
+		switch upload.FinalizeStep {
+		case finalizeStepMoving:
+			if err := u.moveToFinal(upload); err != nil {
+				if errors.Is(err, errFinalizeDataLost) {
+					u.markFailed(dbCtx, upload, err)
+				}
+				return nil, fmt.Errorf("failed to move file to final location: %w", err)
+			}
+			if err := u.setFinalizeStep(dbCtx, upload, finalizeStepMoved); err != nil {
+				return nil, err
+			}
+
+		case finalizeStepMoved:
+			if u.config.EnabledMinio {
+				if err := u.replicateToMinio(ctx, upload); err != nil {
+					uploadLog(ctx, upload).WithError(err).Warn("minio replication failed, finalize can be retried")
+					return nil, fmt.Errorf("failed to upload file to MinIO: %w", err)
+				}
+			}
+			if err := u.setFinalizeStep(dbCtx, upload, finalizeStepReplicated); err != nil {
+				return nil, err
+			}
+
+		case finalizeStepReplicated:
+			// Extraction is repeated in full when a finalize is resumed; its
+			// files are only committed together with the archive
+			extracted := &extraction{}
+			if upload.Extract {
+				var err error
+				extracted, err = u.extractArchive(ctx, extractSource{
+					UploadID: upload.ID,
+					Owner:    upload.Owner,
+					FolderID: upload.FolderID,
+					Path:     upload.FinalPath,
+					Format:   uploadFormat(upload),
+					Metadata: upload.Metadata,
+					Tags:     upload.Tags,
+				})
+				if errors.Is(err, ErrNotAnArchive) || errors.Is(err, ErrUnsafeArchive) {
+					u.markFailed(dbCtx, upload, err)
+					removeStoredContent(dbCtx, u.config, upload.FinalPath, upload.FileName)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("failed to extract archive: %w", err)
+				}
+			}
+
+			file, err := u.completeFinalize(dbCtx, upload, extracted.files)
+			if targetDeleted(err) {
+				// No retry can succeed, and nothing refers to the content
+				u.markFailed(dbCtx, upload, err)
+				removeStoredContent(dbCtx, u.config, upload.FinalPath, upload.FileName)
+				u.discardExtraction(dbCtx, extracted)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to complete upload: %w", err)
+			}
+			uploadLog(ctx, upload).WithField("file_id", file.ID).Info("upload completed")
+			return file, nil
+
+		default:
+			return nil, fmt.Errorf("unknown finalize step %q", upload.FinalizeStep)
+		}
+	}
+}
+
+// setFinalizeStep persists the step the finalize has reached
+func (u *fileUseCase) setFinalizeStep(ctx context.Context, upload *entity.Upload, step string) error {
+	upload.FinalizeStep = step
+	upload.UpdatedAt = time.Now()
+	err := u.fileRepo.UpdateUpload(ctx, upload,
+		repository.UploadFieldStatus, repository.UploadFieldFinalizeStep, repository.UploadFieldFinalPath,
+		repository.UploadFieldEncryption, repository.UploadFieldCompression, repository.UploadFieldChecksum)
+	if errors.Is(err, repository.ErrVersionConflict) {
+		return errors.New("upload is being finalized concurrently")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update upload record: %w", err)
+	}
+	uploadLog(ctx, upload).WithField("finalize_step", step).Debug("finalize step reached")
+	return nil
+}
+
+// moveToFinal moves the temporary file to its final path, compressing and
+// encrypting it on the way as the upload requires, and records the checksum
+// of the content on the upload. A file that is already at the final path was
+// moved by an earlier attempt and is left as is once it reads back in full.
+func (u *fileUseCase) moveToFinal(upload *entity.Upload) error {
+	if utils.IsFileExists(upload.TempPath) {
+		if upload.Encryption != nil || upload.Compression != "" {
+			return u.storeToFinal(upload)
+		}
+		checksum, err := fileChecksum(upload.TempPath)
+		if err != nil {
+			return err
+		}
+		if err := utils.MoveFile(upload.TempPath, upload.FinalPath); err != nil {
+			return err
+		}
+		upload.Checksum = checksum
+		return nil
+	}
+
+	content, err := openLocalContent(u.config, upload.FinalPath, uploadFormat(upload))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errFinalizeDataLost
+		}
+		return err
+	}
+	defer content.Reader.Close()
+	if content.Size != upload.TotalSize {
+		return fmt.Errorf("final file has %d bytes, expected %d", content.Size, upload.TotalSize)
+	}
+	checksum, err := contentChecksum(content.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to read final file: %w", err)
+	}
+	upload.Checksum = checksum
+	return nil
+}
+
+// storeToFinal compresses and encrypts the temporary file into its final
+// path, through a partial file that is renamed into place once complete, and
+// then removes the temporary file
+func (u *fileUseCase) storeToFinal(upload *entity.Upload) error {
+	src, err := os.Open(upload.TempPath)
+	if err != nil {
+		return err
+	}
+	partPath := upload.FinalPath + ".part"
+	out, err := os.Create(partPath)
+	if err != nil {
+		src.Close()
+		return err
+	}
+
+	w, err := newContentWriter(u.config, out, uploadFormat(upload))
+	if err == nil {
+		if _, err = io.Copy(w, src); err == nil {
+			err = w.Close()
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	src.Close()
+	if err == nil {
+		err = os.Rename(partPath, upload.FinalPath)
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	upload.Checksum = w.Checksum()
+	return os.Remove(upload.TempPath)
+}
+
+// uploadFormat is the format of the content of an upload at its final path
+func uploadFormat(upload *entity.Upload) contentFormat {
+	return contentFormat{Encryption: upload.Encryption, Compression: upload.Compression, Size: upload.TotalSize}
+}
+
+// replicateToMinio uploads the final file to MinIO. Re-uploading the same
+// object key simply overwrites it, so this is safe to repeat.
+func (u *fileUseCase) replicateToMinio(ctx context.Context, upload *entity.Upload) error {
+	f, err := os.Open(upload.FinalPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file for minio upload: %w", err)
+	}
+	defer f.Close()
+
+	fileStat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	metadata := minioUserMetadata(upload.Metadata, upload.Tags, map[string]string{
+		"originalName": upload.OriginalName,
+		"uploadID":     upload.ID.String(),
+	})
+	_, err = minioClient.Client.PutObject(ctx, u.config.MinioBucket,
+		upload.FileName, f, fileStat.Size(), storedObjectOptions(upload.MimeType, uploadFormat(upload), metadata))
+	return err
+}
+
+// completeFinalize commits the upload's content, as a new file or as a new
+// version of its target file, along with any files extracted from it, and
+// marks the upload completed in a single transaction. If a previous attempt
+// already committed the content it is reused rather than duplicated.
+func (u *fileUseCase) completeFinalize(ctx context.Context, upload *entity.Upload, extracted []*entity.File) (*entity.File, error) {
+	compressedSize, err := u.compressedSize(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	var file *entity.File
+	err = commitTransaction(ctx, u.fileRepo, func(repo repository.FileRepository) error {
+		existing, err := findCommittedFile(ctx, repo, upload.ID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		now := time.Now()
+		if existing != nil {
+			file = existing
+		} else {
+			content := &entity.File{
+				ID:             uuid.New(),
+				FileName:       upload.FileName,
+				OriginalName:   upload.OriginalName,
+				Owner:          upload.Owner,
+				FolderID:       upload.FolderID,
+				Size:           upload.TotalSize,
+				MimeType:       upload.MimeType,
+				Metadata:       upload.Metadata,
+				Tags:           upload.Tags,
+				Path:           upload.FinalPath,
+				Backend:        entity.BackendLocal,
+				Encryption:     upload.Encryption,
+				Compression:    upload.Compression,
+				CompressedSize: compressedSize,
+				Checksum:       upload.Checksum,
+				UploadID:       upload.ID,
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			}
+			if upload.Extract {
+				withExtractedFiles(content, extracted)
+			}
+			if file, err = commitFileContent(ctx, repo, content, upload.TargetFileID); err != nil {
+				return err
+			}
+			for _, extractedFile := range extracted {
+				if _, err := commitFileContent(ctx, repo, extractedFile, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		completed := *upload
+		completed.Status = "completed"
+		completed.FinalizeStep = ""
+		completed.UpdatedAt = now
+		completed.CompletedAt = &now
+		err = repo.UpdateUpload(ctx, &completed,
+			repository.UploadFieldStatus, repository.UploadFieldFinalizeStep, repository.UploadFieldCompletedAt)
+		if err != nil {
+			return err
+		}
+		*upload = completed
+		return nil
+	})
+	if err == nil && upload.TargetFileID != nil {
+		u.pruneFileVersions(ctx, file.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// compressedSize returns the size of the compressed content of an upload at
+// its final path, or 0 when it is not compressed
+func (u *fileUseCase) compressedSize(upload *entity.Upload) (int64, error) {
+	if upload.Compression == "" {
+		return 0, nil
+	}
+	content, err := openLocalContent(u.config, upload.FinalPath, uploadFormat(upload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open final file: %w", err)
+	}
+	content.Reader.Close()
+	return content.EncodedSize, nil
+}
@@ -0,0 +1,403 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const maxFolderNameLength = 255
+
+var (
+	ErrFolderNotFound    = errors.New("folder not found")
+	ErrFolderExists      = errors.New("a folder with this name already exists here")
+	ErrFolderNotEmpty    = errors.New("folder is not empty")
+	ErrInvalidFolderName = errors.New("invalid folder name")
+	ErrFolderCycle       = errors.New("a folder cannot be moved into itself or one of its subfolders")
+)
+
+// FolderContents is one page of a folder listing. Subfolders come first,
+// followed by files; Total counts both.
+type FolderContents struct {
+	Folder   *entity.Folder // nil for the top level
+	Folders  []*entity.Folder
+	Files    []*entity.File
+	Total    int64
+	Page     int
+	PageSize int
+}
+
+type FolderUseCase interface {
+	CreateFolder(ctx context.Context, owner string, parentID *uuid.UUID, name string) (*entity.Folder, error)
+	// CreateFolderPath creates every missing folder of a path like
+	// "reports/2026/q3" and returns the last one
+	CreateFolderPath(ctx context.Context, owner string, path string) (*entity.Folder, error)
+	GetFolder(ctx context.Context, owner string, folderID uuid.UUID) (*entity.Folder, error)
+	RenameFolder(ctx context.Context, owner string, folderID uuid.UUID, name string) (*entity.Folder, error)
+	// MoveFolder moves a folder below newParentID (nil meaning the top level)
+	MoveFolder(ctx context.Context, owner string, folderID uuid.UUID, newParentID *uuid.UUID) (*entity.Folder, error)
+	// DeleteFolder deletes an empty folder, or with recursive set, the folder
+	// together with all of its subfolders and files
+	DeleteFolder(ctx context.Context, owner string, folderID uuid.UUID, recursive bool) error
+	// ListContents lists a folder (nil meaning the top level)
+	ListContents(ctx context.Context, owner string, folderID *uuid.UUID, page, pageSize int) (*FolderContents, error)
+}
+
+type folderUseCase struct {
+	folderRepo repository.FolderRepository
+	fileRepo   repository.FileRepository
+	config     *config.Config
+}
+
+func NewFolderUseCase(folderRepo repository.FolderRepository, fileRepo repository.FileRepository, config *config.Config) FolderUseCase {
+	return &folderUseCase{
+		folderRepo: folderRepo,
+		fileRepo:   fileRepo,
+		config:     config,
+	}
+}
+
+func (u *folderUseCase) CreateFolder(ctx context.Context, owner string, parentID *uuid.UUID, name string) (*entity.Folder, error) {
+	name, err := normalizeFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+	if parentID != nil {
+		if _, err := u.GetFolder(ctx, owner, *parentID); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := u.folderRepo.GetFolderByName(ctx, owner, parentID, name); err == nil {
+		return nil, ErrFolderExists
+	}
+
+	folder := newFolder(owner, parentID, name)
+	if err := u.folderRepo.CreateFolder(ctx, folder); err != nil {
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	logger.FromContext(ctx).WithField("folder_id", folder.ID).Info("folder created")
+	return folder, nil
+}
+
+func (u *folderUseCase) CreateFolderPath(ctx context.Context, owner string, path string) (*entity.Folder, error) {
+	folder, _, err := ensureFolderPath(ctx, u.folderRepo, owner, nil, path)
+	return folder, err
+}
+
+func (u *folderUseCase) GetFolder(ctx context.Context, owner string, folderID uuid.UUID) (*entity.Folder, error) {
+	folder, err := getOwnedFolder(ctx, u.folderRepo, owner, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.fillStats(ctx, []*entity.Folder{folder}); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+func (u *folderUseCase) RenameFolder(ctx context.Context, owner string, folderID uuid.UUID, name string) (*entity.Folder, error) {
+	name, err := normalizeFolderName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := getOwnedFolder(ctx, u.folderRepo, owner, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if folder.Name == name {
+		return folder, nil
+	}
+
+	if _, err := u.folderRepo.GetFolderByName(ctx, owner, folder.ParentID, name); err == nil {
+		return nil, ErrFolderExists
+	}
+
+	folder.Name = name
+	folder.UpdatedAt = time.Now()
+	if err := u.folderRepo.UpdateFolder(ctx, folder); err != nil {
+		return nil, fmt.Errorf("failed to rename folder: %w", err)
+	}
+	return folder, nil
+}
+
+func (u *folderUseCase) MoveFolder(ctx context.Context, owner string, folderID uuid.UUID, newParentID *uuid.UUID) (*entity.Folder, error) {
+	folder, err := getOwnedFolder(ctx, u.folderRepo, owner, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if newParentID != nil {
+		if _, err := getOwnedFolder(ctx, u.folderRepo, owner, *newParentID); err != nil {
+			return nil, err
+		}
+
+		descendants, err := u.folderRepo.DescendantFolderIDs(ctx, folder.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load subfolders: %w", err)
+		}
+		for _, id := range descendants {
+			if id == *newParentID {
+				return nil, ErrFolderCycle
+			}
+		}
+	}
+
+	if _, err := u.folderRepo.GetFolderByName(ctx, owner, newParentID, folder.Name); err == nil {
+		return nil, ErrFolderExists
+	}
+
+	folder.ParentID = newParentID
+	folder.UpdatedAt = time.Now()
+	if err := u.folderRepo.UpdateFolder(ctx, folder); err != nil {
+		return nil, fmt.Errorf("failed to move folder: %w", err)
+	}
+	return folder, nil
+}
+
+func (u *folderUseCase) DeleteFolder(ctx context.Context, owner string, folderID uuid.UUID, recursive bool) error {
+	folder, err := getOwnedFolder(ctx, u.folderRepo, owner, folderID)
+	if err != nil {
+		return err
+	}
+
+	// Collect the content of every version before the rows disappear. The
+	// folders and their files stay locked meanwhile, so that no file can be
+	// committed into them and no version added between the listing and the
+	// deletion; uploads still heading for them fail when they complete.
+	var ids []uuid.UUID
+	var files []*entity.File
+	var versions []*entity.FileVersion
+	err = u.folderRepo.Transaction(ctx, func(repo repository.FolderRepository) error {
+		var err error
+		if ids, err = repo.LockFolderTree(ctx, folder.ID); err != nil {
+			return fmt.Errorf("failed to load subfolders: %w", err)
+		}
+		if len(ids) == 0 {
+			return ErrFolderNotFound
+		}
+		if files, err = repo.ListFilesInFolders(ctx, ids); err != nil {
+			return fmt.Errorf("failed to load folder files: %w", err)
+		}
+		if !recursive && (len(ids) > 1 || len(files) > 0) {
+			return ErrFolderNotEmpty
+		}
+		if versions, err = repo.ListFileVersionsInFolders(ctx, ids); err != nil {
+			return fmt.Errorf("failed to load file versions: %w", err)
+		}
+		if err := repo.DeleteFolders(ctx, ids); err != nil {
+			return fmt.Errorf("failed to delete folder: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Stored content is removed only after the rows are gone, so a failure
+	// here leaves orphaned bytes rather than records pointing at nothing
+	paths := make(map[uuid.UUID]string, len(files))
+	for _, file := range files {
+		paths[file.ID] = file.Path
+		removeStoredContent(ctx, u.config, file.Path, file.FileName)
+	}
+	for _, version := range versions {
+		if version.Path != paths[version.FileID] {
+			removeStoredContent(ctx, u.config, version.Path, version.FileName)
+		}
+	}
+
+	logger.FromContext(ctx).WithFields(logrus.Fields{
+		"folder_id":     folder.ID,
+		"folders":       len(ids),
+		"files_deleted": len(files),
+	}).Info("folder deleted")
+	return nil
+}
+
+func (u *folderUseCase) ListContents(ctx context.Context, owner string, folderID *uuid.UUID, page, pageSize int) (*FolderContents, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	contents := &FolderContents{Page: page, PageSize: pageSize}
+	if folderID != nil {
+		folder, err := u.GetFolder(ctx, owner, *folderID)
+		if err != nil {
+			return nil, err
+		}
+		contents.Folder = folder
+	}
+
+	// Folders and files are paged as one list, folders first
+	offset := (page - 1) * pageSize
+	folders, folderTotal, err := u.folderRepo.ListChildFolders(ctx, owner, folderID, pageSize, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	if err := u.fillStats(ctx, folders); err != nil {
+		return nil, err
+	}
+
+	fileOffset := offset - int(folderTotal)
+	if fileOffset < 0 {
+		fileOffset = 0
+	}
+	files, fileTotal, err := u.fileRepo.ListFiles(ctx, repository.FileFilter{
+		Owner:    owner,
+		InFolder: true,
+		FolderID: folderID,
+		Limit:    pageSize - len(folders),
+		Offset:   fileOffset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	contents.Folders = folders
+	contents.Files = files
+	contents.Total = folderTotal + fileTotal
+	return contents, nil
+}
+
+func (u *folderUseCase) fillStats(ctx context.Context, folders []*entity.Folder) error {
+	ids := make([]uuid.UUID, 0, len(folders))
+	for _, folder := range folders {
+		ids = append(ids, folder.ID)
+	}
+
+	stats, err := u.folderRepo.FolderStats(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("failed to compute folder sizes: %w", err)
+	}
+	for _, folder := range folders {
+		folder.Size = stats[folder.ID].Size
+		folder.FileCount = stats[folder.ID].FileCount
+	}
+	return nil
+}
+
+// resolveUploadFolder determines the folder an upload goes into: folderID if
+// set, otherwise the folder path (created as needed), otherwise the top level
+func resolveUploadFolder(ctx context.Context, folderRepo repository.FolderRepository, owner string, folderID *uuid.UUID, path string) (*uuid.UUID, error) {
+	if folderID != nil {
+		folder, err := getOwnedFolder(ctx, folderRepo, owner, *folderID)
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			return &folder.ID, nil
+		}
+		folder, _, err = ensureFolderPath(ctx, folderRepo, owner, &folder.ID, path)
+		if err != nil {
+			return nil, err
+		}
+		return &folder.ID, nil
+	}
+
+	if strings.Trim(path, "/") == "" {
+		return nil, nil
+	}
+	folder, _, err := ensureFolderPath(ctx, folderRepo, owner, nil, path)
+	if err != nil {
+		return nil, err
+	}
+	return &folder.ID, nil
+}
+
+// ensureFolderPath walks a slash-separated path below parentID, creating
+// missing folders along the way, and returns the last folder along with the
+// IDs of the folders it created, parents first
+func ensureFolderPath(ctx context.Context, folderRepo repository.FolderRepository, owner string, parentID *uuid.UUID, path string) (*entity.Folder, []uuid.UUID, error) {
+	var current *entity.Folder
+	var created []uuid.UUID
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		name, err := normalizeFolderName(segment)
+		if err != nil {
+			return nil, created, err
+		}
+
+		folder, err := folderRepo.GetFolderByName(ctx, owner, parentID, name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			folder = newFolder(owner, parentID, name)
+			if err := folderRepo.CreateFolder(ctx, folder); err != nil {
+				// Another request may have created it in the meantime
+				existing, lookupErr := folderRepo.GetFolderByName(ctx, owner, parentID, name)
+				if lookupErr != nil {
+					return nil, created, fmt.Errorf("failed to create folder %q: %w", name, err)
+				}
+				folder = existing
+			} else {
+				created = append(created, folder.ID)
+			}
+		} else if err != nil {
+			return nil, created, fmt.Errorf("failed to look up folder %q: %w", name, err)
+		}
+
+		current = folder
+		parentID = &folder.ID
+	}
+
+	if current == nil {
+		return nil, created, fmt.Errorf("%w: empty path", ErrInvalidFolderName)
+	}
+	return current, created, nil
+}
+
+func getOwnedFolder(ctx context.Context, folderRepo repository.FolderRepository, owner string, folderID uuid.UUID) (*entity.Folder, error) {
+	folder, err := folderRepo.GetFolderByID(ctx, folderID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if folder.Owner != owner {
+		return nil, ErrFolderNotFound
+	}
+	return folder, nil
+}
+
+func newFolder(owner string, parentID *uuid.UUID, name string) *entity.Folder {
+	now := time.Now()
+	return &entity.Folder{
+		ID:        uuid.New(),
+		Owner:     owner,
+		ParentID:  parentID,
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func normalizeFolderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "" || name == "." || name == "..":
+		return "", fmt.Errorf("%w: %q", ErrInvalidFolderName, name)
+	case len(name) > maxFolderNameLength:
+		return "", fmt.Errorf("%w: longer than %d characters", ErrInvalidFolderName, maxFolderNameLength)
+	case strings.ContainsAny(name, `/\`) || strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return "", fmt.Errorf("%w: %q contains invalid characters", ErrInvalidFolderName, name)
+	}
+	return name, nil
+}
@@ -0,0 +1,167 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/repository"
+	"fileupload/pkg/utils"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	minioClient "fileupload/pkg/minio"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	healthCheckTimeout = 3 * time.Second
+)
+
+// ComponentStatus describes the result of checking a single dependency
+type ComponentStatus struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	FreeBytes uint64 `json:"free_bytes,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// HealthReport is the aggregated readiness of the service
+type HealthReport struct {
+	Ready        bool                       `json:"ready"`
+	ShuttingDown bool                       `json:"shutting_down,omitempty"`
+	Components   map[string]ComponentStatus `json:"components"`
+}
+
+type HealthUseCase interface {
+	Readiness(ctx context.Context) *HealthReport
+	SetShuttingDown()
+	IsShuttingDown() bool
+}
+
+type healthUseCase struct {
+	fileRepo     repository.FileRepository
+	config       *config.Config
+	shuttingDown atomic.Bool
+}
+
+func NewHealthUseCase(fileRepo repository.FileRepository, config *config.Config) HealthUseCase {
+	return &healthUseCase{
+		fileRepo: fileRepo,
+		config:   config,
+	}
+}
+
+// Readiness checks every dependency concurrently. The service is ready only
+// when all of them are up and no shutdown is in progress.
+func (u *healthUseCase) Readiness(ctx context.Context) *HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) (uint64, error){
+		"database": func(ctx context.Context) (uint64, error) {
+			return 0, u.fileRepo.Ping(ctx)
+		},
+		"upload_temp_dir": func(ctx context.Context) (uint64, error) {
+			return u.checkDir(u.config.UploadTempDir)
+		},
+		"upload_final_dir": func(ctx context.Context) (uint64, error) {
+			return u.checkDir(u.config.UploadFinalDir)
+		},
+	}
+	if u.config.EnabledMinio {
+		checks["minio"] = u.checkMinio
+	}
+
+	report := &HealthReport{
+		Ready:        true,
+		ShuttingDown: u.IsShuttingDown(),
+		Components:   make(map[string]ComponentStatus, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) (uint64, error)) {
+			defer wg.Done()
+
+			start := time.Now()
+			free, err := check(ctx)
+			status := ComponentStatus{
+				Status:    StatusUp,
+				FreeBytes: free,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				status.Status = StatusDown
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Components[name] = status
+			if err != nil {
+				report.Ready = false
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	if report.ShuttingDown {
+		report.Ready = false
+	}
+
+	return report
+}
+
+// SetShuttingDown flips readiness to false so that load balancers stop
+// routing new traffic while in-flight requests drain
+func (u *healthUseCase) SetShuttingDown() {
+	u.shuttingDown.Store(true)
+}
+
+func (u *healthUseCase) IsShuttingDown() bool {
+	return u.shuttingDown.Load()
+}
+
+// checkDir verifies that dir is writable and has at least the configured
+// amount of free space, returning the free space in bytes
+func (u *healthUseCase) checkDir(dir string) (uint64, error) {
+	probe, err := os.CreateTemp(dir, ".healthcheck-*")
+	if err != nil {
+		return 0, fmt.Errorf("directory not writable: %w", err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	free, err := utils.DiskFreeSpace(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read free space: %w", err)
+	}
+
+	minFree := uint64(u.config.Settings().MinFreeDisk)
+	if free < minFree {
+		return free, fmt.Errorf("free space %d bytes is below the minimum of %d bytes", free, minFree)
+	}
+
+	return free, nil
+}
+
+func (u *healthUseCase) checkMinio(ctx context.Context) (uint64, error) {
+	if minioClient.Client == nil {
+		return 0, errors.New("minio client not initialized")
+	}
+	exists, err := minioClient.Client.BucketExists(ctx, u.config.MinioBucket)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, fmt.Errorf("bucket %q does not exist", u.config.MinioBucket)
+	}
+	return 0, nil
+}
@@ -0,0 +1,110 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrIdempotencyKeyInUse is returned while the original request for a key
+	// is still being processed
+	ErrIdempotencyKeyInUse = errors.New("a request with this idempotency key is still being processed")
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a
+	// request that differs from the one it was first used for
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+)
+
+// idempotencyLease is how long a reservation outlives the last sign of life
+// of its request. The middleware renews it every third of that while the
+// request runs, so only a reservation left by a crashed process expires.
+const idempotencyLease = time.Minute
+
+// IdempotencyRenewInterval is how often a running request renews its lease
+const IdempotencyRenewInterval = idempotencyLease / 3
+
+type IdempotencyUseCase interface {
+	// Begin reserves the record's key. If the same request was already
+	// completed with this key, the stored record is returned for replay.
+	Begin(ctx context.Context, record *entity.IdempotencyRecord) (*entity.IdempotencyRecord, error)
+	// Renew extends the lease of a key reserved by Begin while its request
+	// runs. It returns false once the key is no longer held by the request.
+	Renew(ctx context.Context, record *entity.IdempotencyRecord) (bool, error)
+	// Complete stores the response of a request that reserved its key
+	Complete(ctx context.Context, record *entity.IdempotencyRecord) error
+	// Release forgets a key reserved by Begin so the request can be
+	// retried. A key taken over by another request is left alone.
+	Release(ctx context.Context, record *entity.IdempotencyRecord) error
+	// PurgeExpired deletes records older than the idempotency window
+	PurgeExpired(ctx context.Context)
+}
+
+type idempotencyUseCase struct {
+	repo   repository.IdempotencyRepository
+	config *config.Config
+}
+
+func NewIdempotencyUseCase(repo repository.IdempotencyRepository, config *config.Config) IdempotencyUseCase {
+	return &idempotencyUseCase{
+		repo:   repo,
+		config: config,
+	}
+}
+
+func (u *idempotencyUseCase) Begin(ctx context.Context, record *entity.IdempotencyRecord) (*entity.IdempotencyRecord, error) {
+	now := time.Now()
+	record.Completed = false
+	record.CreatedAt = now
+	record.ExpiresAt = now.Add(u.config.IdempotencyTTL)
+	record.LeaseExpiresAt = now.Add(idempotencyLease)
+	record.Token = uuid.NewString()
+
+	existing, err := u.repo.Reserve(ctx, record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if existing == nil {
+		return nil, nil
+	}
+
+	if existing.Method != record.Method || existing.Path != record.Path || existing.RequestHash != record.RequestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !existing.Completed {
+		return nil, ErrIdempotencyKeyInUse
+	}
+
+	logger.FromContext(ctx).WithField("idempotency_key", record.Key).Info("replaying idempotent response")
+	return existing, nil
+}
+
+func (u *idempotencyUseCase) Renew(ctx context.Context, record *entity.IdempotencyRecord) (bool, error) {
+	return u.repo.Renew(ctx, record.Owner, record.Key, record.Token, time.Now().Add(idempotencyLease))
+}
+
+func (u *idempotencyUseCase) Complete(ctx context.Context, record *entity.IdempotencyRecord) error {
+	record.Completed = true
+	return u.repo.Complete(ctx, record)
+}
+
+func (u *idempotencyUseCase) Release(ctx context.Context, record *entity.IdempotencyRecord) error {
+	return u.repo.Delete(ctx, record.Owner, record.Key, record.Token)
+}
+
+func (u *idempotencyUseCase) PurgeExpired(ctx context.Context) {
+	deleted, err := u.repo.DeleteExpired(ctx, time.Now())
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("failed to purge expired idempotency keys")
+		return
+	}
+	if deleted > 0 {
+		logger.FromContext(ctx).WithField("deleted", deleted).Info("purged expired idempotency keys")
+	}
+}
@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrShuttingDown is returned for operations started after shutdown began
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// inflightTracker keeps count of running upload operations so that shutdown
+// can wait for them, and lets shutdown abort them once its deadline expires
+type inflightTracker struct {
+	mu       sync.Mutex
+	draining bool
+	wg       sync.WaitGroup
+
+	abortCtx context.Context
+	abort    context.CancelFunc
+}
+
+func newInflightTracker() *inflightTracker {
+	abortCtx, abort := context.WithCancel(context.Background())
+	return &inflightTracker{
+		abortCtx: abortCtx,
+		abort:    abort,
+	}
+}
+
+// begin registers a new operation. The returned context is cancelled when
+// either ctx is done or shutdown aborts in-flight work; done must be called
+// when the operation finishes.
+func (t *inflightTracker) begin(ctx context.Context) (context.Context, func(), error) {
+	t.mu.Lock()
+	if t.draining {
+		t.mu.Unlock()
+		return nil, nil, ErrShuttingDown
+	}
+	t.wg.Add(1)
+	t.mu.Unlock()
+
+	opCtx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(t.abortCtx, cancel)
+
+	return opCtx, func() {
+		stop()
+		cancel()
+		t.wg.Done()
+	}, nil
+}
+
+// stopAccepting makes every subsequent begin fail with ErrShuttingDown
+func (t *inflightTracker) stopAccepting() {
+	t.mu.Lock()
+	t.draining = true
+	t.mu.Unlock()
+}
+
+// wait blocks until all operations have finished or ctx is done
+func (t *inflightTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// contextReader stops reading as soon as its context is cancelled, so that a
+// copy of a long chunk can be interrupted between reads
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
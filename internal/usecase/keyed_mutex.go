@@ -0,0 +1,51 @@
+package usecase
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// keyedMutex serializes work per key (e.g. per upload) without holding a
+// lock for keys nobody is using. Entries are reference counted and removed
+// once the last holder unlocks.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[uuid.UUID]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{
+		locks: make(map[uuid.UUID]*keyedMutexEntry),
+	}
+}
+
+// Lock acquires the lock for key and returns the function releasing it
+func (k *keyedMutex) Lock(key uuid.UUID) func() {
+	k.mu.Lock()
+	entry, ok := k.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{}
+		k.locks[key] = entry
+	}
+	entry.refs++
+	k.mu.Unlock()
+
+	entry.mu.Lock()
+
+	return func() {
+		entry.mu.Unlock()
+
+		k.mu.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
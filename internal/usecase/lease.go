@@ -0,0 +1,78 @@
+package usecase
+
+import (
+	"context"
+	"fileupload/pkg/logger"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// jobLease is how long background work stays claimed by an instance
+	// that stopped renewing it. Renewals happen every third of that.
+	jobLease = time.Minute
+
+	// RecoveryInterval is how often interrupted background work is looked
+	// for. Work abandoned by a crashed instance is recovered at most this
+	// long after its lease expired.
+	RecoveryInterval = jobLease
+)
+
+// instanceID identifies this process in the leases it holds, so that the
+// work of a live instance is never mistaken for abandoned work
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + "/" + uuid.NewString()
+}
+
+// renewFunc extends a lease until the given time. It reports false once the
+// lease is no longer held.
+type renewFunc func(ctx context.Context, until time.Time) (bool, error)
+
+// holdLease renews a lease until the returned stop function is called. The
+// returned context is cancelled if the lease is lost, since another instance
+// may then be doing the same work. A failed renewal is only logged: the lease
+// is still held until it expires.
+func holdLease(ctx context.Context, renew renewFunc) (context.Context, func()) {
+	leaseCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(jobLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			held, err := renew(context.WithoutCancel(ctx), time.Now().Add(jobLease))
+			if err != nil {
+				logger.FromContext(ctx).WithError(err).Warn("failed to renew lease")
+				continue
+			}
+			if !held {
+				logger.FromContext(ctx).Error("lease lost to another instance, aborting")
+				cancel()
+				return
+			}
+		}
+	}()
+	return leaseCtx, func() {
+		close(done)
+		wg.Wait()
+		cancel()
+	}
+}
@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"context"
+	"fileupload/config"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fileupload/pkg/ratelimit"
+	"time"
+)
+
+type RateLimitUseCase interface {
+	// Allow takes a token from the bucket of a client. The result reports
+	// whether the request may proceed and the state of the bucket; its Limit
+	// is zero when rate limiting is disabled.
+	Allow(ctx context.Context, key string) (ratelimit.Result, error)
+	// PurgeIdle forgets clients whose bucket has filled up again
+	PurgeIdle(ctx context.Context)
+}
+
+type rateLimitUseCase struct {
+	repo   repository.RateLimitRepository
+	config *config.Config
+}
+
+func NewRateLimitUseCase(repo repository.RateLimitRepository, config *config.Config) RateLimitUseCase {
+	return &rateLimitUseCase{
+		repo:   repo,
+		config: config,
+	}
+}
+
+func (u *rateLimitUseCase) Allow(ctx context.Context, key string) (ratelimit.Result, error) {
+	limit := u.limit()
+	if !limit.Enabled() {
+		return ratelimit.Result{Allowed: true}, nil
+	}
+	return u.repo.Take(ctx, key, limit, time.Now())
+}
+
+func (u *rateLimitUseCase) PurgeIdle(ctx context.Context) {
+	limit := u.limit()
+	if !limit.Enabled() {
+		return
+	}
+	deleted, err := u.repo.DeleteIdle(ctx, time.Now().Add(-limit.RefillTime()))
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Error("failed to purge idle rate limit buckets")
+		return
+	}
+	if deleted > 0 {
+		logger.FromContext(ctx).WithField("deleted", deleted).Debug("purged idle rate limit buckets")
+	}
+}
+
+// limit is the configured bucket: RateLimitPerMinute requests per minute
+// with bursts of up to RateLimitBurst requests
+func (u *rateLimitUseCase) limit() ratelimit.Limit {
+	settings := u.config.Settings()
+	return ratelimit.Limit{
+		Rate:  float64(settings.RateLimitPerMinute) / 60,
+		Burst: settings.RateLimitBurst,
+	}
+}
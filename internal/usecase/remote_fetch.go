@@ -0,0 +1,279 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fileupload/pkg/safehttp"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidSourceURL is returned for remote URLs that cannot be fetched
+var ErrInvalidSourceURL = errors.New("invalid source URL")
+
+const (
+	// importProgressInterval is how many bytes are fetched between two
+	// progress updates of an import
+	importProgressInterval = 8 * 1024 * 1024
+
+	// defaultImportName names imported files when neither the request nor
+	// the URL provides a name
+	defaultImportName = "download"
+)
+
+// newFetchClient builds the client used for remote URLs from the current
+// allowlists. An invalid allowlist is logged and ignored, which only makes
+// the client stricter.
+func (u *fileUseCase) newFetchClient() *http.Client {
+	settings := u.config.Settings()
+	opts := safehttp.Options{
+		Timeout:      u.config.URLFetchTimeout,
+		MaxRedirects: u.config.URLFetchMaxRedirects,
+		AllowedCIDRs: settings.URLFetchAllowCIDRs,
+		AllowedHosts: settings.URLFetchAllowHosts,
+	}
+	client, err := safehttp.NewClient(opts)
+	if err != nil {
+		logger.Log.WithError(err).Error("invalid URL fetch allowlist, ignoring it")
+		opts.AllowedCIDRs = nil
+		client, _ = safehttp.NewClient(opts)
+	}
+	return client
+}
+
+func (u *fileUseCase) ImportFromURL(ctx context.Context, owner string, rawURL string, fileName string, opts UploadOptions) (*entity.Upload, error) {
+	ctx, done, err := u.inflight.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	source, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSourceURL, err)
+	}
+	if err := safehttp.CheckURL(source); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSourceURL, err)
+	}
+
+	opts, err = opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	folderID, err := u.resolveUploadTarget(ctx, owner, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	nameFromURL := fileName == ""
+	if nameFromURL {
+		fileName = path.Base(source.Path)
+		if fileName == "." || fileName == "/" {
+			fileName = defaultImportName
+		}
+	}
+
+	// The fetch outlives the request; it is tracked like any other upload
+	// operation so that shutdown waits for it
+	jobCtx, jobDone, err := u.inflight.begin(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	storedName := uuid.New().String() + filepath.Ext(fileName)
+	tempPath := filepath.Join(u.config.UploadTempDir, storedName)
+	if err := os.WriteFile(tempPath, nil, 0644); err != nil {
+		jobDone()
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	// The import is leased from the start, so that recovery on another
+	// instance never mistakes it for an abandoned one
+	now := time.Now()
+	leaseExpiresAt := now.Add(jobLease)
+	upload := &entity.Upload{
+		ID:             uuid.New(),
+		FileName:       storedName,
+		OriginalName:   fileName,
+		Owner:          owner,
+		FolderID:       folderID,
+		TargetFileID:   opts.TargetFileID,
+		Extract:        opts.Extract,
+		SourceURL:      source.Redacted(),
+		Metadata:       opts.Metadata,
+		Tags:           opts.Tags,
+		Status:         "pending",
+		TempPath:       tempPath,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		LeaseHolder:    instanceID,
+		LeaseExpiresAt: &leaseExpiresAt,
+	}
+	if err := u.createUpload(ctx, upload); err != nil {
+		jobDone()
+		os.Remove(tempPath)
+		if errors.Is(err, ErrTooManyUploads) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to create upload record: %w", err)
+	}
+
+	uploadLog(ctx, upload).WithField("source_url", upload.SourceURL).Info("url import queued")
+
+	go func() {
+		defer jobDone()
+		u.runImport(jobCtx, upload, source, nameFromURL)
+	}()
+
+	return upload, nil
+}
+
+// runImport fetches the remote content into the upload's temporary file and
+// finalizes it like a chunked upload
+func (u *fileUseCase) runImport(ctx context.Context, upload *entity.Upload, source *url.URL, nameFromURL bool) {
+	unlock := u.uploadLocks.Lock(upload.ID)
+	defer unlock()
+	ctx, release := u.holdUploadLease(ctx, upload)
+	defer release()
+
+	if err := u.fetchRemote(ctx, upload, source, nameFromURL); err != nil {
+		os.Remove(upload.TempPath)
+		u.markFailed(context.WithoutCancel(ctx), upload, err)
+		return
+	}
+
+	if _, err := u.runFinalize(ctx, upload); err != nil {
+		uploadLog(ctx, upload).WithError(err).Error("failed to finalize url import")
+	}
+}
+
+func (u *fileUseCase) fetchRemote(ctx context.Context, upload *entity.Upload, source *url.URL, nameFromURL bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.newFetchClient().Do(req)
+	if err != nil {
+		// The error text repeats the URL; only the redacted form is kept
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to fetch %s: %w", upload.SourceURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote server answered %s", resp.Status)
+	}
+	if nameFromURL {
+		if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+			upload.OriginalName = path.Base(params["filename"])
+		}
+	}
+	upload.MimeType = mime.TypeByExtension(filepath.Ext(upload.OriginalName))
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		upload.MimeType = mediaType
+	}
+	if upload.MimeType == "" {
+		upload.MimeType = "application/octet-stream"
+	}
+	limit := u.maxUploadSize(uploadModeImport, upload.MimeType)
+	if resp.ContentLength > limit {
+		return fileTooLarge(limit)
+	}
+	if resp.ContentLength > 0 {
+		upload.TotalSize = resp.ContentLength
+	}
+	upload.Status = "uploading"
+	upload.UpdatedAt = time.Now()
+	err = u.fileRepo.UpdateUpload(ctx, upload, repository.UploadFieldStatus, repository.UploadFieldTotalSize,
+		repository.UploadFieldOriginalName, repository.UploadFieldMimeType)
+	if err != nil {
+		return fmt.Errorf("failed to update upload record: %w", err)
+	}
+
+	file, err := os.OpenFile(upload.TempPath, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open temporary file: %w", err)
+	}
+	defer file.Close()
+
+	// One byte past the limit is read so that oversized content is detected
+	// even when the server sent no Content-Length
+	body := io.LimitReader(resp.Body, limit+1)
+	var written int64
+	for {
+		n, err := io.CopyN(file, body, importProgressInterval)
+		written += n
+		if written > limit {
+			return fileTooLarge(limit)
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to download content: %w", err)
+		}
+		if err := u.recordChunkProgress(ctx, upload, written); err != nil {
+			return fmt.Errorf("failed to update upload record: %w", err)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return fmt.Errorf("remote content truncated: expected %d bytes, got %d", resp.ContentLength, written)
+	}
+
+	upload.TotalSize = written
+	upload.UpdatedAt = time.Now()
+	if err := u.fileRepo.UpdateUpload(ctx, upload, repository.UploadFieldTotalSize); err != nil {
+		return fmt.Errorf("failed to update upload record: %w", err)
+	}
+
+	uploadLog(ctx, upload).WithFields(logrus.Fields{
+		"source_url": upload.SourceURL,
+	}).Info("url import downloaded")
+	return nil
+}
+
+// RecoverImports fails URL imports abandoned by a crashed instance. They
+// cannot be resumed because the stored URL has its credentials redacted.
+// Imports still leased by a live instance, this one included, are left alone.
+func (u *fileUseCase) RecoverImports(ctx context.Context) error {
+	for _, status := range []string{"pending", "uploading"} {
+		uploads, err := u.fileRepo.ListUploadsByStatus(ctx, status)
+		if err != nil {
+			return fmt.Errorf("failed to list interrupted imports: %w", err)
+		}
+		for _, upload := range uploads {
+			if upload.SourceURL == "" {
+				continue
+			}
+			_, release, claimed, err := u.claimUpload(ctx, upload)
+			if err != nil {
+				uploadLog(ctx, upload).WithError(err).Error("failed to claim interrupted import")
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			os.Remove(upload.TempPath)
+			u.markFailed(ctx, upload, errors.New("import interrupted by a restart"))
+			release()
+		}
+	}
+	return nil
+}
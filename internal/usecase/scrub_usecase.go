@@ -0,0 +1,378 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fileupload/pkg/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	minioClient "fileupload/pkg/minio"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/sirupsen/logrus"
+)
+
+// scrubBatchSize is the number of records loaded at once by Scrub
+const scrubBatchSize = 200
+
+// quarantinePrefix is the prefix orphaned MinIO objects are moved under
+const quarantinePrefix = "quarantine/"
+
+// Problems found by Scrub
+const (
+	ScrubMissing = "missing" // a record's content is not on its backend
+	ScrubCorrupt = "corrupt" // a copy of a record's content has the wrong size or checksum
+	ScrubOrphan  = "orphan"  // stored content that no record points at
+)
+
+// ScrubOptions control a scrub
+type ScrubOptions struct {
+	Repair     bool // restore missing or corrupt copies from a valid copy on the other backend
+	Quarantine bool // move orphaned content out of the way
+}
+
+// ScrubIssue is one problem found by Scrub. Orphans have no record.
+type ScrubIssue struct {
+	Problem  string
+	Backend  string
+	Location string // path on local disk or MinIO object key
+	Kind     string
+	ID       uuid.UUID
+	Detail   string
+	Fixed    bool // repaired or quarantined
+}
+
+// ScrubReport lists the issues found by Scrub among the stored content of
+// Checked records
+type ScrubReport struct {
+	Checked int
+	Issues  []ScrubIssue
+}
+
+type ScrubUseCase interface {
+	// Scrub checks that the content of every file and file version is on its
+	// backend with the recorded size and checksum, as is any copy on the
+	// other backend, and looks for stored content no record points at
+	Scrub(ctx context.Context, opts ScrubOptions) (*ScrubReport, error)
+	// ScheduledScrub runs Scrub with the configured options and logs what it
+	// finds
+	ScheduledScrub(ctx context.Context)
+}
+
+type scrubUseCase struct {
+	repo   repository.StorageRepository
+	config *config.Config
+}
+
+func NewScrubUseCase(repo repository.StorageRepository, config *config.Config) ScrubUseCase {
+	return &scrubUseCase{
+		repo:   repo,
+		config: config,
+	}
+}
+
+// orphanCandidate is stored content that may have no record
+type orphanCandidate struct {
+	backend  string
+	location string
+}
+
+func (u *scrubUseCase) Scrub(ctx context.Context, opts ScrubOptions) (*ScrubReport, error) {
+	// Stored content is listed before the records, so that content recorded
+	// while scrubbing is not mistaken for an orphan
+	candidates, err := u.orphanCandidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ScrubReport{}
+	referenced := make(map[orphanCandidate]bool)
+	checked := make(map[string]bool)
+	for _, kind := range repository.StorageKinds {
+		after := uuid.Nil
+		for {
+			batch, err := u.repo.ListStoredContent(ctx, kind, "", after, scrubBatchSize)
+			if err != nil {
+				return report, fmt.Errorf("failed to list %s records: %w", kind, err)
+			}
+			for _, content := range batch {
+				if ctx.Err() != nil {
+					return report, ctx.Err()
+				}
+				after = content.ID
+				referenced[orphanCandidate{entity.BackendLocal, filepath.Clean(content.Path)}] = true
+				referenced[orphanCandidate{entity.BackendMinio, content.ObjectKey}] = true
+
+				// The current version of a file shares its content
+				if checked[content.ObjectKey] {
+					continue
+				}
+				checked[content.ObjectKey] = true
+				report.Checked++
+				report.Issues = append(report.Issues, u.checkContent(ctx, content, opts)...)
+			}
+			if len(batch) < scrubBatchSize {
+				break
+			}
+		}
+	}
+
+	finalizing, err := u.repo.ListFinalizingFileNames(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to list finalizing uploads: %w", err)
+	}
+	for _, name := range finalizing {
+		referenced[orphanCandidate{entity.BackendLocal, filepath.Join(u.config.UploadFinalDir, name)}] = true
+		referenced[orphanCandidate{entity.BackendMinio, name}] = true
+	}
+
+	for _, candidate := range candidates {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		if !referenced[candidate] {
+			if issue, ok := u.handleOrphan(ctx, candidate, opts); ok {
+				report.Issues = append(report.Issues, issue)
+			}
+		}
+	}
+	return report, nil
+}
+
+func (u *scrubUseCase) ScheduledScrub(ctx context.Context) {
+	entry := logger.FromContext(ctx)
+	report, err := u.Scrub(ctx, ScrubOptions{Repair: u.config.ScrubRepair, Quarantine: u.config.ScrubQuarantine})
+	if err != nil {
+		entry.WithError(err).Error("integrity scrub failed")
+	}
+	if report == nil {
+		return
+	}
+	for _, issue := range report.Issues {
+		issueLog(entry, issue).Warn("integrity issue found")
+	}
+	entry.WithFields(logrus.Fields{
+		"checked": report.Checked,
+		"issues":  len(report.Issues),
+	}).Info("integrity scrub finished")
+}
+
+func issueLog(entry *logrus.Entry, issue ScrubIssue) *logrus.Entry {
+	fields := logrus.Fields{
+		"problem":  issue.Problem,
+		"backend":  issue.Backend,
+		"location": issue.Location,
+		"fixed":    issue.Fixed,
+	}
+	if issue.Kind != "" {
+		fields["kind"] = issue.Kind
+		fields["id"] = issue.ID
+	}
+	if issue.Detail != "" {
+		fields["detail"] = issue.Detail
+	}
+	return entry.WithFields(fields)
+}
+
+// checkContent checks the copies of a record's content on both backends and
+// repairs the bad ones from a good one when requested. A missing copy on the
+// backend the record does not point at is not an issue: not all content is
+// replicated.
+func (u *scrubUseCase) checkContent(ctx context.Context, content *repository.StoredContent, opts ScrubOptions) []ScrubIssue {
+	backends := []string{content.Backend}
+	if u.config.EnabledMinio {
+		backends = append(backends, otherBackend(content.Backend))
+	}
+
+	var issues []ScrubIssue
+	var good string
+	for _, backend := range backends {
+		err := u.checkCopy(ctx, backend, content)
+		switch {
+		case err == nil:
+			if good == "" {
+				good = backend
+			}
+			continue
+		case errors.Is(err, ErrContentNotFound):
+			if backend != content.Backend {
+				continue
+			}
+			issues = append(issues, u.contentIssue(ScrubMissing, backend, content, ""))
+		default:
+			issues = append(issues, u.contentIssue(ScrubCorrupt, backend, content, err.Error()))
+		}
+	}
+	if len(issues) == 0 || ctx.Err() != nil {
+		return nil
+	}
+
+	// A record deleted while it was checked takes its content with it
+	if exists, err := u.repo.StoredContentExists(ctx, content.Kind, content.ID); err == nil && !exists {
+		return nil
+	}
+
+	if opts.Repair && good != "" {
+		for i := range issues {
+			issues[i].Fixed = u.repair(ctx, content, good, issues[i].Backend)
+		}
+	}
+	return issues
+}
+
+// checkCopy checks the size and checksum of the copy of content on a backend
+func (u *scrubUseCase) checkCopy(ctx context.Context, backend string, content *repository.StoredContent) error {
+	if backend == entity.BackendMinio && !u.config.EnabledMinio {
+		return errors.New("MinIO is not enabled")
+	}
+	size, err := statBackendContent(ctx, u.config, backend, content)
+	if err != nil {
+		return err
+	}
+	if expected := storedContentSize(content); size != expected {
+		return fmt.Errorf("stored size is %d bytes, expected %d", size, expected)
+	}
+	return verifyBackendContent(ctx, u.config, backend, content, content.Checksum)
+}
+
+// repair replaces the copy of content on a backend with the good copy, and
+// reports whether the result checks out
+func (u *scrubUseCase) repair(ctx context.Context, content *repository.StoredContent, from, to string) bool {
+	entry := logger.FromContext(ctx).WithFields(logrus.Fields{
+		"kind": content.Kind,
+		"id":   content.ID,
+		"from": from,
+		"to":   to,
+	})
+	if _, err := copyBackendContent(ctx, u.config, content, from, to); err != nil {
+		entry.WithError(err).Error("failed to repair stored content")
+		return false
+	}
+	if err := u.checkCopy(ctx, to, content); err != nil {
+		entry.WithError(err).Error("repaired stored content does not check out")
+		return false
+	}
+	entry.Info("stored content repaired")
+	return true
+}
+
+func (u *scrubUseCase) contentIssue(problem, backend string, content *repository.StoredContent, detail string) ScrubIssue {
+	location := content.Path
+	if backend == entity.BackendMinio {
+		location = content.ObjectKey
+	}
+	return ScrubIssue{
+		Problem:  problem,
+		Backend:  backend,
+		Location: location,
+		Kind:     content.Kind,
+		ID:       content.ID,
+		Detail:   detail,
+	}
+}
+
+// orphanCandidates lists the stored content old enough to be an orphan if no
+// record points at it. Partial files and the temporary files of direct
+// uploads, which start with a dot, are never candidates.
+func (u *scrubUseCase) orphanCandidates(ctx context.Context) ([]orphanCandidate, error) {
+	cutoff := time.Now().Add(-u.config.ScrubOrphanMinAge)
+
+	var candidates []orphanCandidate
+	entries, err := os.ReadDir(u.config.UploadFinalDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list stored files: %w", err)
+	}
+	for _, dirEntry := range entries {
+		name := dirEntry.Name()
+		if !dirEntry.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".part") {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		candidates = append(candidates, orphanCandidate{entity.BackendLocal, filepath.Join(u.config.UploadFinalDir, name)})
+	}
+
+	if !u.config.EnabledMinio {
+		return candidates, nil
+	}
+	for object := range minioClient.Client.ListObjects(ctx, u.config.MinioBucket, minio.ListObjectsOptions{Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list minio objects: %w", object.Err)
+		}
+		// Archives have no file record; they are purged once they expire
+		if strings.HasPrefix(object.Key, quarantinePrefix) || strings.HasPrefix(object.Key, archiveObjectPrefix) ||
+			object.LastModified.After(cutoff) {
+			continue
+		}
+		candidates = append(candidates, orphanCandidate{entity.BackendMinio, object.Key})
+	}
+	return candidates, nil
+}
+
+// handleOrphan reports orphaned content, quarantining it when requested. It
+// reports nothing when the content has disappeared since it was listed.
+func (u *scrubUseCase) handleOrphan(ctx context.Context, orphan orphanCandidate, opts ScrubOptions) (ScrubIssue, bool) {
+	issue := ScrubIssue{Problem: ScrubOrphan, Backend: orphan.backend, Location: orphan.location}
+
+	var err error
+	if orphan.backend == entity.BackendMinio {
+		_, err = minioClient.Client.StatObject(ctx, u.config.MinioBucket, orphan.location, minio.StatObjectOptions{})
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return issue, false
+		}
+	} else if _, err = os.Stat(orphan.location); os.IsNotExist(err) {
+		return issue, false
+	}
+	if err != nil {
+		issue.Detail = err.Error()
+		return issue, true
+	}
+
+	if opts.Quarantine {
+		if err := u.quarantine(ctx, orphan); err != nil {
+			logger.FromContext(ctx).WithError(err).WithField("location", orphan.location).Error("failed to quarantine orphaned content")
+			issue.Detail = err.Error()
+		} else {
+			issue.Fixed = true
+		}
+	}
+	return issue, true
+}
+
+// quarantine moves orphaned content to the quarantine directory or prefix,
+// where it is kept for inspection rather than deleted
+func (u *scrubUseCase) quarantine(ctx context.Context, orphan orphanCandidate) error {
+	if orphan.backend == entity.BackendMinio {
+		_, err := minioClient.Client.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: u.config.MinioBucket, Object: quarantinePrefix + orphan.location},
+			minio.CopySrcOptions{Bucket: u.config.MinioBucket, Object: orphan.location})
+		if err != nil {
+			return err
+		}
+		return minioClient.Client.RemoveObject(ctx, u.config.MinioBucket, orphan.location, minio.RemoveObjectOptions{})
+	}
+
+	if err := os.MkdirAll(u.config.QuarantineDir, os.ModePerm); err != nil {
+		return err
+	}
+	return utils.MoveFile(orphan.location, filepath.Join(u.config.QuarantineDir, filepath.Base(orphan.location)))
+}
+
+// otherBackend returns the backend that is not backend
+func otherBackend(backend string) string {
+	if backend == entity.BackendMinio {
+		return entity.BackendLocal
+	}
+	return entity.BackendMinio
+}
@@ -0,0 +1,292 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type ShareUseCase interface {
+	// CreateShareLink creates a link to the current content of a file. The
+	// returned token is not stored and cannot be retrieved again.
+	CreateShareLink(ctx context.Context, owner string, fileID uuid.UUID, opts ShareOptions) (*entity.ShareLink, string, error)
+	ListShareLinks(ctx context.Context, owner string, fileID uuid.UUID) ([]*entity.ShareLink, error)
+	RevokeShareLink(ctx context.Context, owner string, linkID uuid.UUID) error
+	ListShareAccesses(ctx context.Context, owner string, linkID uuid.UUID, page, pageSize int) ([]*entity.ShareAccess, int64, error)
+
+	// OpenSharedFile checks a share link and counts a download against it,
+	// unless the client continues a download (see ShareClient). Every
+	// attempt, successful or not, is recorded in the link's access log.
+	OpenSharedFile(ctx context.Context, token, password string, client ShareClient) (*FileContent, error)
+}
+
+var (
+	// ErrShareNotFound is returned for share links that do not exist or
+	// belong to another owner
+	ErrShareNotFound = errors.New("share link not found")
+	// ErrShareUnavailable is returned for links that are revoked, expired or
+	// have reached their download limit
+	ErrShareUnavailable = errors.New("share link is no longer available")
+	// ErrSharePasswordRequired is returned when a link's password is missing
+	// or wrong
+	ErrSharePasswordRequired = errors.New("share link password required or incorrect")
+	// ErrInvalidShareOptions wraps every share link validation error
+	ErrInvalidShareOptions = errors.New("invalid share link options")
+)
+
+// Share access outcomes recorded in the access log
+const (
+	ShareOutcomeServed        = "served"
+	ShareOutcomeContinued     = "continued"
+	ShareOutcomeRevoked       = "revoked"
+	ShareOutcomeExpired       = "expired"
+	ShareOutcomeLimitReached  = "limit_reached"
+	ShareOutcomeWrongPassword = "wrong_password"
+	ShareOutcomeError         = "error"
+)
+
+const (
+	// shareTokenBytes is the amount of randomness in a share token
+	shareTokenBytes = 32
+	// maxSharePasswordLength is bcrypt's input limit
+	maxSharePasswordLength = 72
+)
+
+// ShareOptions restricts a share link. Zero values mean no restriction.
+type ShareOptions struct {
+	Password     string
+	ExpiresAt    *time.Time
+	MaxDownloads int
+}
+
+// ShareClient describes who is using a share link, for the access log
+type ShareClient struct {
+	RemoteAddr string
+	UserAgent  string
+	RequestID  string
+	// Continuation is set for range requests that start past the first
+	// byte, as clients send to resume a download or seek in it. They are
+	// not counted as downloads of their own, so that a download cut short
+	// can be finished without using up the link.
+	Continuation bool
+}
+
+type shareUseCase struct {
+	shareRepo   repository.ShareRepository
+	fileUseCase FileUseCase
+}
+
+func NewShareUseCase(shareRepo repository.ShareRepository, fileUseCase FileUseCase) ShareUseCase {
+	return &shareUseCase{
+		shareRepo:   shareRepo,
+		fileUseCase: fileUseCase,
+	}
+}
+
+func (u *shareUseCase) CreateShareLink(ctx context.Context, owner string, fileID uuid.UUID, opts ShareOptions) (*entity.ShareLink, string, error) {
+	if _, err := u.fileUseCase.GetFile(ctx, owner, fileID); err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(now) {
+		return nil, "", fmt.Errorf("%w: expiry must be in the future", ErrInvalidShareOptions)
+	}
+	if opts.MaxDownloads < 0 {
+		return nil, "", fmt.Errorf("%w: max downloads must not be negative", ErrInvalidShareOptions)
+	}
+	if len(opts.Password) > maxSharePasswordLength {
+		return nil, "", fmt.Errorf("%w: password longer than %d bytes", ErrInvalidShareOptions, maxSharePasswordLength)
+	}
+
+	raw := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	link := &entity.ShareLink{
+		ID:           uuid.New(),
+		FileID:       fileID,
+		Owner:        owner,
+		TokenHash:    hashShareToken(token),
+		ExpiresAt:    opts.ExpiresAt,
+		MaxDownloads: opts.MaxDownloads,
+		CreatedAt:    now,
+	}
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to hash share password: %w", err)
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	if err := u.shareRepo.CreateShareLink(ctx, link); err != nil {
+		return nil, "", fmt.Errorf("failed to create share link: %w", err)
+	}
+
+	logger.FromContext(ctx).WithFields(logrus.Fields{
+		"share_id": link.ID,
+		"file_id":  fileID,
+	}).Info("share link created")
+
+	return link, token, nil
+}
+
+func (u *shareUseCase) ListShareLinks(ctx context.Context, owner string, fileID uuid.UUID) ([]*entity.ShareLink, error) {
+	if _, err := u.fileUseCase.GetFile(ctx, owner, fileID); err != nil {
+		return nil, err
+	}
+	return u.shareRepo.ListShareLinksByFile(ctx, fileID)
+}
+
+func (u *shareUseCase) RevokeShareLink(ctx context.Context, owner string, linkID uuid.UUID) error {
+	link, err := u.getOwnedLink(ctx, owner, linkID)
+	if err != nil {
+		return err
+	}
+	if link.RevokedAt != nil {
+		return nil
+	}
+
+	if err := u.shareRepo.RevokeShareLink(ctx, link.ID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+
+	logger.FromContext(ctx).WithField("share_id", link.ID).Info("share link revoked")
+	return nil
+}
+
+func (u *shareUseCase) ListShareAccesses(ctx context.Context, owner string, linkID uuid.UUID, page, pageSize int) ([]*entity.ShareAccess, int64, error) {
+	if _, err := u.getOwnedLink(ctx, owner, linkID); err != nil {
+		return nil, 0, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return u.shareRepo.ListShareAccesses(ctx, linkID, pageSize, (page-1)*pageSize)
+}
+
+func (u *shareUseCase) OpenSharedFile(ctx context.Context, token, password string, client ShareClient) (*FileContent, error) {
+	link, err := u.shareRepo.GetShareLinkByTokenHash(ctx, hashShareToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case link.RevokedAt != nil:
+		u.recordAccess(ctx, link, client, ShareOutcomeRevoked)
+		return nil, ErrShareUnavailable
+	case link.ExpiresAt != nil && !link.ExpiresAt.After(now):
+		u.recordAccess(ctx, link, client, ShareOutcomeExpired)
+		return nil, ErrShareUnavailable
+	case link.MaxDownloads > 0 && link.DownloadCount >= link.MaxDownloads:
+		u.recordAccess(ctx, link, client, ShareOutcomeLimitReached)
+		return nil, ErrShareUnavailable
+	}
+
+	if link.PasswordHash != "" {
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			u.recordAccess(ctx, link, client, ShareOutcomeWrongPassword)
+			return nil, ErrSharePasswordRequired
+		}
+	}
+
+	content, err := u.fileUseCase.OpenFile(ctx, link.Owner, link.FileID, 0)
+	if err != nil {
+		u.recordAccess(ctx, link, client, ShareOutcomeError)
+		return nil, err
+	}
+
+	if client.Continuation {
+		u.recordAccess(ctx, link, client, ShareOutcomeContinued)
+		return content, nil
+	}
+
+	// The download is only counted once the content can be served, and the
+	// count is checked again atomically in case of concurrent downloads
+	ok, err := u.shareRepo.ConsumeDownload(ctx, link.ID, now)
+	if err != nil || !ok {
+		content.Reader.Close()
+		if err != nil {
+			u.recordAccess(ctx, link, client, ShareOutcomeError)
+			return nil, fmt.Errorf("failed to count share download: %w", err)
+		}
+		u.recordAccess(ctx, link, client, ShareOutcomeLimitReached)
+		return nil, ErrShareUnavailable
+	}
+
+	u.recordAccess(ctx, link, client, ShareOutcomeServed)
+	return content, nil
+}
+
+func (u *shareUseCase) getOwnedLink(ctx context.Context, owner string, linkID uuid.UUID) (*entity.ShareLink, error) {
+	link, err := u.shareRepo.GetShareLinkByID(ctx, linkID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if link.Owner != owner {
+		return nil, ErrShareNotFound
+	}
+	return link, nil
+}
+
+// recordAccess appends to the link's access log. A failure to record is
+// logged but does not fail the request.
+func (u *shareUseCase) recordAccess(ctx context.Context, link *entity.ShareLink, client ShareClient, outcome string) {
+	err := u.shareRepo.CreateShareAccess(ctx, &entity.ShareAccess{
+		ID:          uuid.New(),
+		ShareLinkID: link.ID,
+		Outcome:     outcome,
+		RemoteAddr:  client.RemoteAddr,
+		UserAgent:   client.UserAgent,
+		RequestID:   client.RequestID,
+		CreatedAt:   time.Now(),
+	})
+
+	entry := logger.FromContext(ctx).WithFields(logrus.Fields{
+		"share_id": link.ID,
+		"file_id":  link.FileID,
+		"outcome":  outcome,
+	})
+	if err != nil {
+		entry.WithError(err).Error("failed to record share access")
+		return
+	}
+	entry.Info("share link accessed")
+}
+
+// hashShareToken returns the form in which tokens are stored. Tokens carry
+// enough randomness that a fast hash is sufficient.
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
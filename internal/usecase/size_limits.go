@@ -0,0 +1,69 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// ErrFileTooLarge is returned for uploads above the size limit that applies
+// to them
+var ErrFileTooLarge = errors.New("file size exceeds maximum allowed size")
+
+// SizeLimitError reports the limit, in bytes, that an upload or a chunk
+// exceeded. It wraps ErrFileTooLarge or ErrChunkTooLarge.
+type SizeLimitError struct {
+	Err   error
+	Limit int64
+}
+
+func (e *SizeLimitError) Error() string {
+	return fmt.Sprintf("%v (limit is %d bytes)", e.Err, e.Limit)
+}
+
+func (e *SizeLimitError) Unwrap() error {
+	return e.Err
+}
+
+// Upload modes with their own size limit. Imports from URLs are only subject
+// to the global and per-MIME limits.
+const (
+	uploadModeDirect  = "direct"
+	uploadModeChunked = "chunked"
+	uploadModeImport  = "import"
+)
+
+// maxUploadSize returns the size limit of an upload: the lowest of the
+// global limit, the limit of its mode and the limit of its MIME type, where
+// an exact type takes precedence over its family ("image/*")
+func (u *fileUseCase) maxUploadSize(mode, mimeType string) int64 {
+	settings := u.config.Settings()
+	limit := settings.MaxFileSize
+	lower := func(l int64) {
+		if l > 0 && l < limit {
+			limit = l
+		}
+	}
+
+	switch mode {
+	case uploadModeDirect:
+		lower(settings.MaxDirectUploadSize)
+	case uploadModeChunked:
+		lower(settings.MaxChunkedUploadSize)
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		if l, ok := settings.MimeSizeLimits[mediaType]; ok {
+			lower(l)
+		} else if family, _, ok := strings.Cut(mediaType, "/"); ok {
+			lower(settings.MimeSizeLimits[family+"/*"])
+		}
+	}
+	return limit
+}
+
+// fileTooLarge returns the error reported for content above limit
+func fileTooLarge(limit int64) error {
+	return &SizeLimitError{Err: ErrFileTooLarge, Limit: limit}
+}
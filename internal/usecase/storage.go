@@ -0,0 +1,370 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/domain/entity"
+	"fileupload/pkg/compression"
+	"fileupload/pkg/encryption"
+	"fileupload/pkg/logger"
+	"fileupload/pkg/utils"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"os"
+	"strings"
+	"time"
+
+	minioClient "fileupload/pkg/minio"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrContentNotFound is returned when stored content exists neither on local
+// disk nor in MinIO
+var ErrContentNotFound = errors.New("stored content not found")
+
+// FileContent is an open, seekable handle on stored content. The caller must
+// close Reader.
+type FileContent struct {
+	Reader   ContentReader
+	Name     string
+	MimeType string
+	Size     int64
+	ModTime  time.Time
+	ETag     string
+	Checksum string // hex-encoded SHA-256 of the content, empty if unknown
+
+	// Encoding is the content coding ("gzip", "zstd") of content stored
+	// compressed. Encoded then reads it as stored, EncodedSize bytes long,
+	// for clients that accept that coding; Reader always reads the content
+	// as uploaded.
+	Encoding    string
+	Encoded     io.ReadSeeker
+	EncodedSize int64
+}
+
+// ContentReader reads stored content sequentially or at any offset
+type ContentReader interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// contentFormat tells how content is stored: encrypted with Encryption when
+// set, and compressed with Compression when set, Size being the size of the
+// content as uploaded
+type contentFormat struct {
+	Encryption  *entity.Encryption
+	Compression string
+	Size        int64
+}
+
+func fileFormat(file *entity.File) contentFormat {
+	return contentFormat{Encryption: file.Encryption, Compression: file.Compression, Size: file.Size}
+}
+
+func versionFormat(version *entity.FileVersion) contentFormat {
+	return contentFormat{Encryption: version.Encryption, Compression: version.Compression, Size: version.Size}
+}
+
+// openStoredContent opens stored content for reading, preferring the local
+// copy at path and falling back to the MinIO object when MinIO is enabled.
+// Encrypted and compressed content is decoded transparently.
+func openStoredContent(ctx context.Context, cfg *config.Config, path, objectKey string, format contentFormat) (*FileContent, error) {
+	content, err := openLocalContent(cfg, path, format)
+	if err == nil {
+		return content, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to open stored file: %w", err)
+	}
+
+	if !cfg.EnabledMinio {
+		return nil, ErrContentNotFound
+	}
+
+	object, info, err := openMinioObject(ctx, cfg, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	return decodeContent(cfg, object, info.Size, info.LastModified, format)
+}
+
+// openMinioObject opens a MinIO object as stored. A missing object is
+// reported as ErrContentNotFound.
+func openMinioObject(ctx context.Context, cfg *config.Config, objectKey string) (*minio.Object, minio.ObjectInfo, error) {
+	object, err := minioClient.Client.GetObject(ctx, cfg.MinioBucket, objectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, minio.ObjectInfo{}, fmt.Errorf("failed to open minio object: %w", err)
+	}
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, minio.ObjectInfo{}, ErrContentNotFound
+		}
+		return nil, minio.ObjectInfo{}, fmt.Errorf("failed to stat minio object: %w", err)
+	}
+	return object, info, nil
+}
+
+// openLocalContent opens content stored on local disk. A missing file is
+// reported with an error satisfying os.IsNotExist.
+func openLocalContent(cfg *config.Config, path string, format contentFormat) (*FileContent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat stored file: %w", err)
+	}
+	return decodeContent(cfg, f, info.Size(), info.ModTime(), format)
+}
+
+// decodeContent wraps stored content of the given size on storage in the
+// readers that decrypt and decompress it. src is closed on error.
+func decodeContent(cfg *config.Config, src ContentReader, size int64, modTime time.Time, format contentFormat) (*FileContent, error) {
+	content := &FileContent{Reader: src, Size: size, ModTime: modTime}
+
+	if enc := format.Encryption; enc != nil {
+		dataKey, err := unwrapDataKey(cfg, enc)
+		if err != nil {
+			src.Close()
+			return nil, fmt.Errorf("failed to decrypt stored content: %w", err)
+		}
+		r, err := encryption.NewReader(src, size, dataKey, enc.SegmentSize)
+		if err != nil {
+			src.Close()
+			return nil, fmt.Errorf("failed to decrypt stored content: %w", err)
+		}
+		content.Reader, content.Size = decryptingReader{r, src}, r.Size()
+	}
+
+	if format.Compression != "" {
+		encoded := content.Reader
+		r, err := compression.NewSeekableReader(encoded, content.Size, format.Size, format.Compression)
+		if err != nil {
+			encoded.Close()
+			return nil, fmt.Errorf("failed to decompress stored content: %w", err)
+		}
+		content.Encoding = format.Compression
+		content.Encoded = io.NewSectionReader(encoded, 0, content.Size)
+		content.EncodedSize = content.Size
+		content.Reader, content.Size = decompressingReader{r, encoded}, r.Size()
+	}
+	return content, nil
+}
+
+// decryptingReader reads decrypted content and closes the stored content
+type decryptingReader struct {
+	*encryption.Reader
+	io.Closer
+}
+
+// decompressingReader reads decompressed content and closes both the
+// decompressor and the content it reads
+type decompressingReader struct {
+	*compression.Reader
+	compressed io.Closer
+}
+
+func (r decompressingReader) Close() error {
+	r.Reader.Close()
+	return r.compressed.Close()
+}
+
+// contentWriter stores what is written to it into an underlying writer,
+// compressed and then encrypted as requested, and computes the checksum of
+// the content as written. Close flushes it without closing the underlying
+// writer.
+type contentWriter struct {
+	w          io.Writer
+	compressor io.WriteCloser
+	sealer     io.WriteCloser
+	compressed countingWriter
+	hash       hash.Hash
+}
+
+// newContentWriter returns a contentWriter storing content into dst in the
+// given format. The size of the format is not used.
+func newContentWriter(cfg *config.Config, dst io.Writer, format contentFormat) (*contentWriter, error) {
+	sealer, err := sealContent(cfg, dst, format.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	cw := &contentWriter{sealer: sealer, hash: sha256.New()}
+	var w io.Writer = sealer
+	if format.Compression != "" {
+		if cw.compressor, err = compression.NewWriter(io.MultiWriter(sealer, &cw.compressed), format.Compression); err != nil {
+			return nil, err
+		}
+		w = cw.compressor
+	}
+	cw.w = io.MultiWriter(w, cw.hash)
+	return cw, nil
+}
+
+func (w *contentWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *contentWriter) Close() error {
+	if w.compressor != nil {
+		if err := w.compressor.Close(); err != nil {
+			return err
+		}
+	}
+	return w.sealer.Close()
+}
+
+// Checksum returns the hex-encoded SHA-256 of the content written
+func (w *contentWriter) Checksum() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
+
+// CompressedSize returns the size of the compressed content, once closed
+func (w *contentWriter) CompressedSize() int64 {
+	return w.compressed.n
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// rangeServedMimeTypes are never stored compressed, whatever
+// CompressMimeTypes lists: players and viewers read them by range, and
+// compressed content can only be read from an offset by decompressing
+// everything before it
+var rangeServedMimeTypes = []string{"video/*", "audio/*", "application/pdf"}
+
+// contentCompression returns the algorithm new content of the given MIME
+// type is compressed with, or "" when it is stored as is
+func contentCompression(cfg *config.Config, mimeType string) string {
+	settings := cfg.Settings()
+	if settings.CompressionAlgorithm == "none" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil || matchesMimeType(rangeServedMimeTypes, mediaType) {
+		return ""
+	}
+	if matchesMimeType(settings.CompressMimeTypes, mediaType) {
+		return settings.CompressionAlgorithm
+	}
+	return ""
+}
+
+// matchesMimeType reports whether mediaType, or its family ("text/*"), is
+// listed in mimeTypes
+func matchesMimeType(mimeTypes []string, mediaType string) bool {
+	family, _, _ := strings.Cut(mediaType, "/")
+	for _, candidate := range mimeTypes {
+		if strings.EqualFold(candidate, mediaType) || strings.EqualFold(candidate, family+"/*") {
+			return true
+		}
+	}
+	return false
+}
+
+// contentChecksum computes the checksum of content as uploaded
+func contentChecksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileChecksum computes the checksum of a file stored as is
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return contentChecksum(f)
+}
+
+// newContentEncryption generates the data key of new content, or returns
+// nil when encryption is disabled and content is stored in plaintext
+func newContentEncryption(cfg *config.Config) (*entity.Encryption, error) {
+	if !cfg.EncryptionEnabled {
+		return nil, nil
+	}
+	_, keyID, wrapped, err := cfg.Keyring().NewDataKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return &entity.Encryption{KeyID: keyID, WrappedKey: wrapped, SegmentSize: encryption.DefaultSegmentSize}, nil
+}
+
+// sealContent returns a writer storing what is written to it into dst,
+// encrypted when enc is set. The writer must be closed to flush it; closing
+// it does not close dst.
+func sealContent(cfg *config.Config, dst io.Writer, enc *entity.Encryption) (io.WriteCloser, error) {
+	if enc == nil {
+		return nopWriteCloser{dst}, nil
+	}
+	dataKey, err := unwrapDataKey(cfg, enc)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.NewWriter(dst, dataKey, enc.SegmentSize)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func unwrapDataKey(cfg *config.Config, enc *entity.Encryption) ([]byte, error) {
+	keyring := cfg.Keyring()
+	if keyring == nil {
+		return nil, fmt.Errorf("content is encrypted with master key %q: %w", enc.KeyID, encryption.ErrUnknownKey)
+	}
+	return keyring.Unwrap(enc.KeyID, enc.WrappedKey)
+}
+
+// storedObjectOptions are the options MinIO objects of content in the given
+// format are uploaded with. Encrypted content is opaque; compressed content
+// carries its coding so that it can be served as is.
+func storedObjectOptions(mimeType string, format contentFormat, metadata map[string]string) minio.PutObjectOptions {
+	opts := minio.PutObjectOptions{ContentType: mimeType, UserMetadata: metadata}
+	if format.Encryption != nil {
+		opts.ContentType = "application/octet-stream"
+	} else if format.Compression != "" {
+		opts.ContentEncoding = format.Compression
+	}
+	return opts
+}
+
+// removeStoredContent deletes stored content from local disk and, when
+// enabled, from MinIO. Failures are logged and otherwise ignored.
+func removeStoredContent(ctx context.Context, cfg *config.Config, path, objectKey string) {
+	entry := logger.FromContext(ctx).WithField("path", path)
+
+	if err := utils.RemoveFile(path); err != nil {
+		entry.WithError(err).Warn("failed to remove stored file")
+	}
+
+	if cfg.EnabledMinio {
+		err := minioClient.Client.RemoveObject(ctx, cfg.MinioBucket, objectKey, minio.RemoveObjectOptions{})
+		if err != nil {
+			entry.WithError(err).Warn("failed to remove minio object")
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fileupload/config"
+	"fileupload/internal/domain/entity"
+	"fileupload/internal/repository"
+	"fileupload/pkg/logger"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// migrateBatchSize is the number of records loaded at once by Migrate
+const migrateBatchSize = 200
+
+// MigrateOptions control a storage migration
+type MigrateOptions struct {
+	Target       string // backend to move content to, entity.BackendLocal or entity.BackendMinio
+	Concurrency  int    // number of records migrated at once
+	DryRun       bool   // only report what would be migrated
+	DeleteSource bool   // remove the source copy once the target copy is verified
+}
+
+// MigrateResult counts the records processed by Migrate. Reused records
+// already had a verified copy on the target, left by an interrupted run or
+// shared with another record; Skipped ones were changed concurrently; Failed
+// ones could not be copied or verified. Bytes is the stored size of what was
+// copied, or would be in a dry run.
+type MigrateResult struct {
+	Copied  int
+	Reused  int
+	Skipped int
+	Failed  int
+	Bytes   int64
+}
+
+type StorageMigrationUseCase interface {
+	// Migrate copies the content of every file and file version that is not
+	// on the target backend to it, verifies the copy against the checksum of
+	// the content and records the new backend. It can be interrupted and run
+	// again at any point.
+	Migrate(ctx context.Context, opts MigrateOptions) (*MigrateResult, error)
+}
+
+type storageMigrationUseCase struct {
+	repo   repository.StorageRepository
+	config *config.Config
+}
+
+func NewStorageMigrationUseCase(repo repository.StorageRepository, config *config.Config) StorageMigrationUseCase {
+	return &storageMigrationUseCase{
+		repo:   repo,
+		config: config,
+	}
+}
+
+// migrateOutcome is what happened to one record
+type migrateOutcome int
+
+const (
+	migrateCopied migrateOutcome = iota
+	migrateReused
+	migrateSkipped
+)
+
+func (u *storageMigrationUseCase) Migrate(ctx context.Context, opts MigrateOptions) (*MigrateResult, error) {
+	if opts.Target != entity.BackendLocal && opts.Target != entity.BackendMinio {
+		return nil, fmt.Errorf("unknown storage backend %q", opts.Target)
+	}
+	if !u.config.EnabledMinio {
+		return nil, errors.New("MinIO is not enabled")
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+
+	result := &MigrateResult{}
+	var mu sync.Mutex
+	jobs := make(chan *repository.StoredContent)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for content := range jobs {
+				entry := logger.FromContext(ctx).WithFields(logrus.Fields{
+					"kind":    content.Kind,
+					"id":      content.ID,
+					"backend": content.Backend,
+					"target":  opts.Target,
+				})
+
+				outcome, size, err := u.migrate(ctx, content, opts)
+				mu.Lock()
+				switch {
+				case err != nil:
+					result.Failed++
+				case outcome == migrateCopied:
+					result.Copied++
+					result.Bytes += size
+				case outcome == migrateReused:
+					result.Reused++
+				default:
+					result.Skipped++
+				}
+				mu.Unlock()
+
+				switch {
+				case err != nil:
+					entry.WithError(err).Error("failed to migrate stored content")
+				case outcome == migrateSkipped:
+					entry.Warn("record changed while migrating its content, skipped")
+				default:
+					entry.Debug("stored content migrated")
+				}
+			}
+		}()
+	}
+
+	err := u.feed(ctx, opts.Target, jobs)
+	close(jobs)
+	wg.Wait()
+	return result, err
+}
+
+// feed lists the records to migrate and hands them to the workers
+func (u *storageMigrationUseCase) feed(ctx context.Context, target string, jobs chan<- *repository.StoredContent) error {
+	for _, kind := range repository.StorageKinds {
+		after := uuid.Nil
+		for {
+			batch, err := u.repo.ListStoredContent(ctx, kind, target, after, migrateBatchSize)
+			if err != nil {
+				return fmt.Errorf("failed to list %s records: %w", kind, err)
+			}
+			for _, content := range batch {
+				after = content.ID
+				select {
+				case jobs <- content:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if len(batch) < migrateBatchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// migrate moves the content of one record to the target backend and returns
+// the number of stored bytes copied
+func (u *storageMigrationUseCase) migrate(ctx context.Context, content *repository.StoredContent, opts MigrateOptions) (migrateOutcome, int64, error) {
+	source := content.Backend
+	if opts.DryRun {
+		return u.plan(ctx, content, opts.Target)
+	}
+
+	// Content recorded before checksums were kept is checked against a
+	// checksum of the source instead. Without either, a copy can only be
+	// checked for being readable.
+	expected := content.Checksum
+	if expected == "" {
+		sum, err := backendChecksum(ctx, u.config, source, content)
+		if err != nil && !errors.Is(err, ErrContentNotFound) {
+			return 0, 0, fmt.Errorf("failed to read source: %w", err)
+		}
+		expected = sum
+	}
+
+	outcome, copied := migrateReused, int64(0)
+	err := verifyBackendContent(ctx, u.config, opts.Target, content, expected)
+	if err != nil {
+		if !errors.Is(err, ErrContentNotFound) {
+			logger.FromContext(ctx).WithError(err).WithField("id", content.ID).Warn("existing copy on target is not valid, copying again")
+		}
+		if copied, err = copyBackendContent(ctx, u.config, content, source, opts.Target); err != nil {
+			return 0, 0, fmt.Errorf("failed to copy content: %w", err)
+		}
+		if err := verifyBackendContent(ctx, u.config, opts.Target, content, expected); err != nil {
+			return 0, 0, fmt.Errorf("failed to verify copy: %w", err)
+		}
+		outcome = migrateCopied
+	}
+
+	updated, err := u.repo.UpdateBackend(ctx, content, opts.Target)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to record backend: %w", err)
+	}
+	if !updated {
+		return migrateSkipped, 0, nil
+	}
+
+	if opts.DeleteSource {
+		if err := removeBackendContent(ctx, u.config, source, content); err != nil {
+			return 0, 0, fmt.Errorf("failed to remove source copy: %w", err)
+		}
+	}
+	return outcome, copied, nil
+}
+
+// plan reports what migrate would do with a record, from the presence of its
+// content on either backend
+func (u *storageMigrationUseCase) plan(ctx context.Context, content *repository.StoredContent, target string) (migrateOutcome, int64, error) {
+	if _, err := statBackendContent(ctx, u.config, target, content); err == nil {
+		return migrateReused, 0, nil
+	} else if !errors.Is(err, ErrContentNotFound) {
+		return 0, 0, err
+	}
+	size, err := statBackendContent(ctx, u.config, content.Backend, content)
+	if err != nil {
+		return 0, 0, err
+	}
+	return migrateCopied, size, nil
+}
@@ -0,0 +1,185 @@
+// Package compression compresses stored content with gzip or zstd and reads
+// it back through a seekable decompressing reader
+package compression
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithms, named after their HTTP content codings
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Valid reports whether algorithm is supported
+func Valid(algorithm string) bool {
+	return algorithm == Gzip || algorithm == Zstd
+}
+
+// NewWriter returns a writer compressing into w. Closing it flushes the
+// compressed stream without closing w.
+func NewWriter(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	switch algorithm {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+}
+
+// NewReader returns a reader decompressing r
+func NewReader(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+}
+
+// Reader decompresses content of a known size. Compressed streams can only
+// be read forward, so reading at an earlier offset than the last read starts
+// decompressing again from the beginning, and reading further ahead
+// decompresses and discards what lies in between. Reading at an offset thus
+// costs as much as reading everything before it; content that is mostly
+// read by range is better stored uncompressed.
+type Reader struct {
+	src            io.ReaderAt
+	compressedSize int64
+	size           int64
+	algorithm      string
+	offset         int64
+
+	mu       sync.Mutex
+	stream   io.ReadCloser
+	position int64 // offset of stream in the decompressed content
+}
+
+// NewSeekableReader returns a Reader of the size bytes of content compressed
+// with algorithm into the first compressedSize bytes of src
+func NewSeekableReader(src io.ReaderAt, compressedSize, size int64, algorithm string) (*Reader, error) {
+	if !Valid(algorithm) {
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+	return &Reader{src: src, compressedSize: compressedSize, size: size, algorithm: algorithm}, nil
+}
+
+// Size returns the size of the decompressed content
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if remaining := r.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stream == nil || off < r.position {
+		if err := r.restart(); err != nil {
+			return 0, err
+		}
+	}
+	if off > r.position {
+		skipped, err := io.CopyN(io.Discard, r.stream, off-r.position)
+		r.position += skipped
+		if err != nil {
+			return 0, r.fail(err)
+		}
+	}
+
+	n, err := io.ReadFull(r.stream, p)
+	r.position += int64(n)
+	if err != nil {
+		return n, r.fail(err)
+	}
+	if off+int64(n) == r.size {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close releases the decompressor. It does not close the underlying content.
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stream == nil {
+		return nil
+	}
+	err := r.stream.Close()
+	r.stream = nil
+	return err
+}
+
+func (r *Reader) restart() error {
+	if r.stream != nil {
+		r.stream.Close()
+		r.stream = nil
+	}
+	stream, err := NewReader(io.NewSectionReader(r.src, 0, r.compressedSize), r.algorithm)
+	if err != nil {
+		return fmt.Errorf("failed to start decompression: %w", err)
+	}
+	r.stream, r.position = stream, 0
+	return nil
+}
+
+// fail drops a stream that failed, so that the next read starts over, and
+// reports a stream that ended before the expected size as truncated
+func (r *Reader) fail(err error) error {
+	r.stream.Close()
+	r.stream = nil
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("compressed content ends before %d bytes: %w", r.size, io.ErrUnexpectedEOF)
+	}
+	return err
+}
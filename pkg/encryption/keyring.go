@@ -0,0 +1,156 @@
+// Package encryption implements envelope encryption of stored content: every
+// piece of content is encrypted with its own random data key, and that data
+// key is stored wrapped (encrypted) by a master key identified by an ID
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size in bytes of master and data keys (AES-256)
+const KeySize = 32
+
+// ErrUnknownKey is returned when content is wrapped by a master key that is
+// not in the keyring
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Keyring holds the master keys by ID. New data keys are wrapped by the
+// active key; the others are kept to unwrap content that has not been
+// re-wrapped yet.
+type Keyring struct {
+	keys   map[string]cipher.AEAD
+	active string
+}
+
+// NewKeyring builds a keyring from 32-byte master keys. active may be empty
+// for a keyring that can only unwrap.
+func NewKeyring(keys map[string][]byte, active string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD, len(keys)), active: active}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+	if _, ok := k.keys[active]; active != "" && !ok {
+		return nil, fmt.Errorf("active master key %q: %w", active, ErrUnknownKey)
+	}
+	return k, nil
+}
+
+// ActiveKeyID returns the ID of the key new data keys are wrapped by
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// NewDataKey generates a random data key and returns it along with its
+// wrapped form and the ID of the master key that wrapped it
+func (k *Keyring) NewDataKey() (dataKey []byte, keyID string, wrapped []byte, err error) {
+	dataKey = make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", nil, err
+	}
+	keyID, wrapped, err = k.Wrap(dataKey)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return dataKey, keyID, wrapped, nil
+}
+
+// Wrap encrypts a data key with the active master key. The key ID is bound
+// to the result so a wrapped key cannot be passed off as another key's.
+func (k *Keyring) Wrap(dataKey []byte) (keyID string, wrapped []byte, err error) {
+	aead, ok := k.keys[k.active]
+	if !ok {
+		return "", nil, errors.New("no active master key")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.active, aead.Seal(nonce, nonce, dataKey, []byte(k.active)), nil
+}
+
+// Unwrap decrypts a data key wrapped by the master key keyID
+func (k *Keyring) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is truncated")
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with master key %q: %w", keyID, err)
+	}
+	return dataKey, nil
+}
+
+// ParseKey decodes a base64-encoded 32-byte key
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("key is not valid base64")
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key is %d bytes, expected %d", len(key), KeySize)
+	}
+	return key, nil
+}
+
+// LoadKeyFile reads master keys from a file holding one "<id> <base64 key>"
+// pair per line. Blank lines and lines starting with # are ignored.
+func LoadKeyFile(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer f.Close()
+
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("key file %s line %d: expected \"<id> <base64 key>\"", path, line)
+		}
+		key, err := ParseKey(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("key file %s line %d: %w", path, line, err)
+		}
+		if _, ok := keys[fields[0]]; ok {
+			return nil, fmt.Errorf("key file %s line %d: duplicate key %q", path, line, fields[0])
+		}
+		keys[fields[0]] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return keys, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key is %d bytes, expected %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
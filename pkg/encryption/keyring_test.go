@@ -0,0 +1,137 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+	keys := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		keys[id] = testDataKey(t)
+	}
+	k, err := NewKeyring(keys, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	k := testKeyring(t, "k1", "k1", "k2")
+	dataKey, keyID, wrapped, err := k.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "k1" {
+		t.Fatalf("data key wrapped by %q, want the active key k1", keyID)
+	}
+	got, err := k.Unwrap(keyID, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Fatal("unwrapped data key differs")
+	}
+}
+
+func TestUnwrapUnknownKey(t *testing.T) {
+	k := testKeyring(t, "k1", "k1")
+	_, keyID, wrapped, err := k.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := k.Unwrap("missing", wrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unwrapping with an unknown key returned %v, want ErrUnknownKey", err)
+	}
+
+	// A keyring that lost the key cannot unwrap what it wrapped
+	other := testKeyring(t, "", "k2")
+	if _, err := other.Unwrap(keyID, wrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unwrapping with a retired key returned %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestUnwrapFailures(t *testing.T) {
+	k := testKeyring(t, "k1", "k1", "k2")
+	_, _, wrapped, err := k.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := bytes.Clone(wrapped)
+	flipped[len(flipped)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		keyID   string
+		wrapped []byte
+	}{
+		{"passed off as another key's", "k2", wrapped},
+		{"tampered", "k1", flipped},
+		{"truncated", "k1", wrapped[:4]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := k.Unwrap(tt.keyID, tt.wrapped); err == nil {
+				t.Fatal("data key unwrapped without error")
+			}
+		})
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	if _, err := NewKeyring(map[string][]byte{"k1": testDataKey(t)}, "k2"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unknown active key returned %v, want ErrUnknownKey", err)
+	}
+	if _, err := NewKeyring(map[string][]byte{"k1": make([]byte, 16)}, "k1"); err == nil {
+		t.Fatal("accepted a 16-byte master key")
+	}
+
+	k := testKeyring(t, "", "k1")
+	if _, _, err := k.Wrap(testDataKey(t)); err == nil {
+		t.Fatal("wrapped without an active key")
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testDataKey(t))
+	tests := []struct {
+		name    string
+		content string
+		keys    int
+		wantErr bool
+	}{
+		{"keys", "# master keys\nk1 " + key + "\n\nk2 " + key + "\n", 2, false},
+		{"missing key", "k1\n", 0, true},
+		{"invalid base64", "k1 not-base64!\n", 0, true},
+		{"short key", "k1 " + base64.StdEncoding.EncodeToString(make([]byte, 16)) + "\n", 0, true},
+		{"duplicate", "k1 " + key + "\nk1 " + key + "\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			keys, err := LoadKeyFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != tt.keys {
+				t.Fatalf("loaded %d keys, want %d", len(keys), tt.keys)
+			}
+		})
+	}
+}
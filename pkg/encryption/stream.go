@@ -0,0 +1,236 @@
+package encryption
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultSegmentSize is the plaintext size of one encrypted segment
+const DefaultSegmentSize = 64 * 1024
+
+// tagSize is the size of the GCM authentication tag added to each segment
+const tagSize = 16
+
+// Content is encrypted with AES-256-GCM in independent segments so that any
+// range can be decrypted without reading what precedes it. Every segment but
+// the last holds segmentSize bytes of plaintext followed by its tag; the last
+// one holds the rest, possibly nothing. The nonce of a segment is its index
+// plus a flag marking the last one, which is safe because a data key only
+// ever encrypts one piece of content, and makes reordered, truncated or
+// extended content fail to decrypt.
+func segmentNonce(index int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], uint64(index))
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// SealedSize returns the size of size bytes of plaintext once encrypted
+func SealedSize(size int64, segmentSize int) int64 {
+	segments := (size + int64(segmentSize) - 1) / int64(segmentSize)
+	return size + max(segments, 1)*tagSize
+}
+
+// Writer encrypts what is written to it into an underlying writer. Close
+// must be called to write the last segment.
+type Writer struct {
+	dst         io.Writer
+	aead        cipher.AEAD
+	segmentSize int
+	buf         []byte
+	index       int64
+	err         error
+}
+
+// NewWriter returns a Writer encrypting into dst with dataKey
+func NewWriter(dst io.Writer, dataKey []byte, segmentSize int) (*Writer, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if segmentSize <= 0 {
+		return nil, fmt.Errorf("invalid segment size %d", segmentSize)
+	}
+	return &Writer{
+		dst:         dst,
+		aead:        aead,
+		segmentSize: segmentSize,
+		buf:         make([]byte, 0, segmentSize+tagSize),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		// A full segment is only sealed once more data follows, since the
+		// last segment is sealed differently
+		if len(w.buf) == w.segmentSize {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(w.buf[len(w.buf):w.segmentSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Close writes the last segment. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.seal(true); err != nil {
+		return err
+	}
+	w.err = errors.New("encryption writer is closed")
+	return nil
+}
+
+func (w *Writer) seal(last bool) error {
+	sealed := w.aead.Seal(w.buf[:0], segmentNonce(w.index, last), w.buf, nil)
+	if _, err := w.dst.Write(sealed); err != nil {
+		w.err = err
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.index++
+	return nil
+}
+
+// Reader decrypts content written by a Writer. It reads the segments it
+// needs from the underlying content on demand, so it can be read from any
+// offset.
+type Reader struct {
+	src         io.ReaderAt
+	aead        cipher.AEAD
+	segmentSize int64
+	sealedSize  int64
+	size        int64
+	segments    int64
+	offset      int64
+
+	mu      sync.Mutex
+	current int64 // index of the segment in plain, -1 when none
+	plain   []byte
+	sealed  []byte
+}
+
+// NewReader returns a Reader decrypting sealedSize bytes of src with dataKey
+func NewReader(src io.ReaderAt, sealedSize int64, dataKey []byte, segmentSize int) (*Reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if segmentSize <= 0 {
+		return nil, fmt.Errorf("invalid segment size %d", segmentSize)
+	}
+
+	sealedSegment := int64(segmentSize + tagSize)
+	segments := (sealedSize + sealedSegment - 1) / sealedSegment
+	if segments == 0 || sealedSize-(segments-1)*sealedSegment < tagSize {
+		return nil, errors.New("encrypted content is truncated")
+	}
+	return &Reader{
+		src:         src,
+		aead:        aead,
+		segmentSize: int64(segmentSize),
+		sealedSize:  sealedSize,
+		size:        sealedSize - segments*tagSize,
+		segments:    segments,
+		current:     -1,
+		sealed:      make([]byte, sealedSegment),
+	}, nil
+}
+
+// Size returns the size of the plaintext
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off < r.size {
+		index := off / r.segmentSize
+		plain, err := r.segment(index)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], plain[off-index*r.segmentSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// segment returns the decrypted segment at index, keeping the last one
+// decrypted for sequential reads
+func (r *Reader) segment(index int64) ([]byte, error) {
+	if index == r.current {
+		return r.plain, nil
+	}
+
+	start := index * int64(len(r.sealed))
+	sealed := r.sealed[:min(int64(len(r.sealed)), r.sealedSize-start)]
+	if n, err := r.src.ReadAt(sealed, start); n < len(sealed) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	plain, err := r.aead.Open(r.plain[:0], segmentNonce(index, index == r.segments-1), sealed, nil)
+	if err != nil {
+		r.current = -1
+		return nil, fmt.Errorf("segment %d failed authentication: %w", index, err)
+	}
+	r.plain, r.current = plain, index
+	return plain, nil
+}
@@ -0,0 +1,237 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+const testSegmentSize = 16
+
+func testDataKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testPlaintext(t *testing.T, size int) []byte {
+	t.Helper()
+	plain := make([]byte, size)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func seal(t *testing.T, plain, dataKey []byte) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	w, err := NewWriter(&sealed, dataKey, testSegmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in uneven pieces so segments do not line up with writes
+	for p := plain; len(p) > 0; {
+		n := min(len(p), 7)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+// open decrypts sealed content, returning the error of NewReader or of the
+// read, whichever fails
+func open(sealed, dataKey []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), dataKey, testSegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	dataKey := testDataKey(t)
+	for _, size := range []int{0, 1, testSegmentSize - 1, testSegmentSize, testSegmentSize + 1, 3*testSegmentSize + 5} {
+		plain := testPlaintext(t, size)
+		sealed := seal(t, plain, dataKey)
+		if got, want := int64(len(sealed)), SealedSize(int64(size), testSegmentSize); got != want {
+			t.Errorf("size %d: sealed %d bytes, SealedSize says %d", size, got, want)
+		}
+
+		r, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), dataKey, testSegmentSize)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if r.Size() != int64(size) {
+			t.Errorf("size %d: reader reports size %d", size, r.Size())
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted content differs", size)
+		}
+	}
+}
+
+func TestWrongDataKey(t *testing.T) {
+	sealed := seal(t, testPlaintext(t, 40), testDataKey(t))
+	if _, err := open(sealed, testDataKey(t)); err == nil {
+		t.Fatal("content decrypted with another data key")
+	}
+}
+
+func TestTamperedContent(t *testing.T) {
+	dataKey := testDataKey(t)
+	plain := testPlaintext(t, 3*testSegmentSize+5)
+	sealed := seal(t, plain, dataKey)
+	segment := testSegmentSize + tagSize
+
+	tests := []struct {
+		name   string
+		sealed func() []byte
+	}{
+		{
+			name: "last segment dropped",
+			sealed: func() []byte {
+				return sealed[:3*segment]
+			},
+		},
+		{
+			name: "truncated within a segment",
+			sealed: func() []byte {
+				return sealed[:2*segment+5]
+			},
+		},
+		{
+			name: "truncated within a tag",
+			sealed: func() []byte {
+				return sealed[:len(sealed)-1]
+			},
+		},
+		{
+			name: "segments reordered",
+			sealed: func() []byte {
+				out := bytes.Clone(sealed)
+				copy(out[:segment], sealed[segment:2*segment])
+				copy(out[segment:2*segment], sealed[:segment])
+				return out
+			},
+		},
+		{
+			name: "segment extended",
+			sealed: func() []byte {
+				return append(bytes.Clone(sealed), sealed[:segment]...)
+			},
+		},
+		{
+			name: "byte flipped",
+			sealed: func() []byte {
+				out := bytes.Clone(sealed)
+				out[segment+3] ^= 1
+				return out
+			},
+		},
+		{
+			name: "empty",
+			sealed: func() []byte {
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := open(tt.sealed(), dataKey); err == nil {
+				t.Fatal("tampered content decrypted without error")
+			}
+		})
+	}
+}
+
+func TestSeek(t *testing.T) {
+	dataKey := testDataKey(t)
+	plain := testPlaintext(t, 4*testSegmentSize+3)
+	sealed := seal(t, plain, dataKey)
+	r, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), dataKey, testSegmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		want   int64
+		n      int
+	}{
+		{"within a segment", 3, io.SeekStart, 3, 5},
+		{"across a boundary", testSegmentSize - 2, io.SeekStart, testSegmentSize - 2, 4},
+		{"across several segments", 5, io.SeekStart, 5, 2*testSegmentSize + 7},
+		{"backwards", -(testSegmentSize + 4), io.SeekCurrent, testSegmentSize + 8, testSegmentSize},
+		{"on a boundary", 2 * testSegmentSize, io.SeekStart, 2 * testSegmentSize, 1},
+		{"from the end", -7, io.SeekEnd, 4*testSegmentSize - 4, 7},
+		{"into the last segment", 4 * testSegmentSize, io.SeekStart, 4 * testSegmentSize, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, err := r.Seek(tt.offset, tt.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos != tt.want {
+				t.Fatalf("seeked to %d, want %d", pos, tt.want)
+			}
+			got := make([]byte, tt.n)
+			if _, err := io.ReadFull(r, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain[pos:pos+int64(tt.n)]) {
+				t.Fatalf("read at %d differs from the plaintext", pos)
+			}
+		})
+	}
+
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("read at the end returned %d, %v, want 0, EOF", n, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("seeked to a negative position")
+	}
+}
+
+func TestReadAtAcrossSegments(t *testing.T) {
+	dataKey := testDataKey(t)
+	plain := testPlaintext(t, 3*testSegmentSize)
+	sealed := seal(t, plain, dataKey)
+	r, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), dataKey, testSegmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, testSegmentSize+2)
+	if _, err := r.ReadAt(got, testSegmentSize-1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain[testSegmentSize-1:2*testSegmentSize+1]) {
+		t.Fatal("ReadAt differs from the plaintext")
+	}
+
+	// Reading past the end returns what there is along with EOF
+	n, err := r.ReadAt(make([]byte, 10), int64(len(plain))-4)
+	if n != 4 || err != io.EOF {
+		t.Fatalf("ReadAt past the end returned %d, %v, want 4, EOF", n, err)
+	}
+}
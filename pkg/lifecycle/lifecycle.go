@@ -0,0 +1,92 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"fileupload/pkg/logger"
+)
+
+// StopFunc stops a component. It must return once the component has stopped
+// or ctx is done, whichever comes first.
+type StopFunc func(ctx context.Context) error
+
+type hook struct {
+	name string
+	stop StopFunc
+}
+
+// Manager stops registered components in the order they were registered.
+// Register the HTTP server first, then the components it depends on, so that
+// nothing is torn down while still in use.
+type Manager struct {
+	mu    sync.Mutex
+	hooks []hook
+}
+
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// Register adds a component to be stopped during shutdown
+func (m *Manager) Register(name string, stop StopFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, stop: stop})
+}
+
+// Shutdown runs every stop hook in registration order. A failing hook does
+// not prevent the remaining ones from running; all errors are returned joined.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := append([]hook(nil), m.hooks...)
+	m.mu.Unlock()
+
+	var errs []error
+	for _, h := range hooks {
+		entry := logger.FromContext(ctx).WithField("component", h.name)
+		entry.Info("stopping component")
+		if err := h.stop(ctx); err != nil {
+			entry.WithError(err).Error("component did not stop cleanly")
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			continue
+		}
+		entry.Info("component stopped")
+	}
+	return errors.Join(errs...)
+}
+
+// Periodic runs fn every interval in its own goroutine until the returned
+// StopFunc is called. Stopping cancels the context passed to a running fn and
+// waits for it to return.
+func Periodic(interval time.Duration, fn func(ctx context.Context)) StopFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn(ctx)
+			}
+		}
+	}()
+
+	return func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx carrying the given log fields in addition
+// to any fields already attached to it
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := logrus.Fields{}
+	for k, v := range fieldsFrom(ctx) {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FromContext returns an application log entry populated with the fields
+// carried by ctx (request ID, owner, ...)
+func FromContext(ctx context.Context) *logrus.Entry {
+	return Log.WithFields(fieldsFrom(ctx))
+}
+
+// UploadFromContext returns an upload log entry populated with the fields
+// carried by ctx
+func UploadFromContext(ctx context.Context) *logrus.Entry {
+	return UploadLog.WithFields(fieldsFrom(ctx))
+}
+
+func fieldsFrom(ctx context.Context) logrus.Fields {
+	if ctx == nil {
+		return logrus.Fields{}
+	}
+	if fields, ok := ctx.Value(fieldsKey{}).(logrus.Fields); ok {
+		return fields
+	}
+	return logrus.Fields{}
+}
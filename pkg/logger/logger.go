@@ -1,13 +1,26 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
 var Log *logrus.Logger
 
+// Options controls how a logger is formatted and where it writes
+type Options struct {
+	Level      string // panic, fatal, error, warn, info, debug, trace
+	Format     string // "text" or "json"
+	File       string // empty means stdout
+	MaxSizeMB  int    // rotate the file once it grows past this size
+	MaxBackups int    // number of rotated files to keep
+}
+
 func init() {
 	Log = logrus.New()
 
@@ -21,7 +34,59 @@ func init() {
 
 	// Level default: Info (bisa diubah jadi Debug, Error, dll)
 	Log.SetLevel(logrus.InfoLevel)
+}
+
+// Setup applies the given options to the application logger
+func Setup(opts Options) error {
+	return configure(Log, opts)
+}
+
+// SetLevel changes the level of both the application and upload loggers
+func SetLevel(level string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	Log.SetLevel(lvl)
+	UploadLog.SetLevel(lvl)
+	return nil
+}
+
+func configure(l *logrus.Logger, opts Options) error {
+	if opts.Level != "" {
+		lvl, err := logrus.ParseLevel(opts.Level)
+		if err != nil {
+			return fmt.Errorf("invalid log level %q: %w", opts.Level, err)
+		}
+		l.SetLevel(lvl)
+	}
+
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		l.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case "json":
+		l.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format %q", opts.Format)
+	}
+
+	out, err := openOutput(opts)
+	if err != nil {
+		return err
+	}
+	if closer, ok := l.Out.(io.Closer); ok && l.Out != os.Stdout && l.Out != os.Stderr {
+		closer.Close()
+	}
+	l.SetOutput(out)
+	return nil
+}
 
-	// Panic, Fatal, Error, Warn, Info, Debug, Trace
-	// Log.SetLevel(logrus.DebugLevel)
+func openOutput(opts Options) (io.Writer, error) {
+	if opts.File == "" {
+		return os.Stdout, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.File), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return NewRotatingFile(opts.File, int64(opts.MaxSizeMB)*1024*1024, opts.MaxBackups)
 }
@@ -10,15 +10,15 @@ var UploadLog *logrus.Logger
 
 func init() {
 	UploadLog = logrus.New()
-
-	file, err := os.OpenFile("logs/upload.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err == nil {
-		UploadLog.SetOutput(file)
-	} else {
-		UploadLog.SetOutput(os.Stdout)
-	}
-
+	UploadLog.SetOutput(os.Stdout)
 	UploadLog.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
 }
+
+// SetupUpload applies the given options to the upload logger. Unlike the
+// previous behaviour, a log file that cannot be opened is reported instead of
+// silently falling back to stdout.
+func SetupUpload(opts Options) error {
+	return configure(UploadLog, opts)
+}
@@ -0,0 +1,73 @@
+// Package ratelimit implements the token bucket algorithm shared by the rate
+// limit stores
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket holding at most Burst tokens and refilled
+// at Rate tokens per second. Every request takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Bucket is the state of one client's token bucket
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Result is the outcome of taking a token
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket and Remaining the tokens left in it
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a token is available, zero when allowed
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// Enabled reports whether the limit restricts anything
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Full returns a full bucket as of now
+func (l Limit) Full(now time.Time) Bucket {
+	return Bucket{Tokens: float64(l.Burst), UpdatedAt: now}
+}
+
+// RefillTime is how long an empty bucket takes to fill up. A bucket left
+// alone for that long is full and can be forgotten.
+func (l Limit) RefillTime() time.Duration {
+	return seconds(float64(l.Burst) / l.Rate)
+}
+
+// Take refills b for the time elapsed until now and takes a token from it
+// if one is available
+func (l Limit) Take(b Bucket, now time.Time) (Bucket, Result) {
+	if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(float64(l.Burst), b.Tokens+elapsed*l.Rate)
+		b.UpdatedAt = now
+	}
+
+	result := Result{Limit: l.Burst}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.Tokens) / l.Rate)
+	}
+	result.Remaining = int(b.Tokens)
+	result.ResetAfter = seconds((float64(l.Burst) - b.Tokens) / l.Rate)
+	return b, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 3}
+	start := time.Unix(1000, 0)
+	bucket := limit.Full(start)
+
+	tests := []struct {
+		name       string
+		after      time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+		resetAfter time.Duration
+	}{
+		{"first of the burst", 0, true, 2, 0, 500 * time.Millisecond},
+		{"second of the burst", 0, true, 1, 0, time.Second},
+		{"last of the burst", 0, true, 0, 0, 1500 * time.Millisecond},
+		{"empty bucket", 0, false, 0, 500 * time.Millisecond, 1500 * time.Millisecond},
+		{"partly refilled", 250 * time.Millisecond, false, 0, 250 * time.Millisecond, 1250 * time.Millisecond},
+		{"refilled with one token", 500 * time.Millisecond, true, 0, 0, 1250 * time.Millisecond},
+		{"refill capped at the burst", time.Hour, true, 2, 0, 500 * time.Millisecond},
+	}
+	now := start
+	for _, tt := range tests {
+		now = now.Add(tt.after)
+		var result Result
+		bucket, result = limit.Take(bucket, now)
+		if result.Allowed != tt.allowed {
+			t.Fatalf("%s: allowed = %v, want %v", tt.name, result.Allowed, tt.allowed)
+		}
+		if result.Limit != limit.Burst {
+			t.Errorf("%s: limit = %d, want %d", tt.name, result.Limit, limit.Burst)
+		}
+		if result.Remaining != tt.remaining {
+			t.Errorf("%s: remaining = %d, want %d", tt.name, result.Remaining, tt.remaining)
+		}
+		if result.RetryAfter != tt.retryAfter {
+			t.Errorf("%s: retry after = %v, want %v", tt.name, result.RetryAfter, tt.retryAfter)
+		}
+		if result.ResetAfter != tt.resetAfter {
+			t.Errorf("%s: reset after = %v, want %v", tt.name, result.ResetAfter, tt.resetAfter)
+		}
+	}
+}
+
+func TestTakeIgnoresClockGoingBack(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 1}
+	now := time.Unix(1000, 0)
+	bucket, _ := limit.Take(limit.Full(now), now)
+
+	// A bucket updated by an instance whose clock runs ahead is not refilled
+	// by an earlier time, nor moved back to it
+	bucket, result := limit.Take(bucket, now.Add(-time.Minute))
+	if result.Allowed {
+		t.Fatal("took a token from an empty bucket")
+	}
+	if !bucket.UpdatedAt.Equal(now) {
+		t.Fatalf("bucket updated at %v, want %v", bucket.UpdatedAt, now)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	tests := []struct {
+		limit      Limit
+		enabled    bool
+		refillTime time.Duration
+	}{
+		{Limit{Rate: 10, Burst: 100}, true, 10 * time.Second},
+		{Limit{Rate: 0.5, Burst: 1}, true, 2 * time.Second},
+		{Limit{Rate: 0, Burst: 100}, false, 0},
+		{Limit{Rate: 10, Burst: 0}, false, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.limit.Enabled(); got != tt.enabled {
+			t.Errorf("%+v: enabled = %v, want %v", tt.limit, got, tt.enabled)
+		}
+		if !tt.enabled {
+			continue
+		}
+		if got := tt.limit.RefillTime(); got != tt.refillTime {
+			t.Errorf("%+v: refill time = %v, want %v", tt.limit, got, tt.refillTime)
+		}
+	}
+}
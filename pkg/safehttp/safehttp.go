@@ -0,0 +1,164 @@
+// Package safehttp provides an HTTP client for fetching URLs supplied by
+// users. Every connection, including those made while following redirects,
+// is checked against the resolved IP address so that requests cannot reach
+// loopback, private or otherwise internal destinations.
+package safehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrBlockedDestination is returned for URLs whose host resolves to an
+// address that is not allowed
+var ErrBlockedDestination = errors.New("destination address is not allowed")
+
+// ErrInvalidURL is returned for URLs that are not absolute http(s) URLs
+var ErrInvalidURL = errors.New("only absolute http and https URLs are allowed")
+
+// ErrTooManyRedirects is returned once a request exceeds its redirect limit
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+const (
+	dialTimeout           = 10 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	responseHeaderTimeout = 30 * time.Second
+)
+
+// Options configures a client. AllowedCIDRs and AllowedHosts exempt
+// destinations from the address check, e.g. a partner reachable over a
+// private network.
+type Options struct {
+	Timeout      time.Duration
+	MaxRedirects int
+	AllowedCIDRs []string
+	AllowedHosts []string
+}
+
+// blockedPrefixes are ranges that are never reachable from a public URL, in
+// addition to what netip classifies as loopback, private, link-local,
+// multicast or unspecified
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64 can map to internal IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001::/32"),      // Teredo
+	netip.MustParsePrefix("2002::/16"),      // 6to4
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site-local
+}
+
+type guard struct {
+	allowedPrefixes []netip.Prefix
+	allowedHosts    map[string]bool
+}
+
+// NewClient returns a client that refuses internal destinations, follows at
+// most opts.MaxRedirects redirects and ignores proxy environment variables
+func NewClient(opts Options) (*http.Client, error) {
+	g := &guard{allowedHosts: make(map[string]bool, len(opts.AllowedHosts))}
+	for _, cidr := range opts.AllowedCIDRs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed CIDR %q: %w", cidr, err)
+		}
+		g.allowedPrefixes = append(g.allowedPrefixes, prefix.Masked())
+	}
+	for _, host := range opts.AllowedHosts {
+		g.allowedHosts[strings.ToLower(strings.TrimSpace(host))] = true
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           g.dialContext,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			return CheckURL(req.URL)
+		},
+	}, nil
+}
+
+// CheckURL verifies that u is an absolute http or https URL
+func CheckURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// IsPublicAddr reports whether addr is a globally routable unicast address
+func IsPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *guard) allowed(host string, addr netip.Addr) bool {
+	if g.allowedHosts[strings.ToLower(host)] || IsPublicAddr(addr) {
+		return true
+	}
+	for _, prefix := range g.allowedPrefixes {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialContext resolves the host itself and only dials addresses that pass the
+// check, so a DNS answer cannot change between the check and the connection
+func (g *guard) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var lastErr error = fmt.Errorf("%w: %s", ErrBlockedDestination, host)
+	for _, addr := range addrs {
+		if !g.allowed(host, addr) {
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,199 @@
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"64:ff9b::a00:1", false},
+		{"2002:a00:1::", false},
+		{"8.8.8.8", true},
+		{"172.32.0.1", true},
+		{"::ffff:8.8.8.8", true},
+		{"2606:4700::1111", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := IsPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+				t.Fatalf("IsPublicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+			}
+		})
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"http://example.com/file", true},
+		{"https://example.com:8443/file", true},
+		{"ftp://example.com/file", false},
+		{"file:///etc/passwd", false},
+		{"/relative/path", false},
+		{"http:///no-host", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := CheckURL(u); (err == nil) != tt.valid {
+				t.Fatalf("CheckURL(%s) = %v, want valid %v", tt.url, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestClientRefusesInternalDestinations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	client, err := NewClient(Options{Timeout: 5 * time.Second, MaxRedirects: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"loopback", "http://127.0.0.1:" + port + "/"},
+		{"loopback name", "http://localhost:" + port + "/"},
+		{"IPv6 loopback", "http://[::1]:" + port + "/"},
+		{"IPv4-mapped loopback", "http://[::ffff:127.0.0.1]:" + port + "/"},
+		{"metadata service", "http://169.254.169.254/latest/meta-data/"},
+		{"private network", "http://10.0.0.1:" + port + "/"},
+		{"IPv4-mapped private network", "http://[::ffff:192.168.0.1]:" + port + "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(tt.url)
+			if err == nil {
+				resp.Body.Close()
+				t.Fatal("request reached an internal destination")
+			}
+			if !errors.Is(err, ErrBlockedDestination) {
+				t.Fatalf("request failed with %v, want ErrBlockedDestination", err)
+			}
+		})
+	}
+}
+
+func TestClientRefusesRedirects(t *testing.T) {
+	var reached atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		reached.Store(true)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	mux.HandleFunc("/to-loopback", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://127.0.0.1:"+port+"/internal", http.StatusFound)
+	})
+	mux.HandleFunc("/to-metadata", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	})
+	mux.HandleFunc("/to-private", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://192.168.0.1:"+port+"/internal", http.StatusFound)
+	})
+	mux.HandleFunc("/to-file", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+
+	// The test server is only reachable through its allowed host name, so
+	// redirects to its address are refused like any other internal address
+	client, err := NewClient(Options{Timeout: 5 * time.Second, MaxRedirects: 3, AllowedHosts: []string{"localhost"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://localhost:" + port
+
+	resp, err := client.Get(base + "/internal")
+	if err != nil {
+		t.Fatalf("allowed host refused: %v", err)
+	}
+	resp.Body.Close()
+	reached.Store(false)
+
+	tests := []struct {
+		name string
+		path string
+		err  error
+	}{
+		{"to loopback", "/to-loopback", ErrBlockedDestination},
+		{"to metadata service", "/to-metadata", ErrBlockedDestination},
+		{"to private network", "/to-private", ErrBlockedDestination},
+		{"to another scheme", "/to-file", ErrInvalidURL},
+		{"too many", "/loop", ErrTooManyRedirects},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(base + tt.path)
+			if err == nil {
+				resp.Body.Close()
+				t.Fatal("redirect was followed")
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("request failed with %v, want %v", err, tt.err)
+			}
+			if reached.Load() {
+				t.Fatal("redirect reached the internal handler")
+			}
+		})
+	}
+}
+
+func TestAllowedCIDRs(t *testing.T) {
+	if _, err := NewClient(Options{AllowedCIDRs: []string{"not a cidr"}}); err == nil {
+		t.Fatal("accepted an invalid CIDR")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	client, err := NewClient(Options{Timeout: 5 * time.Second, AllowedCIDRs: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("allowed CIDR refused: %v", err)
+	}
+	resp.Body.Close()
+}
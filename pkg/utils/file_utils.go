@@ -7,8 +7,16 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
+// MaxFilenameLength is the length in bytes that SanitizeFilename truncates
+// names to, the limit of most filesystems
+const MaxFilenameLength = 255
+
 // CalculateFileHash calculates the MD5 hash of a file
 func CalculateFileHash(filePath string) (string, error) {
 	file, err := os.Open(filePath)
@@ -43,6 +51,41 @@ func GetFileExtension(filename string) string {
 	return filepath.Ext(filename)
 }
 
+// SanitizeFilename turns a client supplied file name into a safe display
+// name: any directory part is dropped, control and formatting characters are
+// removed, the name is normalized to Unicode NFC and truncated to
+// MaxFilenameLength bytes, keeping the extension where possible
+func SanitizeFilename(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = norm.NFC.String(name)
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "file"
+	}
+
+	if len(name) > MaxFilenameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > MaxFilenameLength/2 {
+			ext = ""
+		}
+		base := name[:MaxFilenameLength-len(ext)]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+	return name
+}
+
 // GetFileSize returns the size of a file in bytes
 func GetFileSize(filePath string) (int64, error) {
 	fileInfo, err := os.Stat(filePath)